@@ -1,31 +1,85 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/rancher/external-dns/utils"
 )
 
 const (
-	defaultNameTemplate = "%{{service_name}}.%{{stack_name}}.%{{environment_name}}"
+	defaultNameTemplate     = "%{{service_name}}.%{{stack_name}}.%{{environment_name}}"
+	defaultHostNameTemplate = "%{{host_name}}.hosts.%{{environment_name}}"
+	defaultPoolNameTemplate = "%{{pool_name}}.%{{environment_name}}"
+
+	// PolicySync creates, updates and deletes records to match metadata
+	PolicySync = "sync"
+	// PolicyUpsertOnly creates and updates records but never deletes them
+	PolicyUpsertOnly = "upsert-only"
+	// PolicyCreateOnly creates records but never updates or deletes them
+	PolicyCreateOnly = "create-only"
 )
 
 var (
-	RootDomainName  string
+	// RootDomainName is the default zone that service names are created in
+	RootDomainName string
+	// RootDomainNames are all the zones managed by the provider
+	RootDomainNames []string
 	TTL             int
 	CattleURL       string
 	CattleAccessKey string
 	CattleSecretKey string
 	NameTemplate    string
+
+	Policy string
+	// MaxDeletions is the maximum number of records that may be deleted
+	// in a single update cycle. Zero disables the limit.
+	MaxDeletions int
+	// MaxDeletionsPercent is the maximum percentage of the owned records
+	// that may be deleted in a single update cycle. Zero disables the limit.
+	MaxDeletionsPercent int
+	// ProtectedRecords is a list of FQDN patterns that are never modified
+	ProtectedRecords []string
+
+	// StackInclude and StackExclude are lists of stack name patterns
+	// that services must and must not belong to
+	StackInclude []string
+	StackExclude []string
+	// ServiceSelector is a list of 'key=value', 'key!=value', 'key'
+	// or '!key' terms that service labels must all match
+	ServiceSelector []string
+	// FqdnInclude and FqdnExclude are regular expressions that
+	// record FQDNs must and must not match
+	FqdnInclude *regexp.Regexp
+	FqdnExclude *regexp.Regexp
+	// SkipSystemStacks excludes services of Rancher system stacks
+	SkipSystemStacks bool
+
+	// HostRecords enables records for the hosts and host pools
+	HostRecords      bool
+	HostNameTemplate string
+	PoolNameTemplate string
+
+	// StaticRecordsFile is the path of a YAML file declaring additional records
+	StaticRecordsFile string
 )
 
 func SetFromEnvironment() {
-	CattleURL = getEnv("CATTLE_URL")
-	CattleAccessKey = getEnv("CATTLE_ACCESS_KEY")
-	CattleSecretKey = getEnv("CATTLE_SECRET_KEY")
-	RootDomainName = utils.Fqdn(getEnv("ROOT_DOMAIN"))
+	// Cattle is only notified of service FQDNs if it is configured
+	CattleURL = os.Getenv("CATTLE_URL")
+	if len(CattleURL) > 0 {
+		CattleAccessKey = getEnv("CATTLE_ACCESS_KEY")
+		CattleSecretKey = getEnv("CATTLE_SECRET_KEY")
+	}
+	RootDomainNames = parseDomainList(getEnv("ROOT_DOMAIN"))
+	if len(RootDomainNames) == 0 {
+		logrus.Fatalf("Environment variable 'ROOT_DOMAIN' does not contain a domain name")
+	}
+	RootDomainName = RootDomainNames[0]
 	NameTemplate = os.Getenv("NAME_TEMPLATE")
 	if len(NameTemplate) == 0 {
 		NameTemplate = defaultNameTemplate
@@ -38,6 +92,40 @@ func SetFromEnvironment() {
 	} else {
 		TTL = i
 	}
+
+	if err := SetPolicy(os.Getenv("POLICY")); err != nil {
+		logrus.Fatalf("Invalid value for POLICY: %v", err)
+	}
+
+	MaxDeletions = getEnvInt("MAX_DELETIONS", 0)
+	MaxDeletionsPercent = getEnvInt("MAX_DELETIONS_PERCENT", 0)
+	if MaxDeletionsPercent < 0 || MaxDeletionsPercent > 100 {
+		logrus.Fatalf("MAX_DELETIONS_PERCENT must be between 0 and 100")
+	}
+
+	ProtectedRecords = nil
+	for _, pattern := range getEnvList("PROTECTED_RECORDS") {
+		ProtectedRecords = append(ProtectedRecords, utils.Fqdn(strings.ToLower(pattern)))
+	}
+
+	StackInclude = getEnvList("STACK_INCLUDE")
+	StackExclude = getEnvList("STACK_EXCLUDE")
+	ServiceSelector = getEnvList("SERVICE_SELECTOR")
+	FqdnInclude = getEnvRegexp("FQDN_INCLUDE")
+	FqdnExclude = getEnvRegexp("FQDN_EXCLUDE")
+	SkipSystemStacks = getEnvBool("SKIP_SYSTEM_STACKS", false)
+
+	HostRecords = getEnvBool("HOST_RECORDS", false)
+	HostNameTemplate = os.Getenv("HOST_NAME_TEMPLATE")
+	if len(HostNameTemplate) == 0 {
+		HostNameTemplate = defaultHostNameTemplate
+	}
+	PoolNameTemplate = os.Getenv("POOL_NAME_TEMPLATE")
+	if len(PoolNameTemplate) == 0 {
+		PoolNameTemplate = defaultPoolNameTemplate
+	}
+
+	StaticRecordsFile = os.Getenv("STATIC_RECORDS_FILE")
 }
 
 func getEnv(name string) string {
@@ -47,3 +135,102 @@ func getEnv(name string) string {
 	}
 	return envVar
 }
+
+// InstanceRootDomainNames returns the zones managed by the named provider
+// instance as set in <NAME>_ROOT_DOMAIN. They must be a subset of the
+// zones in ROOT_DOMAIN, which are returned if the variable is not set.
+func InstanceRootDomainNames(instance string) ([]string, error) {
+	envName := strings.ToUpper(strings.Replace(instance, "-", "_", -1)) + "_ROOT_DOMAIN"
+	envVar := os.Getenv(envName)
+	if len(envVar) == 0 {
+		return RootDomainNames, nil
+	}
+
+	names := parseDomainList(envVar)
+	for _, name := range names {
+		found := false
+		for _, rootDomainName := range RootDomainNames {
+			if name == rootDomainName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s: domain '%s' is not in ROOT_DOMAIN", envName, name)
+		}
+	}
+	return names, nil
+}
+
+// SetPolicy sets the policy applied when updating the provider.
+// An empty value selects the default policy.
+func SetPolicy(policy string) error {
+	switch policy {
+	case "":
+		Policy = PolicySync
+	case PolicySync, PolicyUpsertOnly, PolicyCreateOnly:
+		Policy = policy
+	default:
+		return fmt.Errorf("Unknown policy '%s'", policy)
+	}
+	return nil
+}
+
+func getEnvInt(name string, defaultValue int) int {
+	envVar := os.Getenv(name)
+	if len(envVar) == 0 {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(envVar)
+	if err != nil || i < 0 {
+		logrus.Fatalf("Environment variable '%s' must be a non-negative integer", name)
+	}
+	return i
+}
+
+func getEnvBool(name string, defaultValue bool) bool {
+	envVar := os.Getenv(name)
+	if len(envVar) == 0 {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(envVar)
+	if err != nil {
+		logrus.Fatalf("Environment variable '%s' must be a boolean value", name)
+	}
+	return b
+}
+
+func getEnvRegexp(name string) *regexp.Regexp {
+	envVar := os.Getenv(name)
+	if len(envVar) == 0 {
+		return nil
+	}
+	re, err := regexp.Compile(envVar)
+	if err != nil {
+		logrus.Fatalf("Environment variable '%s' is not a valid regular expression: %v", name, err)
+	}
+	return re
+}
+
+// getEnvList splits a comma separated environment variable
+// into a list of trimmed, non-empty values.
+func getEnvList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// parseDomainList parses a comma separated list of domain names
+func parseDomainList(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			names = append(names, utils.Fqdn(strings.ToLower(name)))
+		}
+	}
+	return names
+}
@@ -2,31 +2,220 @@ package main
 
 import (
 	"fmt"
+	"path"
+	"reflect"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/rancher/external-dns/config"
+	"github.com/rancher/external-dns/metrics"
+	"github.com/rancher/external-dns/providers"
 	"github.com/rancher/external-dns/utils"
 )
 
-func UpdateProviderDnsRecords(metadataRecs map[string]utils.MetadataDnsRecord) ([]utils.MetadataDnsRecord, error) {
-	var updated []utils.MetadataDnsRecord
-	ourRecords, allRecords, err := getProviderDnsRecords()
+// managedTypes are the record types owned records are managed for.
+// Only the RRSets of the names and types listed in the state RRSets
+// are owned, other RRSets of an owned name are left alone.
+var managedTypes = map[string]struct{}{
+	"A":     {},
+	"AAAA":  {},
+	"CNAME": {},
+	"TXT":   {},
+	"MX":    {},
+	"SRV":   {},
+}
+
+var recordChanges = metrics.NewCounter("external_dns_record_changes_total",
+	"Number of DNS records changed by provider instance and operation", "instance", "operation")
+
+var refusedUpdates = metrics.NewCounter("external_dns_refused_updates_total",
+	"Number of updates refused for exceeding a deletion limit by provider instance and limit", "instance", "limit")
+
+// UpdateProviderDnsRecords changes the records of the provider to match
+// the metadata records. The updated records are returned by
+// WaitForChanges once their changes propagated.
+func (p *providerInstance) UpdateProviderDnsRecords(metadataRecs map[string]utils.MetadataDnsRecord) error {
+	ourRecords, allRecords, err := p.getProviderDnsRecords()
 	if err != nil {
-		return nil, fmt.Errorf("Provider error reading dns entries: %v", err)
+		return fmt.Errorf("Provider error reading dns entries: %v", err)
+	}
+	logrus.Debugf("DNS records from provider %s: %v", p.name, ourRecords)
+
+	desiredRecs := p.desiredRecords(p.instanceRecords(metadataRecs), ourRecords, allRecords)
+
+	if err := p.checkDeletionLimits(desiredRecs, ourRecords); err != nil {
+		return err
 	}
-	logrus.Debugf("DNS records from provider: %v", ourRecords)
 
-	removeExtraRecords(metadataRecs, ourRecords)
+	p.removeExtraRecords(desiredRecs, ourRecords)
+
+	p.unresolvedRecords = append(p.unresolvedRecords, p.addMissingRecords(desiredRecs, allRecords)...)
 
-	updated = append(updated, addMissingRecords(metadataRecs, allRecords)...)
+	p.unresolvedRecords = append(p.unresolvedRecords, p.updateExistingRecords(desiredRecs, allRecords)...)
 
-	updated = append(updated, updateExistingRecords(metadataRecs, allRecords)...)
+	return nil
+}
 
+// WaitForChanges waits for the changes of the provider to propagate
+// and returns the records updated since. If they did not propagate the
+// records are held back and returned by a later call.
+func (p *providerInstance) WaitForChanges() ([]utils.MetadataDnsRecord, error) {
+	if waiter, ok := p.provider.(providers.ChangeWaiter); ok {
+		if err := waiter.WaitForChanges(); err != nil {
+			return nil, fmt.Errorf("Provider changes did not propagate: %v", err)
+		}
+	}
+
+	updated := p.unresolvedRecords
+	p.unresolvedRecords = nil
 	return updated, nil
 }
 
-func addMissingRecords(metadataRecs map[string]utils.MetadataDnsRecord, providerRecs map[string]utils.DnsRecord) []utils.MetadataDnsRecord {
+// desiredRecords returns the records the provider should hold after
+// the update, keyed by utils.RecordKey. Protected records are pinned
+// to their current provider values, as are owned records missing from
+// metadata if the policy forbids deleting them and existing records if
+// the policy forbids modifying them. The state RRSet of each zone lists
+// the FQDNs and types of all desired records in that zone.
+func (p *providerInstance) desiredRecords(metadataRecs map[string]utils.MetadataDnsRecord, ourRecords, allRecords map[string]utils.DnsRecord) map[string]utils.MetadataDnsRecord {
+	desiredRecs := make(map[string]utils.MetadataDnsRecord, len(metadataRecs)+1)
+	for key, rec := range metadataRecs {
+		// RRSets selected by a routing policy are identified by
+		// the environment so that several environments can each
+		// publish their own RRSet under the same name
+		if rec.DnsRecord.RoutingPolicy != nil {
+			rec.DnsRecord.SetIdentifier = environmentUUID()
+			key = utils.RecordKey(rec.DnsRecord)
+		}
+		desiredRecs[key] = rec
+	}
+
+	stateFqdns := p.getStateFqdns()
+	for key, rec := range ourRecords {
+		if _, ok := desiredRecs[key]; ok {
+			continue
+		}
+		if isStateRecord(rec, stateFqdns) {
+			continue
+		}
+		if config.Policy != config.PolicySync || isProtected(rec.Fqdn) {
+			logrus.Debugf("Keeping DNS record %s", key)
+			desiredRecs[key] = utils.MetadataDnsRecord{DnsRecord: rec}
+		}
+	}
+
+	for key, desiredRec := range desiredRecs {
+		fqdn := desiredRec.DnsRecord.Fqdn
+		rec, exists := allRecords[key]
+		if exists && (isProtected(fqdn) || config.Policy == config.PolicyCreateOnly) {
+			desiredRecs[key] = utils.MetadataDnsRecord{DnsRecord: rec}
+		} else if !exists && isProtected(fqdn) {
+			delete(desiredRecs, key)
+		}
+	}
+
+	zoneEntries := make(map[string]map[string]struct{})
+	for key, rec := range desiredRecs {
+		fqdn := rec.DnsRecord.Fqdn
+		zone := utils.ZoneForFqdn(fqdn, p.zones)
+		if zone == "" {
+			logrus.Warnf("Skipping DNS record %s: not in any of the zones %v", key, p.zones)
+			delete(desiredRecs, key)
+			continue
+		}
+		if _, ok := zoneEntries[zone]; !ok {
+			zoneEntries[zone] = make(map[string]struct{})
+		}
+		zoneEntries[zone][utils.StateEntry(fqdn, rec.DnsRecord.Type)] = struct{}{}
+	}
+
+	for zone, ourEntries := range zoneEntries {
+		stateFqdn := utils.StateFqdn(environmentUUID(), p.stateName, zone)
+		stateRec := utils.StateRecord(stateFqdn, config.TTL, ourEntries)
+		desiredRecs[utils.RecordKey(stateRec)] = utils.MetadataDnsRecord{DnsRecord: stateRec}
+	}
+
+	return desiredRecs
+}
+
+// getStateFqdns returns the FQDNs of the state RRSets of all managed zones.
+func (p *providerInstance) getStateFqdns() map[string]struct{} {
+	stateFqdns := make(map[string]struct{}, len(p.zones))
+	for _, zone := range p.zones {
+		stateFqdns[utils.StateFqdn(environmentUUID(), p.stateName, zone)] = struct{}{}
+	}
+	return stateFqdns
+}
+
+// environmentUUID returns the UUID of the environment
+// that owns the records in the state RRSets
+func environmentUUID() string {
+	_, envUUID := source.GetEnvironment()
+	return envUUID
+}
+
+// isStateRecord returns true if the record is one of the state RRSets
+func isStateRecord(rec utils.DnsRecord, stateFqdns map[string]struct{}) bool {
+	_, ok := stateFqdns[rec.Fqdn]
+	return ok && rec.Type == "TXT"
+}
+
+// isProtected returns true if the FQDN matches any of
+// the configured protected record patterns.
+func isProtected(fqdn string) bool {
+	for _, pattern := range config.ProtectedRecords {
+		if ok, _ := path.Match(pattern, fqdn); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDeletionLimits returns an error if the number of owned records
+// that would be deleted exceeds the configured limits. An alert is
+// raised once for each set of desired records that is refused.
+func (p *providerInstance) checkDeletionLimits(desiredRecs map[string]utils.MetadataDnsRecord, ourRecords map[string]utils.DnsRecord) error {
+	if config.MaxDeletions == 0 && config.MaxDeletionsPercent == 0 {
+		return nil
+	}
+
+	stateFqdns := p.getStateFqdns()
+	var owned, deletions int
+	for key, rec := range ourRecords {
+		if isStateRecord(rec, stateFqdns) {
+			continue
+		}
+		owned++
+		if _, ok := desiredRecs[key]; !ok {
+			deletions++
+		}
+	}
+
+	var limit, alert string
+	if config.MaxDeletions > 0 && deletions > config.MaxDeletions {
+		limit = "MAX_DELETIONS"
+		alert = fmt.Sprintf("Refusing to delete %d DNS records, the limit is %d per update",
+			deletions, config.MaxDeletions)
+	} else if config.MaxDeletionsPercent > 0 && deletions*100 > owned*config.MaxDeletionsPercent {
+		limit = "MAX_DELETIONS_PERCENT"
+		alert = fmt.Sprintf("Refusing to delete %d of %d DNS records, the limit is %d%% per update",
+			deletions, owned, config.MaxDeletionsPercent)
+	} else {
+		p.refusedRecords = nil
+		return nil
+	}
+
+	refusedUpdates.Inc(p.name, limit)
+	if reflect.DeepEqual(desiredRecs, p.refusedRecords) {
+		logrus.Debugf("%s: %s", p.name, alert)
+	} else {
+		logrus.Errorf("ALERT: %s: %s", p.name, alert)
+		p.refusedRecords = desiredRecs
+	}
+	return fmt.Errorf("Deletion of %d of %d records exceeds %s", deletions, owned, limit)
+}
+
+func (p *providerInstance) addMissingRecords(metadataRecs map[string]utils.MetadataDnsRecord, providerRecs map[string]utils.DnsRecord) []utils.MetadataDnsRecord {
 	var toAdd []utils.MetadataDnsRecord
 	for key := range metadataRecs {
 		if _, ok := providerRecs[key]; !ok {
@@ -39,30 +228,34 @@ func addMissingRecords(metadataRecs map[string]utils.MetadataDnsRecord, provider
 		logrus.Debugf("DNS records to add: %v", toAdd)
 	}
 
-	return updateRecords(toAdd, &Add)
+	return p.updateRecords(toAdd, &Add)
 }
 
-func updateRecords(toChange []utils.MetadataDnsRecord, op *Op) []utils.MetadataDnsRecord {
+func (p *providerInstance) updateRecords(toChange []utils.MetadataDnsRecord, op *Op) []utils.MetadataDnsRecord {
 	var changed []utils.MetadataDnsRecord
 	for _, value := range toChange {
 		switch *op {
 		case Add:
-			logrus.Infof("Adding dns record: %v", value)
-			if err := provider.AddRecord(value.DnsRecord); err != nil {
+			logrus.Infof("Adding dns record to %s: %v", p.name, value)
+			if err := p.provider.AddRecord(value.DnsRecord); err != nil {
 				logrus.Errorf("Failed to add DNS record to provider %v: %v", value, err)
 			} else {
+				recordChanges.Inc(p.name, op.Name)
 				changed = append(changed, value)
 			}
 		case Remove:
-			logrus.Infof("Removing dns record: %v", value)
-			if err := provider.RemoveRecord(value.DnsRecord); err != nil {
+			logrus.Infof("Removing dns record from %s: %v", p.name, value)
+			if err := p.provider.RemoveRecord(value.DnsRecord); err != nil {
 				logrus.Errorf("Failed to remove DNS record from provider %v: %v", value, err)
+			} else {
+				recordChanges.Inc(p.name, op.Name)
 			}
 		case Update:
-			logrus.Infof("Updating dns record: %v", value)
-			if err := provider.UpdateRecord(value.DnsRecord); err != nil {
+			logrus.Infof("Updating dns record in %s: %v", p.name, value)
+			if err := p.provider.UpdateRecord(value.DnsRecord); err != nil {
 				logrus.Errorf("Failed to update DNS record to provider %v: %v", value, err)
 			} else {
+				recordChanges.Inc(p.name, op.Name)
 				changed = append(changed, value)
 			}
 		}
@@ -70,37 +263,41 @@ func updateRecords(toChange []utils.MetadataDnsRecord, op *Op) []utils.MetadataD
 	return changed
 }
 
-func updateExistingRecords(metadataRecs map[string]utils.MetadataDnsRecord, providerRecs map[string]utils.DnsRecord) []utils.MetadataDnsRecord {
+func (p *providerInstance) updateExistingRecords(metadataRecs map[string]utils.MetadataDnsRecord, providerRecs map[string]utils.DnsRecord) []utils.MetadataDnsRecord {
 	var toUpdate []utils.MetadataDnsRecord
-	for key := range metadataRecs {
-		if _, ok := providerRecs[key]; ok {
-			metadataR := make(map[string]struct{}, len(metadataRecs[key].DnsRecord.Records))
-			for _, s := range metadataRecs[key].DnsRecord.Records {
-				metadataR[s] = struct{}{}
-			}
+	for key, metadataRec := range metadataRecs {
+		providerRec, ok := providerRecs[key]
+		if !ok {
+			continue
+		}
 
-			providerR := make(map[string]struct{}, len(providerRecs[key].Records))
-			for _, s := range providerRecs[key].Records {
-				providerR[s] = struct{}{}
-			}
-			var update bool
-			if len(metadataR) != len(providerR) {
-				update = true
-			} else {
-				for key := range metadataR {
-					if _, ok := providerR[key]; !ok {
-						update = true
-					}
-				}
-				for key := range providerR {
-					if _, ok := metadataR[key]; !ok {
-						update = true
-					}
-				}
-			}
-			if update {
-				toUpdate = append(toUpdate, metadataRecs[key])
-			}
+		// compare the records in the form the provider stores them
+		rules := providers.GetRecordRules(p.provider, metadataRec.DnsRecord.Fqdn)
+		desired := rules.Normalize(metadataRec.DnsRecord)
+		if desired.TTL != metadataRec.DnsRecord.TTL {
+			logrus.Debugf("TTL of DNS record %s is normalized from %d to %d by %s",
+				key, metadataRec.DnsRecord.TTL, desired.TTL, p.name)
+		}
+
+		actual := rules.Normalize(providerRec)
+		if !providers.SameRoutingPolicy(desired.RoutingPolicy, actual.RoutingPolicy) {
+			logrus.Debugf("Routing policy of DNS record %s differs", key)
+			toUpdate = append(toUpdate, metadataRec)
+		} else if desired.Proxied != actual.Proxied {
+			logrus.Debugf("Proxying of DNS record %s differs", key)
+			toUpdate = append(toUpdate, metadataRec)
+		} else if !providers.SameHealthCheck(desired.HealthCheck, actual.HealthCheck) {
+			logrus.Debugf("Health check of DNS record %s differs: %v != %v", key, actual.HealthCheck, desired.HealthCheck)
+			toUpdate = append(toUpdate, metadataRec)
+		} else if !providers.SameAlias(desired.Alias, actual.Alias) {
+			logrus.Debugf("Alias target of DNS record %s differs: %v != %v", key, actual.Alias, desired.Alias)
+			toUpdate = append(toUpdate, metadataRec)
+		} else if !sameValues(desired.Records, actual.Records) {
+			logrus.Debugf("Values of DNS record %s differ: %v != %v", key, providerRec.Records, desired.Records)
+			toUpdate = append(toUpdate, metadataRec)
+		} else if desired.TTL != providerRec.TTL {
+			logrus.Debugf("TTL of DNS record %s differs: %d != %d", key, providerRec.TTL, desired.TTL)
+			toUpdate = append(toUpdate, metadataRec)
 		}
 	}
 
@@ -110,14 +307,37 @@ func updateExistingRecords(metadataRecs map[string]utils.MetadataDnsRecord, prov
 		logrus.Debugf("DNS records to update: %v", toUpdate)
 	}
 
-	return updateRecords(toUpdate, &Update)
+	return p.updateRecords(toUpdate, &Update)
+}
+
+// sameValues returns true if both lists hold the same set of values
+func sameValues(a, b []string) bool {
+	setA := make(map[string]struct{}, len(a))
+	for _, s := range a {
+		setA[s] = struct{}{}
+	}
+
+	setB := make(map[string]struct{}, len(b))
+	for _, s := range b {
+		setB[s] = struct{}{}
+	}
+
+	if len(setA) != len(setB) {
+		return false
+	}
+	for s := range setA {
+		if _, ok := setB[s]; !ok {
+			return false
+		}
+	}
+	return true
 }
 
-func removeExtraRecords(metadataRecs map[string]utils.MetadataDnsRecord, providerRecs map[string]utils.DnsRecord) []utils.MetadataDnsRecord {
+func (p *providerInstance) removeExtraRecords(metadataRecs map[string]utils.MetadataDnsRecord, providerRecs map[string]utils.DnsRecord) []utils.MetadataDnsRecord {
 	var toRemove []utils.MetadataDnsRecord
 	for key := range providerRecs {
 		if _, ok := metadataRecs[key]; !ok {
-			toRemove = append(toRemove, utils.MetadataDnsRecord{"", "", providerRecs[key]})
+			toRemove = append(toRemove, utils.MetadataDnsRecord{DnsRecord: providerRecs[key]})
 		}
 	}
 
@@ -127,11 +347,11 @@ func removeExtraRecords(metadataRecs map[string]utils.MetadataDnsRecord, provide
 		logrus.Debugf("DNS records to remove: %v", toRemove)
 	}
 
-	return updateRecords(toRemove, &Remove)
+	return p.updateRecords(toRemove, &Remove)
 }
 
-func getProviderDnsRecords() (map[string]utils.DnsRecord, map[string]utils.DnsRecord, error) {
-	providerRecords, err := provider.GetRecords()
+func (p *providerInstance) getProviderDnsRecords() (map[string]utils.DnsRecord, map[string]utils.DnsRecord, error) {
+	providerRecords, err := p.provider.GetRecords()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -142,49 +362,99 @@ func getProviderDnsRecords() (map[string]utils.DnsRecord, map[string]utils.DnsRe
 		return ourRecords, allRecords, nil
 	}
 
-	stateFqdn := utils.StateFqdn(m.EnvironmentUUID, config.RootDomainName)
-	ourFqdns := make(map[string]struct{})
+	stateFqdns := p.getStateFqdns()
+	ourEntries := make(map[string]struct{})
+	// types listed in the state RRSets by owned FQDN
+	ourTypes := make(map[string][]string)
 
-	// Get the FQDNs that were created by us from the state RRSet
+	// Get the FQDNs and types that were created by us from the state RRSets
 	for _, rec := range providerRecords {
-		if rec.Fqdn == stateFqdn && rec.Type == "TXT" {
-			logrus.Debugf("FQDNs from state RRSet: %v", rec.Records)
+		if isStateRecord(rec, stateFqdns) {
+			logrus.Debugf("Entries of state RRSet %s: %v", rec.Fqdn, rec.Records)
 			for _, value := range rec.Records {
-				ourFqdns[value] = struct{}{}
+				fqdn, recordType := utils.ParseStateEntry(value)
+				ourEntries[utils.StateEntry(fqdn, recordType)] = struct{}{}
+				ourTypes[fqdn] = append(ourTypes[fqdn], recordType)
 			}
-			ourRecords[stateFqdn] = rec
-			break
+			ourRecords[utils.RecordKey(rec)] = rec
+			allRecords[utils.RecordKey(rec)] = rec
 		}
 	}
 
+	// RRSets with a set identifier are only owned
+	// if it is our environment's.
+	envUUID := environmentUUID()
+	var records []utils.DnsRecord
+	heldEntries := make(map[string]struct{})
 	for _, rec := range providerRecords {
-		if rec.Type == "A" {
-			allRecords[rec.Fqdn] = rec
-			if _, ok := ourFqdns[rec.Fqdn]; ok {
-				ourRecords[rec.Fqdn] = rec
+		if _, ok := managedTypes[rec.Type]; ok {
+			allRecords[utils.RecordKey(rec)] = rec
+			if rec.SetIdentifier != "" && rec.SetIdentifier != envUUID {
+				continue
 			}
+			records = append(records, rec)
+			heldEntries[utils.StateEntry(rec.Fqdn, rec.Type)] = struct{}{}
 		}
 	}
 
-	if stateRec, ok := ourRecords[stateFqdn]; ok {
-		allRecords[stateFqdn] = stateRec
+	for _, rec := range records {
+		if _, ok := ourEntries[utils.StateEntry(rec.Fqdn, rec.Type)]; ok {
+			ourRecords[utils.RecordKey(rec)] = rec
+		} else if ownedType := replacedType(rec, ourTypes[rec.Fqdn], heldEntries); ownedType != "" {
+			logrus.Infof("Type of owned DNS record %s changed from %s to %s", rec.Fqdn, ownedType, rec.Type)
+			ourRecords[utils.RecordKey(rec)] = rec
+		}
 	}
 
 	return ourRecords, allRecords, nil
 }
 
+// replacedType returns the owned type that the record replaced at its
+// FQDN, if its type was changed in the provider, so that the record is
+// replaced in turn. A record only replaces an owned type that the FQDN
+// no longer holds and that it cannot coexist with: either is a CNAME,
+// or both are address records.
+func replacedType(rec utils.DnsRecord, ownedTypes []string, heldEntries map[string]struct{}) string {
+	for _, ownedType := range ownedTypes {
+		if _, ok := heldEntries[utils.StateEntry(rec.Fqdn, ownedType)]; ok {
+			return ""
+		}
+	}
+	for _, ownedType := range ownedTypes {
+		if ownedType == "CNAME" || rec.Type == "CNAME" ||
+			(isAddressType(ownedType) && isAddressType(rec.Type)) {
+			return ownedType
+		}
+	}
+	return ""
+}
+
+func isAddressType(recordType string) bool {
+	return recordType == "A" || recordType == "AAAA"
+}
+
 // upgrade path from previous versions of external-dns.
 // checks for any pre-existing A records with names matching the legacy
 // suffix and TTLs matching the value of config.TTL. If any are found,
-// a state RRSet is created in the zone using the FQDNs of the records
-// as values.
-func EnsureUpgradeToStateRRSet() error {
-	allRecords, err := provider.GetRecords()
+// a state RRSet claiming the records is created in the zone.
+func (p *providerInstance) EnsureUpgradeToStateRRSet() error {
+	allRecords, err := p.provider.GetRecords()
 	if err != nil {
 		return err
 	}
 
-	stateFqdn := utils.StateFqdn(m.EnvironmentUUID, config.RootDomainName)
+	for _, zone := range p.zones {
+		if err := p.ensureZoneStateRRSet(zone, allRecords); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *providerInstance) ensureZoneStateRRSet(zone string, allRecords []utils.DnsRecord) error {
+	envName, envUUID := source.GetEnvironment()
+	stateFqdn := utils.StateFqdn(envUUID, p.stateName, zone)
 	logrus.Debugf("Checking for state RRSet %s", stateFqdn)
 	for _, rec := range allRecords {
 		if rec.Fqdn == stateFqdn && rec.Type == "TXT" {
@@ -194,20 +464,20 @@ func EnsureUpgradeToStateRRSet() error {
 	}
 
 	logrus.Debug("State RRSet not found")
-	ourFqdns := make(map[string]struct{})
+	ourEntries := make(map[string]struct{})
 	// records created by previous versions will match this suffix
-	joins := []string{m.EnvironmentName, config.RootDomainName}
+	joins := []string{envName, zone}
 	suffix := "." + strings.ToLower(strings.Join(joins, "."))
 	for _, rec := range allRecords {
 		if rec.Type == "A" && strings.HasSuffix(rec.Fqdn, suffix) && rec.TTL == config.TTL {
-			ourFqdns[rec.Fqdn] = struct{}{}
+			ourEntries[utils.StateEntry(rec.Fqdn, rec.Type)] = struct{}{}
 		}
 	}
 
-	if len(ourFqdns) > 0 {
-		logrus.Infof("Creating RRSet '%s TXT' for %d pre-existing records", stateFqdn, len(ourFqdns))
-		stateRec := utils.StateRecord(stateFqdn, config.TTL, ourFqdns)
-		if err := provider.AddRecord(stateRec); err != nil {
+	if len(ourEntries) > 0 {
+		logrus.Infof("Creating RRSet '%s TXT' for %d pre-existing records", stateFqdn, len(ourEntries))
+		stateRec := utils.StateRecord(stateFqdn, config.TTL, ourEntries)
+		if err := p.provider.AddRecord(stateRec); err != nil {
 			return fmt.Errorf("Failed to add RRSet to provider %v: %v", stateRec, err)
 		}
 	}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/rancher/external-dns/config"
+	"github.com/rancher/external-dns/providers"
+	"github.com/rancher/external-dns/utils"
+)
+
+const testEnvironmentUUID = "uuid"
+
+// testSource is a source of no records in the test environment
+type testSource struct{}
+
+func (testSource) Init() error                 { return nil }
+func (testSource) GetName() string             { return "test" }
+func (testSource) HealthCheck() error          { return nil }
+func (testSource) GetVersion() (string, error) { return "", nil }
+func (testSource) GetEnvironment() (string, string) {
+	return "env", testEnvironmentUUID
+}
+func (testSource) GetDnsRecords() (map[string]utils.MetadataDnsRecord, error) {
+	return nil, nil
+}
+
+// testProvider holds records in memory and logs the changes made
+type testProvider struct {
+	records []utils.DnsRecord
+	changes []string
+	owner   string
+}
+
+func (p *testProvider) Init(rootDomainNames []string) error { return nil }
+func (p *testProvider) GetName() string                     { return "test" }
+func (p *testProvider) HealthCheck() error                  { return nil }
+func (p *testProvider) SetOwner(owner string)               { p.owner = owner }
+
+func (p *testProvider) GetRecords() ([]utils.DnsRecord, error) {
+	return p.records, nil
+}
+
+func (p *testProvider) AddRecord(record utils.DnsRecord) error {
+	p.changes = append(p.changes, "add "+utils.RecordKey(record))
+	return nil
+}
+
+func (p *testProvider) UpdateRecord(record utils.DnsRecord) error {
+	p.changes = append(p.changes, "update "+utils.RecordKey(record))
+	return nil
+}
+
+func (p *testProvider) RemoveRecord(record utils.DnsRecord) error {
+	p.changes = append(p.changes, "remove "+utils.RecordKey(record))
+	return nil
+}
+
+func TestUpdateProviderDnsRecordsOwnership(t *testing.T) {
+	source = testSource{}
+	config.TTL = 300
+	config.Policy = config.PolicySync
+	stateFqdn := utils.StateFqdn(testEnvironmentUUID, "", "example.com.")
+
+	www := []utils.DnsRecord{
+		{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}},
+		{Fqdn: "www.example.com.", Type: "TXT", TTL: 300, Records: []string{"v=spf1 -all"}},
+		{Fqdn: "www.example.com.", Type: "MX", TTL: 300, Records: []string{"10 mail.example.com."}},
+	}
+
+	tests := []struct {
+		name        string
+		stateValues []string
+		// records held besides the state RRSet, www if not set
+		records []utils.DnsRecord
+		desired []utils.DnsRecord
+		changes []string
+	}{
+		{
+			name:        "foreign TXT and MX at an owned name are kept",
+			stateValues: []string{"A:www.example.com."},
+			desired: []utils.DnsRecord{
+				{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}},
+			},
+		},
+		{
+			name:        "owned records missing from metadata are removed",
+			stateValues: []string{"A:www.example.com.", "TXT:www.example.com."},
+			changes: []string{
+				"remove " + stateFqdn + " TXT",
+				"remove www.example.com. A",
+				"remove www.example.com. TXT",
+			},
+		},
+		{
+			name:        "state values without a type only claim A records",
+			stateValues: []string{"www.example.com."},
+			changes: []string{
+				"remove " + stateFqdn + " TXT",
+				"remove www.example.com. A",
+			},
+		},
+		{
+			name:        "legacy state values are rewritten with types",
+			stateValues: []string{"www.example.com."},
+			desired: []utils.DnsRecord{
+				{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}},
+				{Fqdn: "api.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.2"}},
+			},
+			changes: []string{
+				"add api.example.com. A",
+				"update " + stateFqdn + " TXT",
+			},
+		},
+		{
+			name:        "owned record changed to a CNAME is replaced",
+			stateValues: []string{"A:www.example.com."},
+			records: []utils.DnsRecord{
+				{Fqdn: "www.example.com.", Type: "CNAME", TTL: 300, Records: []string{"other.example.net."}},
+			},
+			desired: []utils.DnsRecord{
+				{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}},
+			},
+			changes: []string{
+				"add www.example.com. A",
+				"remove www.example.com. CNAME",
+			},
+		},
+		{
+			name:        "owned record changed to another address type is replaced",
+			stateValues: []string{"A:www.example.com."},
+			records: []utils.DnsRecord{
+				{Fqdn: "www.example.com.", Type: "AAAA", TTL: 300, Records: []string{"2001:db8::1"}},
+				{Fqdn: "www.example.com.", Type: "TXT", TTL: 300, Records: []string{"v=spf1 -all"}},
+			},
+			desired: []utils.DnsRecord{
+				{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}},
+			},
+			changes: []string{
+				"add www.example.com. A",
+				"remove www.example.com. AAAA",
+			},
+		},
+		{
+			name:        "foreign TXT at an owned name whose record was deleted is kept",
+			stateValues: []string{"A:www.example.com."},
+			records: []utils.DnsRecord{
+				{Fqdn: "www.example.com.", Type: "TXT", TTL: 300, Records: []string{"v=spf1 -all"}},
+			},
+			desired: []utils.DnsRecord{
+				{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}},
+			},
+			changes: []string{"add www.example.com. A"},
+		},
+	}
+
+	for _, test := range tests {
+		if test.records == nil {
+			test.records = www
+		}
+		provider := &testProvider{
+			records: append([]utils.DnsRecord{
+				{Fqdn: stateFqdn, Type: "TXT", TTL: 300, Records: test.stateValues},
+			}, test.records...),
+		}
+		instance := &providerInstance{
+			name:     "test",
+			provider: provider,
+			zones:    []string{"example.com."},
+		}
+
+		metadataRecs := make(map[string]utils.MetadataDnsRecord)
+		for _, rec := range test.desired {
+			metadataRecs[utils.RecordKey(rec)] = utils.MetadataDnsRecord{DnsRecord: rec}
+		}
+		config.RootDomainNames = instance.zones
+		if err := instance.UpdateProviderDnsRecords(metadataRecs); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		sort.Strings(provider.changes)
+		if !equalStrings(provider.changes, test.changes) {
+			t.Errorf("%s: got changes %v, want %v", test.name, provider.changes, test.changes)
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// rulesProvider is a test provider declaring record rules
+type rulesProvider struct {
+	testProvider
+	rules providers.RecordRules
+}
+
+func (p *rulesProvider) RecordRules(fqdn string) providers.RecordRules {
+	return p.rules
+}
+
+func TestUpdateProviderDnsRecordsDrift(t *testing.T) {
+	source = testSource{}
+	config.TTL = 300
+	config.Policy = config.PolicySync
+	config.RootDomainNames = []string{"example.com."}
+	stateFqdn := utils.StateFqdn(testEnvironmentUUID, "", "example.com.")
+
+	tests := []struct {
+		name     string
+		rules    providers.RecordRules
+		desired  utils.DnsRecord
+		provider utils.DnsRecord
+		changes  []string
+	}{
+		{
+			name:     "TTL clamped by the provider",
+			rules:    providers.RecordRules{MinTTL: 120},
+			desired:  utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 60, Records: []string{"10.0.0.1"}},
+			provider: utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 120, Records: []string{"10.0.0.1"}},
+		},
+		{
+			name:     "TTL changed in the provider",
+			rules:    providers.RecordRules{MinTTL: 120},
+			desired:  utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}},
+			provider: utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 3600, Records: []string{"10.0.0.1"}},
+			changes:  []string{"update www.example.com. A"},
+		},
+		{
+			name:     "value changed in the provider",
+			desired:  utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}},
+			provider: utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.2"}},
+			changes:  []string{"update www.example.com. A"},
+		},
+		{
+			name:     "case of the value changed by a case insensitive provider",
+			rules:    providers.RecordRules{CaseInsensitive: true},
+			desired:  utils.DnsRecord{Fqdn: "cdn.example.com.", Type: "CNAME", TTL: 300, Records: []string{"Origin.example.net."}},
+			provider: utils.DnsRecord{Fqdn: "cdn.example.com.", Type: "CNAME", TTL: 300, Records: []string{"origin.example.net."}},
+		},
+		{
+			name:     "CNAME target returned without trailing dot",
+			rules:    providers.RecordRules{CaseInsensitive: true},
+			desired:  utils.DnsRecord{Fqdn: "cdn.example.com.", Type: "CNAME", TTL: 300, Records: []string{"origin.example.net."}},
+			provider: utils.DnsRecord{Fqdn: "cdn.example.com.", Type: "CNAME", TTL: 300, Records: []string{"origin.example.net"}},
+		},
+		{
+			name:     "case of the value changed by a case sensitive provider",
+			desired:  utils.DnsRecord{Fqdn: "cdn.example.com.", Type: "CNAME", TTL: 300, Records: []string{"Origin.example.net."}},
+			provider: utils.DnsRecord{Fqdn: "cdn.example.com.", Type: "CNAME", TTL: 300, Records: []string{"origin.example.net."}},
+			changes:  []string{"update cdn.example.com. CNAME"},
+		},
+	}
+
+	for _, test := range tests {
+		state := utils.DnsRecord{Fqdn: stateFqdn, Type: "TXT", TTL: 300,
+			Records: []string{utils.StateEntry(test.desired.Fqdn, test.desired.Type)}}
+		provider := &rulesProvider{
+			testProvider: testProvider{records: []utils.DnsRecord{state, test.provider}},
+			rules:        test.rules,
+		}
+		instance := &providerInstance{name: "test", provider: provider, zones: config.RootDomainNames}
+		metadataRecs := map[string]utils.MetadataDnsRecord{
+			utils.RecordKey(test.desired): {DnsRecord: test.desired},
+		}
+
+		for cycle := 1; cycle <= 2; cycle++ {
+			provider.changes = nil
+			if err := instance.UpdateProviderDnsRecords(metadataRecs); err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+				break
+			}
+			if !equalStrings(provider.changes, test.changes) {
+				t.Errorf("%s: got changes %v, want %v", test.name, provider.changes, test.changes)
+			}
+			// the provider now holds the desired record
+			provider.records = []utils.DnsRecord{state, provider.rules.Normalize(test.desired)}
+			test.changes = nil
+		}
+	}
+}
+
+func TestCheckDeletionLimits(t *testing.T) {
+	source = testSource{}
+	defer func() { config.MaxDeletions, config.MaxDeletionsPercent = 0, 0 }()
+	stateFqdn := utils.StateFqdn(testEnvironmentUUID, "", "example.com.")
+
+	ourRecords := map[string]utils.DnsRecord{}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		rec := utils.DnsRecord{Fqdn: name + ".example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}}
+		ourRecords[utils.RecordKey(rec)] = rec
+	}
+	// the state RRSet is rewritten by every update and never counted
+	state := utils.DnsRecord{Fqdn: stateFqdn, Type: "TXT", TTL: 300}
+	ourRecords[utils.RecordKey(state)] = state
+
+	desired := func(names ...string) map[string]utils.MetadataDnsRecord {
+		recs := map[string]utils.MetadataDnsRecord{}
+		for _, name := range names {
+			rec := utils.DnsRecord{Fqdn: name + ".example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}}
+			recs[utils.RecordKey(rec)] = utils.MetadataDnsRecord{DnsRecord: rec}
+		}
+		return recs
+	}
+
+	tests := []struct {
+		name         string
+		maxDeletions int
+		maxPercent   int
+		desired      map[string]utils.MetadataDnsRecord
+		refused      bool
+	}{
+		{
+			name:    "no limits",
+			desired: desired(),
+		},
+		{
+			name:         "deletions at the count limit",
+			maxDeletions: 1,
+			desired:      desired("a", "b", "c"),
+		},
+		{
+			name:         "deletions above the count limit",
+			maxDeletions: 1,
+			desired:      desired("a", "b"),
+			refused:      true,
+		},
+		{
+			name:       "deletions at the percent limit",
+			maxPercent: 50,
+			desired:    desired("a", "b"),
+		},
+		{
+			name:       "deletions above the percent limit",
+			maxPercent: 50,
+			desired:    desired("a"),
+			refused:    true,
+		},
+		{
+			name:         "deletions within the count limit above the percent limit",
+			maxDeletions: 3,
+			maxPercent:   25,
+			desired:      desired("a", "b"),
+			refused:      true,
+		},
+	}
+
+	for _, test := range tests {
+		config.MaxDeletions, config.MaxDeletionsPercent = test.maxDeletions, test.maxPercent
+		instance := &providerInstance{name: "test", zones: []string{"example.com."}}
+		err := instance.checkDeletionLimits(test.desired, ourRecords)
+		if refused := err != nil; refused != test.refused {
+			t.Errorf("%s: got error %v, want refused %v", test.name, err, test.refused)
+		}
+	}
+}
+
+func TestCheckDeletionLimitsAlertsOnce(t *testing.T) {
+	source = testSource{}
+	config.MaxDeletions, config.MaxDeletionsPercent = 1, 0
+	defer func() { config.MaxDeletions = 0 }()
+
+	ourRecords := map[string]utils.DnsRecord{}
+	for _, name := range []string{"a", "b", "c"} {
+		rec := utils.DnsRecord{Fqdn: name + ".example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}}
+		ourRecords[utils.RecordKey(rec)] = rec
+	}
+	keep := func(rec utils.DnsRecord) map[string]utils.MetadataDnsRecord {
+		return map[string]utils.MetadataDnsRecord{utils.RecordKey(rec): {DnsRecord: rec}}
+	}
+	a := keep(ourRecords["a.example.com. A"])
+	b := keep(ourRecords["b.example.com. A"])
+
+	instance := &providerInstance{name: "test", zones: []string{"example.com."}}
+	for _, step := range []struct {
+		desired map[string]utils.MetadataDnsRecord
+		refused map[string]utils.MetadataDnsRecord
+	}{
+		{desired: a, refused: a},
+		// a repeated refusal leaves the alerted set as it is
+		{desired: a, refused: a},
+		// a changed set is alerted again
+		{desired: b, refused: b},
+		// an accepted update forgets the refusal
+		{desired: metadataRecords(ourRecords), refused: nil},
+	} {
+		instance.checkDeletionLimits(step.desired, ourRecords)
+		if !reflect.DeepEqual(instance.refusedRecords, step.refused) {
+			t.Errorf("got refused records %v, want %v", instance.refusedRecords, step.refused)
+		}
+	}
+}
+
+func metadataRecords(recs map[string]utils.DnsRecord) map[string]utils.MetadataDnsRecord {
+	metadataRecs := make(map[string]utils.MetadataDnsRecord, len(recs))
+	for key, rec := range recs {
+		metadataRecs[key] = utils.MetadataDnsRecord{DnsRecord: rec}
+	}
+	return metadataRecs
+}
+
+func TestUpdateProviderDnsRecordsProtected(t *testing.T) {
+	source = testSource{}
+	config.TTL = 300
+	config.Policy = config.PolicySync
+	config.RootDomainNames = []string{"example.com."}
+	config.ProtectedRecords = []string{"*.mail.example.com."}
+	defer func() { config.ProtectedRecords = nil }()
+	stateFqdn := utils.StateFqdn(testEnvironmentUUID, "", "example.com.")
+
+	provider := &testProvider{records: []utils.DnsRecord{
+		{Fqdn: stateFqdn, Type: "TXT", TTL: 300, Records: []string{
+			"A:www.example.com.", "A:mx1.mail.example.com.", "A:mx2.mail.example.com.",
+		}},
+		{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}},
+		{Fqdn: "mx1.mail.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.1.1"}},
+		{Fqdn: "mx2.mail.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.1.2"}},
+	}}
+	instance := &providerInstance{name: "test", provider: provider, zones: config.RootDomainNames}
+
+	metadataRecs := map[string]utils.MetadataDnsRecord{}
+	for _, rec := range []utils.DnsRecord{
+		{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.2"}},
+		// changed value of a protected record
+		{Fqdn: "mx1.mail.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.2.1"}},
+		// protected record missing in the provider
+		{Fqdn: "mx3.mail.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.2.3"}},
+	} {
+		metadataRecs[utils.RecordKey(rec)] = utils.MetadataDnsRecord{DnsRecord: rec}
+	}
+
+	// mx2 is missing from metadata but protected from removal
+	if err := instance.UpdateProviderDnsRecords(metadataRecs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"update www.example.com. A"}
+	if !equalStrings(provider.changes, want) {
+		t.Errorf("got changes %v, want %v", provider.changes, want)
+	}
+}
+
+func TestUpdateProviderDnsRecordsPolicy(t *testing.T) {
+	source = testSource{}
+	config.TTL = 300
+	config.RootDomainNames = []string{"example.com."}
+	defer func() { config.Policy = config.PolicySync }()
+	stateFqdn := utils.StateFqdn(testEnvironmentUUID, "", "example.com.")
+
+	desired := map[string]utils.MetadataDnsRecord{}
+	for _, rec := range []utils.DnsRecord{
+		{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.2"}},
+		{Fqdn: "new.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.3"}},
+	} {
+		desired[utils.RecordKey(rec)] = utils.MetadataDnsRecord{DnsRecord: rec}
+	}
+
+	tests := []struct {
+		policy  string
+		changes []string
+	}{
+		{
+			policy: config.PolicySync,
+			changes: []string{
+				"add new.example.com. A",
+				"remove old.example.com. A",
+				"update " + stateFqdn + " TXT",
+				"update www.example.com. A",
+			},
+		},
+		{
+			policy: config.PolicyUpsertOnly,
+			changes: []string{
+				"add new.example.com. A",
+				"update " + stateFqdn + " TXT",
+				"update www.example.com. A",
+			},
+		},
+		{
+			policy: config.PolicyCreateOnly,
+			changes: []string{
+				"add new.example.com. A",
+				"update " + stateFqdn + " TXT",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		config.Policy = test.policy
+		provider := &testProvider{records: []utils.DnsRecord{
+			{Fqdn: stateFqdn, Type: "TXT", TTL: 300, Records: []string{"A:www.example.com.", "A:old.example.com."}},
+			{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}},
+			{Fqdn: "old.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.4"}},
+		}}
+		instance := &providerInstance{name: "test", provider: provider, zones: config.RootDomainNames}
+		if err := instance.UpdateProviderDnsRecords(desired); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.policy, err)
+			continue
+		}
+
+		sort.Strings(provider.changes)
+		if !equalStrings(provider.changes, test.changes) {
+			t.Errorf("%s: got changes %v, want %v", test.policy, provider.changes, test.changes)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
+	"github.com/rancher/external-dns/metrics"
 	"net/http"
 )
 
@@ -13,29 +14,37 @@ var (
 
 func startHealthcheck() {
 	router.HandleFunc("/", healtcheck).Methods("GET", "HEAD").Name("Healthcheck")
+	router.Handle("/metrics", metrics.Handler()).Methods("GET").Name("Metrics")
 	logrus.Info("Healthcheck handler is listening on ", healtcheckPort)
 	logrus.Fatal(http.ListenAndServe(healtcheckPort, router))
 }
 
 func healtcheck(w http.ResponseWriter, req *http.Request) {
-	// 1) test metadata server
-	_, err := m.MetadataClient.GetSelfStack()
+	// 1) test the source
+	err := source.HealthCheck()
 	if err != nil {
-		logrus.Error("Healtcheck failed: unable to reach metadata")
-		http.Error(w, "Failed to reach metadata server", http.StatusInternalServerError)
+		logrus.Errorf("Healtcheck failed: unable to reach source %s: %v", source.GetName(), err)
+		http.Error(w, "Failed to reach the source", http.StatusInternalServerError)
 	} else {
-		// 2) test provider
-		err := provider.HealthCheck()
+		// 2) test providers
+		var err error
+		for _, instance := range instances {
+			if err = instance.provider.HealthCheck(); err != nil {
+				logrus.Errorf("Healtcheck failed: Error from provider %s: %v", instance.name, err)
+				break
+			}
+		}
 		if err != nil {
-			logrus.Errorf("Healtcheck failed: Error from provider: %v", err)
 			http.Error(w, "Failed to reach an external provider ", http.StatusInternalServerError)
-		} else {
+		} else if c != nil {
 			err := c.TestConnect()
 			if err != nil {
 				logrus.Error("Healtcheck failed: unable to reach Cattle")
 				http.Error(w, "Failed to connect to Cattle ", http.StatusInternalServerError)
 			}
 			w.Write([]byte("OK"))
+		} else {
+			w.Write([]byte("OK"))
 		}
 	}
 }
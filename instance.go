@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/external-dns/config"
+	"github.com/rancher/external-dns/providers"
+	"github.com/rancher/external-dns/utils"
+)
+
+// providerInstance is a named provider managing a set of zones.
+// Each instance is reconciled independently and owns its records
+// through its own state RRSets.
+type providerInstance struct {
+	name     string
+	provider providers.Provider
+	zones    []string
+	// stateName is the name the state RRSets of the instance are
+	// qualified with. It is empty for an instance without an explicit
+	// name, which keeps the state RRSets of a single provider setup.
+	stateName string
+	// unresolvedRecords are the records updated whose
+	// changes have not been seen to propagate yet
+	unresolvedRecords []utils.MetadataDnsRecord
+	// refusedRecords are the desired records of the last update
+	// refused for exceeding a deletion limit
+	refusedRecords map[string]utils.MetadataDnsRecord
+}
+
+// newProviderInstances creates the provider instances from a comma
+// separated list of 'name=provider' pairs. The name may be omitted
+// in which case the provider name is used as instance name.
+func newProviderInstances(spec string) ([]*providerInstance, error) {
+	var instances []*providerInstance
+	names := make(map[string]struct{})
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, providerName, stateName := entry, entry, ""
+		if parts := strings.SplitN(entry, "=", 2); len(parts) == 2 {
+			name, providerName = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+			stateName = name
+		}
+
+		if _, ok := names[name]; ok {
+			return nil, fmt.Errorf("Provider instance '%s' is configured twice", name)
+		}
+		names[name] = struct{}{}
+
+		zones, err := config.InstanceRootDomainNames(name)
+		if err != nil {
+			return nil, err
+		}
+
+		provider, err := providers.GetProvider(providerName, zones)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get provider '%s' for instance '%s': %v", providerName, name, err)
+		}
+
+		instance := &providerInstance{
+			name:      name,
+			provider:  provider,
+			zones:     zones,
+			stateName: stateName,
+		}
+		if setter, ok := provider.(providers.OwnerSetter); ok {
+			setter.SetOwner(instance.owner())
+		}
+
+		logrus.Infof("Configured provider instance '%s' using %s for zones %v", name, provider.GetName(), zones)
+		instances = append(instances, instance)
+	}
+
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("No provider configured")
+	}
+
+	return instances, nil
+}
+
+// owner identifies the instance as owner of the records in its state RRSets
+func (p *providerInstance) owner() string {
+	if p.stateName == "" {
+		return environmentUUID()
+	}
+	return p.stateName + "." + environmentUUID()
+}
+
+// instanceRecords returns the records published through the instance:
+// those in one of its zones that are not restricted to other instances.
+func (p *providerInstance) instanceRecords(metadataRecs map[string]utils.MetadataDnsRecord) map[string]utils.MetadataDnsRecord {
+	instanceRecs := make(map[string]utils.MetadataDnsRecord)
+	for key, rec := range metadataRecs {
+		zone := utils.ZoneForFqdn(rec.DnsRecord.Fqdn, config.RootDomainNames)
+		if zone == "" {
+			logrus.Warnf("Skipping DNS record %s: not in any of the zones %v", key, config.RootDomainNames)
+			continue
+		}
+		if !p.managesZone(zone) || !p.selectedBy(rec) {
+			continue
+		}
+		rules := providers.GetRecordRules(p.provider, rec.DnsRecord.Fqdn)
+		if reason := rules.Unsupported(rec.DnsRecord); reason != "" {
+			logrus.Warnf("Skipping DNS record %s: %s by %s", key, reason, p.provider.GetName())
+			continue
+		}
+		visibility, ok := rules.Visibility(rec.DnsRecord)
+		if !ok {
+			logrus.Warnf("Skipping DNS record %s: %s has no %s zone for it", key, p.provider.GetName(), rec.DnsRecord.Visibility)
+			continue
+		}
+		if rec.DnsRecord.HealthCheck != nil && !rules.HealthChecks {
+			rec.DnsRecord.HealthCheck = nil
+		}
+		if rec.DnsRecord.Proxied && !rules.Proxied {
+			rec.DnsRecord.Proxied = false
+		}
+		if visibility != rec.DnsRecord.Visibility {
+			rec.DnsRecord.Visibility = visibility
+			key = utils.RecordKey(rec.DnsRecord)
+		}
+		instanceRecs[key] = rec
+	}
+	return instanceRecs
+}
+
+func (p *providerInstance) managesZone(zone string) bool {
+	for _, z := range p.zones {
+		if z == zone {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *providerInstance) selectedBy(rec utils.MetadataDnsRecord) bool {
+	if len(rec.Providers) == 0 {
+		return true
+	}
+	for _, name := range rec.Providers {
+		if name == p.name {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForChanges waits for the changes of all instances to propagate
+// and returns the updated records. The instances are waited for
+// concurrently. It returns false if changes of any instance did not
+// propagate, whose records are returned once they do.
+func waitForChanges(instances []*providerInstance) ([]utils.MetadataDnsRecord, bool) {
+	results := make([][]utils.MetadataDnsRecord, len(instances))
+	errs := make([]error, len(instances))
+	var wg sync.WaitGroup
+	for idx, instance := range instances {
+		wg.Add(1)
+		go func(idx int, instance *providerInstance) {
+			defer wg.Done()
+			results[idx], errs[idx] = instance.WaitForChanges()
+		}(idx, instance)
+	}
+	wg.Wait()
+
+	var updated []utils.MetadataDnsRecord
+	ok := true
+	for idx, instance := range instances {
+		if errs[idx] != nil {
+			logrus.Errorf("Failed to update provider %s with new DNS records: %v", instance.name, errs[idx])
+			ok = false
+			continue
+		}
+		updated = append(updated, results[idx]...)
+	}
+	return updated, ok
+}
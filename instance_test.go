@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/rancher/external-dns/config"
+	"github.com/rancher/external-dns/providers"
+	"github.com/rancher/external-dns/utils"
+)
+
+func init() {
+	providers.RegisterProvider("test", func() providers.Provider {
+		return &testProvider{}
+	})
+}
+
+func TestNewProviderInstances(t *testing.T) {
+	source = testSource{}
+	config.RootDomainNames = []string{"example.com.", "example.org."}
+	os.Setenv("INTERNAL_ROOT_DOMAIN", "example.org")
+	defer os.Unsetenv("INTERNAL_ROOT_DOMAIN")
+
+	type instance struct {
+		name      string
+		stateName string
+		owner     string
+		zones     []string
+	}
+
+	tests := []struct {
+		spec      string
+		instances []instance
+		err       bool
+	}{
+		{
+			spec:      "test",
+			instances: []instance{{"test", "", "uuid", config.RootDomainNames}},
+		},
+		{
+			spec: " public=test , internal = test ,",
+			instances: []instance{
+				{"public", "public", "public.uuid", config.RootDomainNames},
+				{"internal", "internal", "internal.uuid", []string{"example.org."}},
+			},
+		},
+		{spec: " , ", err: true},
+		{spec: "public=unknown", err: true},
+		{spec: "a=test,a=test", err: true},
+	}
+
+	for _, test := range tests {
+		instances, err := newProviderInstances(test.spec)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %d instances", test.spec, len(instances))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.spec, err)
+			continue
+		}
+		if len(instances) != len(test.instances) {
+			t.Errorf("%q: got %d instances, want %d", test.spec, len(instances), len(test.instances))
+			continue
+		}
+		for idx, i := range instances {
+			want := test.instances[idx]
+			owner := i.provider.(*testProvider).owner
+			if i.name != want.name || i.stateName != want.stateName || owner != want.owner || !equalStrings(i.zones, want.zones) {
+				t.Errorf("%q: got instance %s (state name %q, owner %q, zones %v), want %+v",
+					test.spec, i.name, i.stateName, owner, i.zones, want)
+			}
+		}
+	}
+}
+
+func TestProviderInstancesPublishIndependently(t *testing.T) {
+	source = testSource{}
+	config.TTL = 300
+	config.Policy = config.PolicySync
+	config.RootDomainNames = []string{"example.com.", "example.org."}
+
+	public := &providerInstance{name: "public", stateName: "public", provider: &testProvider{}, zones: config.RootDomainNames}
+	internal := &providerInstance{name: "internal", stateName: "internal", provider: &testProvider{}, zones: []string{"example.org."}}
+
+	metadataRecs := make(map[string]utils.MetadataDnsRecord)
+	for _, rec := range []utils.MetadataDnsRecord{
+		{DnsRecord: utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}}},
+		{DnsRecord: utils.DnsRecord{Fqdn: "api.example.org.", Type: "A", TTL: 300, Records: []string{"10.0.0.2"}}},
+		{Providers: []string{"internal"},
+			DnsRecord: utils.DnsRecord{Fqdn: "db.example.org.", Type: "A", TTL: 300, Records: []string{"10.0.0.3"}}},
+		// restricted to an instance not managing the zone
+		{Providers: []string{"internal"},
+			DnsRecord: utils.DnsRecord{Fqdn: "jobs.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.4"}}},
+		{DnsRecord: utils.DnsRecord{Fqdn: "www.example.net.", Type: "A", TTL: 300, Records: []string{"10.0.0.5"}}},
+	} {
+		metadataRecs[utils.RecordKey(rec.DnsRecord)] = rec
+	}
+
+	tests := []struct {
+		instance *providerInstance
+		changes  []string
+	}{
+		{public, []string{
+			"add api.example.org. A",
+			"add public.external-dns-uuid.example.com. TXT",
+			"add public.external-dns-uuid.example.org. TXT",
+			"add www.example.com. A",
+		}},
+		{internal, []string{
+			"add api.example.org. A",
+			"add db.example.org. A",
+			"add internal.external-dns-uuid.example.org. TXT",
+		}},
+	}
+
+	for _, test := range tests {
+		if err := test.instance.UpdateProviderDnsRecords(metadataRecs); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.instance.name, err)
+			continue
+		}
+		changes := test.instance.provider.(*testProvider).changes
+		sort.Strings(changes)
+		if !equalStrings(changes, test.changes) {
+			t.Errorf("%s: got changes %v, want %v", test.instance.name, changes, test.changes)
+		}
+	}
+}
+
+// waitingProvider is a test provider whose changes
+// propagate once propagated is set
+type waitingProvider struct {
+	testProvider
+	propagated bool
+}
+
+func (p *waitingProvider) WaitForChanges() error {
+	if !p.propagated {
+		return errors.New("changes pending")
+	}
+	return nil
+}
+
+func TestWaitForChanges(t *testing.T) {
+	record := func(fqdn string) utils.MetadataDnsRecord {
+		return utils.MetadataDnsRecord{ServiceName: fqdn, DnsRecord: utils.DnsRecord{Fqdn: fqdn, Type: "A"}}
+	}
+	slow := &waitingProvider{}
+	instances := []*providerInstance{
+		{name: "fast", provider: &testProvider{}, unresolvedRecords: []utils.MetadataDnsRecord{record("www.example.com.")}},
+		{name: "slow", provider: slow, unresolvedRecords: []utils.MetadataDnsRecord{record("www.example.org.")}},
+	}
+
+	updated, ok := waitForChanges(instances)
+	if ok || len(updated) != 1 || updated[0].DnsRecord.Fqdn != "www.example.com." {
+		t.Errorf("got updated records %v (%v), want those of the fast instance", updated, ok)
+	}
+
+	// the records of the slow instance are returned once its changes propagate
+	instances[1].unresolvedRecords = append(instances[1].unresolvedRecords, record("api.example.org."))
+	slow.propagated = true
+	updated, ok = waitForChanges(instances)
+	if !ok || len(updated) != 2 || updated[0].DnsRecord.Fqdn != "www.example.org." || updated[1].DnsRecord.Fqdn != "api.example.org." {
+		t.Errorf("got updated records %v (%v), want those of the slow instance", updated, ok)
+	}
+}
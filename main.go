@@ -8,8 +8,7 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/rancher/external-dns/config"
-	"github.com/rancher/external-dns/metadata"
-	"github.com/rancher/external-dns/providers"
+	_ "github.com/rancher/external-dns/metadata"
 	_ "github.com/rancher/external-dns/providers/alidns"
 	_ "github.com/rancher/external-dns/providers/cloudflare"
 	_ "github.com/rancher/external-dns/providers/digitalocean"
@@ -21,6 +20,10 @@ import (
 	_ "github.com/rancher/external-dns/providers/powerdns"
 	_ "github.com/rancher/external-dns/providers/rfc2136"
 	_ "github.com/rancher/external-dns/providers/route53"
+	"github.com/rancher/external-dns/sources"
+	_ "github.com/rancher/external-dns/sources/consul"
+	_ "github.com/rancher/external-dns/sources/docker"
+	_ "github.com/rancher/external-dns/sources/kubernetes"
 	"github.com/rancher/external-dns/utils"
 )
 
@@ -44,13 +47,15 @@ var (
 var Version string
 
 var (
-	providerName = flag.String("provider", "route53", "External provider name")
+	providerName = flag.String("provider", "route53", "External provider name, or comma separated list of 'name=provider' instances")
+	sourceName   = flag.String("source", "rancher", "Source of the DNS records: rancher, docker, kubernetes or consul")
+	policyName   = flag.String("policy", "", "Update policy: sync, upsert-only or create-only (default: $POLICY or sync)")
 	debug        = flag.Bool("debug", false, "Debug")
 	logFile      = flag.String("log", "", "Log file")
 
-	provider providers.Provider
-	m        *metadata.MetadataClient
-	c        *CattleClient
+	instances []*providerInstance
+	source    sources.Source
+	c         *CattleClient
 
 	metadataRecsCached = make(map[string]utils.MetadataDnsRecord)
 )
@@ -74,24 +79,33 @@ func setEnv() {
 
 	// get config from environment variables
 	config.SetFromEnvironment()
+	if *policyName != "" {
+		if err := config.SetPolicy(*policyName); err != nil {
+			logrus.Fatalf("Invalid value for -policy: %v", err)
+		}
+	}
+	logrus.Infof("Using '%s' update policy", config.Policy)
 
 	var err error
-	// configure metadata client
-	m, err = metadata.NewMetadataClient()
+	// configure the source of the records
+	source, err = sources.GetSource(*sourceName)
 	if err != nil {
-		logrus.Fatalf("Failed to configure rancher-metadata client: %v", err)
+		logrus.Fatalf("Failed to configure source '%s': %v", *sourceName, err)
 	}
+	logrus.Infof("Using %s as source of DNS records", source.GetName())
 
 	//configure cattle client
-	c, err = NewCattleClient(config.CattleURL, config.CattleAccessKey, config.CattleSecretKey)
-	if err != nil {
-		logrus.Fatalf("Failed to configure cattle client: %v", err)
+	if config.CattleURL != "" {
+		c, err = NewCattleClient(config.CattleURL, config.CattleAccessKey, config.CattleSecretKey)
+		if err != nil {
+			logrus.Fatalf("Failed to configure cattle client: %v", err)
+		}
 	}
 
-	// get provider
-	provider, err = providers.GetProvider(*providerName, config.RootDomainName)
+	// get providers
+	instances, err = newProviderInstances(*providerName)
 	if err != nil {
-		logrus.Fatalf("Failed to get provider '%s': %v", *providerName, err)
+		logrus.Fatalf("Failed to configure providers '%s': %v", *providerName, err)
 	}
 }
 
@@ -100,8 +114,10 @@ func main() {
 	setEnv()
 
 	go startHealthcheck()
-	if err := EnsureUpgradeToStateRRSet(); err != nil {
-		logrus.Fatalf("Failed to ensure upgrade: %v", err)
+	for _, instance := range instances {
+		if err := instance.EnsureUpgradeToStateRRSet(); err != nil {
+			logrus.Fatalf("Failed to ensure upgrade of provider %s: %v", instance.name, err)
+		}
 	}
 
 	currentVersion := "init"
@@ -109,11 +125,14 @@ func main() {
 
 	for {
 		update, updateForced := false, false
-		newVersion, err := m.GetVersion()
+		newVersion, err := source.GetVersion()
 		if err != nil {
-			logrus.Errorf("Failed to get metadata version: %v", err)
-			goto sleep
-		} else if currentVersion != newVersion {
+			// keep the last version while the source reconnects,
+			// forced updates still run from the records it lists
+			logrus.Errorf("Failed to get source version: %v", err)
+			newVersion = currentVersion
+		}
+		if currentVersion != newVersion {
 			logrus.Debugf("Metadata version changed. Old: %s New: %s.", currentVersion, newVersion)
 			currentVersion = newVersion
 			update = true
@@ -126,10 +145,10 @@ func main() {
 		}
 
 		if update || updateForced {
-			// get records from metadata
-			metadataRecs, err := m.GetMetadataDnsRecords()
+			// get records from the source
+			metadataRecs, err := source.GetDnsRecords()
 			if err != nil {
-				logrus.Errorf("Failed to get DNS records from metadata: %v", err)
+				logrus.Errorf("Failed to get DNS records from %s: %v", source.GetName(), err)
 				goto sleep
 			}
 
@@ -140,16 +159,21 @@ func main() {
 			// allows us to check if the actual records have changed before
 			// querying the provider records.
 			if updateForced || !reflect.DeepEqual(metadataRecs, metadataRecsCached) {
-				// update the provider
-				updatedRecords, err := UpdateProviderDnsRecords(metadataRecs)
-				if err != nil {
-					logrus.Errorf("Failed to update provider with new DNS records: %v", err)
-					goto sleep
+				// update the providers independently of each other
+				failed := false
+				for _, instance := range instances {
+					if err := instance.UpdateProviderDnsRecords(metadataRecs); err != nil {
+						logrus.Errorf("Failed to update provider %s with new DNS records: %v", instance.name, err)
+						failed = true
+					}
 				}
 
+				updatedRecords, ok := waitForChanges(instances)
+				failed = failed || !ok
+
 				// update the service FQDN in Cattle
 				for _, mRec := range updatedRecords {
-					if mRec.ServiceName != "" && mRec.StackName != "" {
+					if c != nil && mRec.ServiceName != "" && mRec.StackName != "" {
 						logrus.Debugf("Updating cattle service FQDN for %s/%s", mRec.ServiceName, mRec.StackName)
 						if err := c.UpdateServiceDomainName(mRec); err != nil {
 							logrus.Errorf("Failed to update cattle service FQDN: %v", err)
@@ -157,6 +181,13 @@ func main() {
 					}
 				}
 
+				// failed updates are retried with the next change
+				// of the source or the next forced update
+				if failed {
+					lastUpdated = time.Now()
+					goto sleep
+				}
+
 				metadataRecsCached = metadataRecs
 				lastUpdated = time.Now()
 			} else {
@@ -2,24 +2,36 @@ package metadata
 
 import (
 	"fmt"
-	"net"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/rancher/external-dns/config"
+	"github.com/rancher/external-dns/sources"
 	"github.com/rancher/external-dns/utils"
 	"github.com/rancher/go-rancher-metadata/metadata"
 )
 
 const (
 	metadataUrl = "http://rancher-metadata.rancher.internal/2015-12-19"
+
+	// key of the static records in the metadata of the external-dns service
+	staticRecordsMetadataKey = "records"
 )
 
 type MetadataClient struct {
 	MetadataClient  metadata.Client
 	EnvironmentName string
 	EnvironmentUUID string
+	// last known-good record values of the services that fail open
+	knownGood sources.KnownGood
+}
+
+func init() {
+	sources.RegisterSource("rancher", func() sources.Source {
+		return &MetadataClient{}
+	})
 }
 
 func getEnvironment(m metadata.Client) (string, string, error) {
@@ -38,29 +50,41 @@ func getEnvironment(m metadata.Client) (string, string, error) {
 	return "", "", fmt.Errorf("Error reading stack info: %v", err)
 }
 
-func NewMetadataClient() (*MetadataClient, error) {
-	m, err := metadata.NewClientAndWait(metadataUrl)
+func (m *MetadataClient) Init() error {
+	client, err := metadata.NewClientAndWait(metadataUrl)
 	if err != nil {
-		logrus.Fatalf("Failed to configure rancher-metadata: %v", err)
+		return fmt.Errorf("Failed to configure rancher-metadata: %v", err)
 	}
 
-	envName, envUUID, err := getEnvironment(m)
+	envName, envUUID, err := getEnvironment(client)
 	if err != nil {
-		logrus.Fatalf("Error reading stack info: %v", err)
+		return err
 	}
 
-	return &MetadataClient{
-		MetadataClient:  m,
-		EnvironmentName: envName,
-		EnvironmentUUID: envUUID,
-	}, nil
+	m.MetadataClient = client
+	m.EnvironmentName = envName
+	m.EnvironmentUUID = envUUID
+	return nil
+}
+
+func (*MetadataClient) GetName() string {
+	return "Rancher"
+}
+
+func (m *MetadataClient) HealthCheck() error {
+	_, err := m.MetadataClient.GetSelfStack()
+	return err
 }
 
 func (m *MetadataClient) GetVersion() (string, error) {
 	return m.MetadataClient.GetVersion()
 }
 
-func (m *MetadataClient) GetMetadataDnsRecords() (map[string]utils.MetadataDnsRecord, error) {
+func (m *MetadataClient) GetEnvironment() (string, string) {
+	return m.EnvironmentName, m.EnvironmentUUID
+}
+
+func (m *MetadataClient) GetDnsRecords() (map[string]utils.MetadataDnsRecord, error) {
 	err := m.updateEnvironmentName()
 	if err != nil {
 		return nil, err
@@ -70,6 +94,16 @@ func (m *MetadataClient) GetMetadataDnsRecords() (map[string]utils.MetadataDnsRe
 	if err != nil {
 		return dnsEntries, err
 	}
+	if config.HostRecords {
+		err = m.getHostsDnsRecords(dnsEntries)
+		if err != nil {
+			return dnsEntries, err
+		}
+	}
+	err = m.getStaticDnsRecords(dnsEntries)
+	if err != nil {
+		return dnsEntries, err
+	}
 	return dnsEntries, nil
 }
 
@@ -79,99 +113,352 @@ func (m *MetadataClient) getContainersDnsRecords(dnsEntries map[string]utils.Met
 		return err
 	}
 
-	ourFqdns := make(map[string]struct{})
+	// Collect the record values of all services first
+	// so that service aliases can refer to them
+	serviceValues := make(map[string][]sources.RecordValue)
+	aliases := make(map[string]metadata.Service)
 	hostMeta := make(map[string]metadata.Host)
 	for _, service := range services {
+		key := sources.ServiceKey(service.StackName, service.Name)
+		switch service.Kind {
+		case "service", "loadBalancerService":
+			serviceValues[key] = m.getHealthyValues(service, hostMeta)
+		case "externalService":
+			serviceValues[key] = getExternalServiceValues(service)
+		case "dnsService":
+			aliases[key] = service
+		}
+	}
 
-		// Check for Service Label: io.rancher.service.external_dns
-		// Accepts 'always', 'auto' (default), or 'never'
-		policy, ok := service.Labels["io.rancher.service.external_dns"]
-		if !ok {
-			policy = "auto"
-		} else if policy == "never" {
-			logrus.Debugf("Service %v is Disabled", service.Name)
-			continue
+	m.knownGood.Rotate()
+
+	for key := range aliases {
+		resolveAliasValues(key, aliases, serviceValues, make(map[string]bool))
+	}
+
+	for _, service := range services {
+		values := serviceValues[sources.ServiceKey(service.StackName, service.Name)]
+		sources.AddServiceRecords(serviceLabels(service), service.Name, service.StackName, m.EnvironmentName,
+			service.System, values, dnsEntries)
+	}
+
+	return nil
+}
+
+// serviceLabels returns the labels of the service with the health check
+// label 'rancher' replaced by the health check of the service. The port
+// checked is the public port the container port is published on, if any.
+func serviceLabels(service metadata.Service) map[string]string {
+	if service.Labels["io.rancher.service.external_dns_health_check"] != "rancher" {
+		return service.Labels
+	}
+
+	labels := make(map[string]string, len(service.Labels))
+	for k, v := range service.Labels {
+		labels[k] = v
+	}
+	delete(labels, "io.rancher.service.external_dns_health_check")
+
+	check := service.HealthCheck
+	if check.Port == 0 {
+		logrus.Warnf("Service %s/%s has no health check to publish its records with", service.StackName, service.Name)
+		return labels
+	}
+
+	port := strconv.Itoa(check.Port)
+	for _, p := range service.Ports {
+		// [ip:]public:private[/protocol]
+		parts := strings.Split(strings.SplitN(p, "/", 2)[0], ":")
+		if len(parts) >= 2 && parts[len(parts)-1] == port {
+			port = parts[len(parts)-2]
+			break
+		}
+	}
+
+	// the request line is like 'GET "/healthz" "HTTP/1.0"'
+	if fields := strings.Fields(check.RequestLine); len(fields) > 0 {
+		path := fields[0]
+		if len(fields) > 1 {
+			path = fields[1]
+		}
+		path = strings.Trim(path, `"`)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
 		}
+		labels["io.rancher.service.external_dns_health_check"] = "HTTP:" + port + path
+	} else {
+		labels["io.rancher.service.external_dns_health_check"] = "TCP:" + port
+	}
+
+	return labels
+}
+
+// getHealthyValues returns the record values of the containers of the
+// service as selected by its health policy
+func (m *MetadataClient) getHealthyValues(service metadata.Service, hostMeta map[string]metadata.Host) []sources.RecordValue {
+	policy, err := sources.ParseHealthPolicy(service.Labels)
+	if err != nil {
+		logrus.Errorf("Skipping service %s/%s: %v", service.StackName, service.Name, err)
+		return nil
+	}
+
+	ipSource, err := sources.IPSource(service.Labels)
+	if err != nil {
+		logrus.Errorf("Skipping service %s/%s: %v", service.StackName, service.Name, err)
+		return nil
+	}
+
+	values, healthy := m.getContainerValues(service, hostMeta, policy, ipSource)
+	return m.knownGood.Filter(sources.ServiceKey(service.StackName, service.Name), policy, values, healthy)
+}
 
-		if service.Kind != "service" && service.Kind != "loadBalancerService" {
+// getContainerValues returns the external IPs of the containers of the
+// service in an included state as record values, along with the number
+// of healthy containers among them
+func (m *MetadataClient) getContainerValues(service metadata.Service, hostMeta map[string]metadata.Host,
+	policy sources.HealthPolicy, ipSource string) ([]sources.RecordValue, int) {
+	requirePorts := sources.RequirePorts(service.Labels, ipSource)
+
+	var values []sources.RecordValue
+	var healthy int
+	for _, container := range service.Containers {
+
+		if (len(container.Ports) == 0 && requirePorts) || !containerStateOK(container, policy) {
 			continue
 		}
 
-		for _, container := range service.Containers {
+		hostUUID := container.HostUUID
+		if len(hostUUID) == 0 {
+			logrus.Debugf("Container's %v host_uuid is empty", container.Name)
+			continue
+		}
 
-			if (len(container.Ports) == 0 && policy != "always") || !containerStateOK(container) {
+		var host metadata.Host
+		if _, ok := hostMeta[hostUUID]; ok {
+			host = hostMeta[hostUUID]
+		} else {
+			var err error
+			host, err = m.MetadataClient.GetHost(hostUUID)
+			if err != nil {
+				logrus.Warnf("Failed to get host metadata: %v", err)
 				continue
 			}
+			hostMeta[hostUUID] = host
+		}
 
-			hostUUID := container.HostUUID
-			if len(hostUUID) == 0 {
-				logrus.Debugf("Container's %v host_uuid is empty", container.Name)
+		// Check for Host Label: io.rancher.host.external_dns
+		// Accepts 'true' (default) or 'false'
+		if label, ok := host.Labels["io.rancher.host.external_dns"]; ok {
+			if label == "false" {
+				logrus.Debugf("Container %v Host %s is Disabled", container.Name, host.Name)
 				continue
 			}
+		}
 
-			var host metadata.Host
-			if _, ok := hostMeta[hostUUID]; ok {
-				host = hostMeta[hostUUID]
-			} else {
-				host, err = m.MetadataClient.GetHost(hostUUID)
-				if err != nil {
-					logrus.Warnf("Failed to get host metadata: %v", err)
-					continue
-				}
-				hostMeta[hostUUID] = host
+		for _, ip := range containerIPs(ipSource, service, container, host) {
+			value, ok := sources.AddressValue(ip)
+			if !ok {
+				logrus.Errorf("Skipping container %s: Invalid IP address %s", container.Name, ip)
+				continue
 			}
+			values = append(values, value)
+		}
 
-			// Check for Host Label: io.rancher.host.external_dns
-			// Accepts 'true' (default) or 'false'
-			if label, ok := host.Labels["io.rancher.host.external_dns"]; ok {
-				if label == "false" {
-					logrus.Debugf("Container %v Host %s is Disabled", container.Name, host.Name)
-					continue
-				}
-			}
+		if sources.Healthy(container.HealthState) {
+			healthy++
+		}
+	}
 
-			var externalIP string
-			if ip, ok := host.Labels["io.rancher.host.external_dns_ip"]; ok && len(ip) > 0 {
-				externalIP = ip
-			} else if len(container.Ports) > 0 {
-				if ip, ok := parsePortToIP(container.Ports[0]); ok {
-					externalIP = ip
-				}
-			}
+	return values, healthy
+}
 
-			// fallback to host agent IP
-			if len(externalIP) == 0 {
-				logrus.Debugf("Fallback to host.AgentIP %s for container %s", host.AgentIP, container.Name)
-				externalIP = host.AgentIP
+// containerIPs returns the addresses published for the container
+// as selected by the IP source of its service
+func containerIPs(ipSource string, service metadata.Service, container metadata.Container, host metadata.Host) []string {
+	switch {
+	case ipSource == "agent_ip":
+		return []string{host.AgentIP}
+	case strings.HasPrefix(ipSource, "host_label:"):
+		label := strings.TrimPrefix(ipSource, "host_label:")
+		if ip, ok := host.Labels[label]; ok && len(ip) > 0 {
+			return []string{ip}
+		}
+		logrus.Debugf("Host %s of container %s has no label %s", host.Name, container.Name, label)
+		return nil
+	case ipSource == "container_ip":
+		if len(container.PrimaryIp) > 0 {
+			return []string{container.PrimaryIp}
+		}
+		logrus.Debugf("Container %s has no primary IP", container.Name)
+		return nil
+	case ipSource == "ports":
+		var ips []string
+		for _, port := range container.Ports {
+			if ip, ok := parsePortToIP(port); ok {
+				ips = append(ips, ip)
 			}
+		}
+		if len(ips) == 0 {
+			logrus.Debugf("Fallback to host.AgentIP %s for container %s", host.AgentIP, container.Name)
+			ips = append(ips, host.AgentIP)
+		}
+		return ips
+	case ipSource == "vip":
+		if len(service.Vip) > 0 {
+			return []string{service.Vip}
+		}
+		logrus.Debugf("Service %s/%s has no VIP", service.StackName, service.Name)
+		return nil
+	}
 
-			if net.ParseIP(externalIP) == nil {
-				logrus.Errorf("Skipping container %s: Invalid IP address %s", container.Name, externalIP)
-			}
+	var externalIP string
+	if ip, ok := host.Labels["io.rancher.host.external_dns_ip"]; ok && len(ip) > 0 {
+		externalIP = ip
+	} else if len(container.Ports) > 0 {
+		if ip, ok := parsePortToIP(container.Ports[0]); ok {
+			externalIP = ip
+		}
+	}
 
-			nameTemplate, ok := service.Labels["io.rancher.service.external_dns_name_template"]
-			if !ok {
-				nameTemplate = config.NameTemplate
+	// fallback to host agent IP
+	if len(externalIP) == 0 {
+		logrus.Debugf("Fallback to host.AgentIP %s for container %s", host.AgentIP, container.Name)
+		externalIP = host.AgentIP
+	}
+
+	return []string{externalIP}
+}
+
+// getExternalServiceValues returns the external IPs of an external
+// service as A and AAAA record values, or its hostname as CNAME value
+func getExternalServiceValues(service metadata.Service) []sources.RecordValue {
+	var values []sources.RecordValue
+	for _, ip := range service.ExternalIps {
+		value, ok := sources.AddressValue(ip)
+		if !ok {
+			logrus.Errorf("Skipping external IP of service %s/%s: Invalid IP address %s",
+				service.StackName, service.Name, ip)
+			continue
+		}
+		values = append(values, value)
+	}
+
+	if len(values) == 0 && len(service.Hostname) > 0 {
+		values = append(values, sources.RecordValue{Type: "CNAME", Value: utils.Fqdn(strings.ToLower(service.Hostname))})
+	}
+
+	return values
+}
+
+// resolveAliasValues sets the values of the service alias to the union
+// of the values of its linked services, resolving linked aliases first.
+func resolveAliasValues(key string, aliases map[string]metadata.Service, serviceValues map[string][]sources.RecordValue, visiting map[string]bool) []sources.RecordValue {
+	if values, ok := serviceValues[key]; ok {
+		return values
+	}
+
+	alias, ok := aliases[key]
+	if !ok || visiting[key] {
+		return nil
+	}
+	visiting[key] = true
+
+	var values []sources.RecordValue
+	seen := make(map[sources.RecordValue]struct{})
+	for link := range alias.Links {
+		for _, value := range resolveAliasValues(link, aliases, serviceValues, visiting) {
+			if _, ok := seen[value]; !ok {
+				seen[value] = struct{}{}
+				values = append(values, value)
 			}
+		}
+	}
 
-			fqdn := utils.FqdnFromTemplate(nameTemplate, container.ServiceName, container.StackName,
-				m.EnvironmentName, config.RootDomainName)
+	serviceValues[key] = values
+	return values
+}
+
+// getHostsDnsRecords adds a record for each host and for each pool
+// the hosts are grouped in with the host label io.rancher.host.external_dns_pools
+func (m *MetadataClient) getHostsDnsRecords(dnsEntries map[string]utils.MetadataDnsRecord) error {
+	hosts, err := m.MetadataClient.GetHosts()
+	if err != nil {
+		return err
+	}
+
+	rootDomainName := utils.Fqdn(config.RootDomainName)
+	for _, host := range hosts {
+
+		// Check for Host Label: io.rancher.host.external_dns
+		// Accepts 'true' (default) or 'false'
+		if host.Labels["io.rancher.host.external_dns"] == "false" {
+			logrus.Debugf("Host %s is Disabled", host.Name)
+			continue
+		}
 
-			addToDnsEntries(fqdn, externalIP, container.ServiceName, container.StackName, dnsEntries)
-			ourFqdns[fqdn] = struct{}{}
+		ip := host.AgentIP
+		if label, ok := host.Labels["io.rancher.host.external_dns_ip"]; ok && len(label) > 0 {
+			ip = label
 		}
+
+		value, ok := sources.AddressValue(ip)
+		if !ok {
+			logrus.Errorf("Skipping host %s: Invalid IP address %s", host.Name, ip)
+			continue
+		}
+
+		hostName := host.Name
+		if len(hostName) == 0 {
+			hostName = strings.SplitN(host.Hostname, ".", 2)[0]
+		}
+
+		fqdns := []string{utils.FqdnFromTemplateValues(config.HostNameTemplate, map[string]string{
+			"host_name":        hostName,
+			"environment_name": m.EnvironmentName,
+		}, rootDomainName)}
+
+		// Check for Host Label: io.rancher.host.external_dns_pools
+		// Comma separated names of the pools the host belongs to
+		for _, pool := range sources.SplitLabel(host.Labels["io.rancher.host.external_dns_pools"]) {
+			fqdns = append(fqdns, utils.FqdnFromTemplateValues(config.PoolNameTemplate, map[string]string{
+				"pool_name":        pool,
+				"environment_name": m.EnvironmentName,
+			}, rootDomainName))
+		}
+
+		for _, fqdn := range fqdns {
+			if !sources.FqdnSelected(fqdn) {
+				logrus.Debugf("FQDN %s of host %s is filtered", fqdn, host.Name)
+				continue
+			}
+			sources.AddRecord(sources.Entry{Fqdn: fqdn, TTL: config.TTL}, value, dnsEntries)
+		}
+	}
+
+	return nil
+}
+
+// getStaticDnsRecords adds the records declared in the static records
+// file and in the metadata of the external-dns service
+func (m *MetadataClient) getStaticDnsRecords(dnsEntries map[string]utils.MetadataDnsRecord) error {
+	records, err := sources.ReadStaticRecordsFile()
+	if err != nil {
+		return err
 	}
 
-	if len(ourFqdns) > 0 {
-		stateFqdn := utils.StateFqdn(m.EnvironmentUUID, config.RootDomainName)
-		stateRec := utils.StateRecord(stateFqdn, config.TTL, ourFqdns)
-		dnsEntries[stateFqdn] = utils.MetadataDnsRecord{
-			ServiceName: "",
-			StackName:   "",
-			DnsRecord:   stateRec,
+	service, err := m.MetadataClient.GetSelfService()
+	if err != nil {
+		return fmt.Errorf("Failed to get self service metadata: %v", err)
+	}
+	if value, ok := service.Metadata[staticRecordsMetadataKey]; ok {
+		metaRecords, err := sources.ParseStaticRecords(value)
+		if err != nil {
+			return fmt.Errorf("Failed to parse static records from service metadata: %v", err)
 		}
+		records = append(records, metaRecords...)
 	}
 
+	sources.AddStaticRecords(records, dnsEntries)
 	return nil
 }
 
@@ -187,44 +474,16 @@ func (m *MetadataClient) updateEnvironmentName() error {
 	return nil
 }
 
-func addToDnsEntries(fqdn, ip, service, stack string, dnsEntries map[string]utils.MetadataDnsRecord) {
-	var records []string
-	if _, ok := dnsEntries[fqdn]; !ok {
-		records = []string{ip}
-	} else {
-		records = dnsEntries[fqdn].DnsRecord.Records
-		// skip if the records already have that IP
-		for _, val := range records {
-			if val == ip {
-				return
-			}
-		}
-		records = append(records, ip)
-	}
-
-	dnsEntries[fqdn] = utils.MetadataDnsRecord{
-		ServiceName: service,
-		StackName:   stack,
-		DnsRecord:   utils.DnsRecord{fqdn, records, "A", config.TTL},
-	}
-}
-
-func containerStateOK(container metadata.Container) bool {
+// containerStateOK returns true if the container is running and
+// in one of the health states included by the policy
+func containerStateOK(container metadata.Container, policy sources.HealthPolicy) bool {
 	switch container.State {
 	case "running":
 	default:
 		return false
 	}
 
-	switch container.HealthState {
-	case "healthy":
-	case "updating-healthy":
-	case "":
-	default:
-		return false
-	}
-
-	return true
+	return policy.Included(container.HealthState)
 }
 
 // expects port string as 'ip:publicPort:privatePort'
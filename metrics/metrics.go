@@ -0,0 +1,203 @@
+// Package metrics implements the few metrics external-dns exposes
+// in the Prometheus text format
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// metric is a registered metric that writes its samples
+type metric interface {
+	write(buf *bytes.Buffer)
+}
+
+var (
+	mu      sync.Mutex
+	metrics = make(map[string]metric)
+)
+
+func register(name string, m metric) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, exists := metrics[name]; exists {
+		logrus.Fatalf("Metric '%s' tried to register twice", name)
+	}
+	metrics[name] = m
+}
+
+// Handler returns a handler serving all registered metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		names := make([]string, 0, len(metrics))
+		for name := range metrics {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var buf bytes.Buffer
+		for _, name := range names {
+			metrics[name].write(&buf)
+		}
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buf.Bytes())
+	})
+}
+
+// Counter counts events by the values of its labels
+type Counter struct {
+	mu         sync.Mutex
+	name, help string
+	labelNames []string
+	values     map[string]float64
+}
+
+// NewCounter registers a counter with the given label names
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		values:     make(map[string]float64),
+	}
+	register(name, c)
+	return c
+}
+
+// Inc increments the counter of the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds the value to the counter of the label values
+func (c *Counter) Add(value float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[formatLabels(c.labelNames, labelValues)] += value
+}
+
+func (c *Counter) write(buf *bytes.Buffer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(buf, c.name, c.help, "counter")
+	for _, labels := range sortedKeys(c.values) {
+		fmt.Fprintf(buf, "%s%s %s\n", c.name, labels, formatValue(c.values[labels]))
+	}
+}
+
+// Histogram counts observed values in buckets by the values of its labels
+type Histogram struct {
+	mu         sync.Mutex
+	name, help string
+	labelNames []string
+	buckets    []float64
+	series     map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// NewHistogram registers a histogram with the given upper
+// bounds of its buckets, in increasing order, and label names
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*histogramSeries),
+	}
+	register(name, h)
+	return h
+}
+
+// Observe adds the value to the histogram of the label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := formatLabels(h.labelNames, labelValues)
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for idx, bound := range h.buckets {
+		if value <= bound {
+			s.counts[idx]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *Histogram) write(buf *bytes.Buffer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(buf, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	labelNames := append(append([]string{}, h.labelNames...), "le")
+	for _, key := range keys {
+		s := h.series[key]
+		for idx, bound := range h.buckets {
+			labels := formatLabels(labelNames, append(append([]string{}, s.labelValues...), formatValue(bound)))
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", h.name, labels, s.counts[idx])
+		}
+		labels := formatLabels(labelNames, append(append([]string{}, s.labelValues...), "+Inf"))
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", h.name, labels, s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", h.name, key, formatValue(s.sum))
+		fmt.Fprintf(buf, "%s_count%s %d\n", h.name, key, s.count)
+	}
+}
+
+func writeHeader(buf *bytes.Buffer, name, help, metricType string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, metricType)
+}
+
+// formatLabels returns the label set of the values, which
+// are matched to the label names by their position
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for idx, name := range names {
+		var value string
+		if idx < len(values) {
+			value = values[idx]
+		}
+		pairs[idx] = name + "=" + strconv.Quote(value)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
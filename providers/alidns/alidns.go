@@ -12,15 +12,17 @@ import (
 )
 
 type AlidnsProvider struct {
-	client         *api.Client
-	rootDomainName string
+	client          *api.Client
+	rootDomainNames []string
 }
 
 func init() {
-	providers.RegisterProvider("alidns", &AlidnsProvider{})
+	providers.RegisterProvider("alidns", func() providers.Provider {
+		return &AlidnsProvider{}
+	})
 }
 
-func (a *AlidnsProvider) Init(rootDomainName string) error {
+func (a *AlidnsProvider) Init(rootDomainNames []string) error {
 	accessKey := os.Getenv("ALICLOUD_ACCESS_KEY_ID")
 	if len(accessKey) == 0 {
 		return fmt.Errorf("ALICLOUD_ACCESS_KEY_ID is not set")
@@ -32,15 +34,17 @@ func (a *AlidnsProvider) Init(rootDomainName string) error {
 	}
 
 	a.client = api.NewClient(accessKey, secretKey)
-	a.rootDomainName = utils.UnFqdn(rootDomainName)
-
-	if _, err := a.client.DescribeDomainInfo(&api.DescribeDomainInfoArgs{
-		DomainName: a.rootDomainName,
-	}); err != nil {
-		return fmt.Errorf("Failed to describe root domain name for '%s': %v", a.rootDomainName, err)
+	a.rootDomainNames = make([]string, len(rootDomainNames))
+	for idx, rootDomainName := range rootDomainNames {
+		a.rootDomainNames[idx] = utils.UnFqdn(rootDomainName)
+		if _, err := a.client.DescribeDomainInfo(&api.DescribeDomainInfoArgs{
+			DomainName: a.rootDomainNames[idx],
+		}); err != nil {
+			return fmt.Errorf("Failed to describe root domain name for '%s': %v", a.rootDomainNames[idx], err)
+		}
 	}
 
-	logrus.Infof("Configured %s with zone '%s'", a.GetName(), a.rootDomainName)
+	logrus.Infof("Configured %s with zones %v", a.GetName(), a.rootDomainNames)
 	return nil
 }
 
@@ -48,121 +52,175 @@ func (a *AlidnsProvider) GetName() string {
 	return "AliDNS"
 }
 
+// Alidns holds the priority of MX records in a field of its own
+func (*AlidnsProvider) RecordRules(fqdn string) providers.RecordRules {
+	return providers.RecordRules{RecordTypes: providers.BasicRecordTypes}
+}
+
 func (a *AlidnsProvider) HealthCheck() error {
-	_, err := a.client.DescribeDomainInfo(&api.DescribeDomainInfoArgs{
-		DomainName: a.rootDomainName,
-	})
-	return err
+	for _, rootDomainName := range a.rootDomainNames {
+		if _, err := a.client.DescribeDomainInfo(&api.DescribeDomainInfoArgs{
+			DomainName: rootDomainName,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *AlidnsProvider) domainForRecord(record utils.DnsRecord) (string, error) {
+	domain := utils.ZoneForFqdn(utils.UnFqdn(record.Fqdn), a.rootDomainNames)
+	if domain == "" {
+		return "", fmt.Errorf("No domain configured for '%s'", record.Fqdn)
+	}
+	return domain, nil
 }
 
 func (a *AlidnsProvider) AddRecord(record utils.DnsRecord) error {
 	for _, rec := range record.Records {
-		r := a.prepareRecord(record, rec)
-		if _, err := a.client.AddDomainRecord(r); err != nil {
-			return fmt.Errorf("Alibaba Cloud API call has failed: %v", err)
+		if err := a.CreateValue(record, rec); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// UpdateRecord changes the records of the name and type in place
+// so that the name keeps resolving
 func (a *AlidnsProvider) UpdateRecord(record utils.DnsRecord) error {
-	if err := a.RemoveRecord(record); err != nil {
+	domain, err := a.domainForRecord(record)
+	if err != nil {
 		return err
 	}
 
-	return a.AddRecord(record)
+	records, err := a.findRecords(domain, record)
+	if err != nil {
+		return err
+	}
+
+	values := make([]providers.RecordValue, len(records))
+	for idx, rec := range records {
+		values[idx] = recordValue(domain, rec)
+	}
+
+	return providers.UpdateRecordValues(a, providers.GetRecordRules(a, record.Fqdn), values, record)
 }
 
 func (a *AlidnsProvider) RemoveRecord(record utils.DnsRecord) error {
-	records, err := a.findRecords(record)
+	domain, err := a.domainForRecord(record)
+	if err != nil {
+		return err
+	}
+
+	records, err := a.findRecords(domain, record)
 	if err != nil {
 		return err
 	}
 
 	for _, rec := range records {
-		if _, err := a.client.DeleteDomainRecord(&api.DeleteDomainRecordArgs{
-			RecordId: rec.RecordId,
-		}); err != nil {
-			return fmt.Errorf("Alibaba Cloud API call has failed: %v", err)
+		if err := a.DeleteValue(record, recordValue(domain, rec)); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (a *AlidnsProvider) GetRecords() ([]utils.DnsRecord, error) {
-	var records []utils.DnsRecord
-	result, err := a.client.DescribeDomainRecords(&api.DescribeDomainRecordsArgs{
-		DomainName: a.rootDomainName,
-	})
+func (a *AlidnsProvider) CreateValue(record utils.DnsRecord, value string) error {
+	domain, err := a.domainForRecord(record)
 	if err != nil {
-		return records, fmt.Errorf("Alibaba Cloud API call has failed: %v", err)
+		return err
 	}
 
-	recordMap := map[string]map[string][]string{}
-	recordTTLs := map[string]map[string]int{}
+	if _, err := a.client.AddDomainRecord(a.prepareRecord(domain, record, value)); err != nil {
+		return fmt.Errorf("Alibaba Cloud API call has failed: %v", err)
+	}
 
-	for _, rec := range result.DomainRecords.Record {
-		var fqdn string
-		if rec.RR == "" {
-			fqdn = a.rootDomainName + "."
-		} else {
-			fqdn = fmt.Sprintf("%s.%s.", rec.RR, a.rootDomainName)
-		}
+	return nil
+}
 
-		recordTTLs[fqdn] = map[string]int{}
-		recordTTLs[fqdn][rec.Type] = int(rec.TTL)
-		recordSet, exists := recordMap[fqdn]
-		if exists {
-			recordSlice, sliceExists := recordSet[rec.Type]
-			if sliceExists {
-				recordSlice = append(recordSlice, rec.Value)
-				recordSet[rec.Type] = recordSlice
-			} else {
-				recordSet[rec.Type] = []string{rec.Value}
-			}
-		} else {
-			recordMap[fqdn] = map[string][]string{}
-			recordMap[fqdn][rec.Type] = []string{rec.Value}
-		}
+func (a *AlidnsProvider) UpdateValue(record utils.DnsRecord, existing providers.RecordValue, value string) error {
+	domain, err := a.domainForRecord(record)
+	if err != nil {
+		return err
 	}
 
-	for fqdn, recordSet := range recordMap {
-		for recordType, recordSlice := range recordSet {
-			ttl := recordTTLs[fqdn][recordType]
-			record := utils.DnsRecord{Fqdn: fqdn, Records: recordSlice, Type: recordType, TTL: ttl}
-			records = append(records, record)
-		}
+	if _, err := a.client.UpdateDomainRecord(&api.UpdateDomainRecordArgs{
+		RecordId: existing.ID,
+		RR:       a.parseName(domain, record),
+		Type:     record.Type,
+		Value:    value,
+		TTL:      int32(record.TTL),
+	}); err != nil {
+		return fmt.Errorf("Alibaba Cloud API call has failed: %v", err)
+	}
+
+	return nil
+}
+
+func (a *AlidnsProvider) DeleteValue(record utils.DnsRecord, existing providers.RecordValue) error {
+	if _, err := a.client.DeleteDomainRecord(&api.DeleteDomainRecordArgs{
+		RecordId: existing.ID,
+	}); err != nil {
+		return fmt.Errorf("Alibaba Cloud API call has failed: %v", err)
 	}
 
+	return nil
+}
+
+func (a *AlidnsProvider) GetRecords() ([]utils.DnsRecord, error) {
+	var records []utils.DnsRecord
+	for _, domain := range a.rootDomainNames {
+		domainRecords, err := a.getDomainRecords(domain)
+		if err != nil {
+			return records, err
+		}
+		records = append(records, domainRecords...)
+	}
 	return records, nil
 }
 
-func (a *AlidnsProvider) parseName(record utils.DnsRecord) string {
-	return strings.TrimSuffix(record.Fqdn, fmt.Sprintf(".%s.", a.rootDomainName))
+func (a *AlidnsProvider) getDomainRecords(domain string) ([]utils.DnsRecord, error) {
+	result, err := a.client.DescribeDomainRecords(&api.DescribeDomainRecordsArgs{
+		DomainName: domain,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Alibaba Cloud API call has failed: %v", err)
+	}
+
+	values := make([]providers.RecordValue, len(result.DomainRecords.Record))
+	for idx, rec := range result.DomainRecords.Record {
+		values[idx] = recordValue(domain, rec)
+	}
+
+	return providers.GroupRecordValues(values), nil
+}
+
+func (a *AlidnsProvider) parseName(domain string, record utils.DnsRecord) string {
+	return strings.TrimSuffix(record.Fqdn, fmt.Sprintf(".%s.", domain))
 }
 
-func (a *AlidnsProvider) prepareRecord(record utils.DnsRecord, rec string) *api.AddDomainRecordArgs {
+func (a *AlidnsProvider) prepareRecord(domain string, record utils.DnsRecord, rec string) *api.AddDomainRecordArgs {
 	return &api.AddDomainRecordArgs{
-		DomainName: a.rootDomainName,
-		RR:         a.parseName(record),
+		DomainName: domain,
+		RR:         a.parseName(domain, record),
 		Type:       record.Type,
 		Value:      rec,
 		TTL:        int32(record.TTL),
 	}
 }
 
-func (a *AlidnsProvider) findRecords(record utils.DnsRecord) ([]api.RecordType, error) {
+func (a *AlidnsProvider) findRecords(domain string, record utils.DnsRecord) ([]api.RecordType, error) {
 	var records []api.RecordType
 	result, err := a.client.DescribeDomainRecords(&api.DescribeDomainRecordsArgs{
-		DomainName: a.rootDomainName,
+		DomainName: domain,
 	})
 	if err != nil {
 		return records, fmt.Errorf("Alibaba Cloud API call has failed: %v", err)
 	}
 
-	name := a.parseName(record)
+	name := a.parseName(domain, record)
 	for _, rec := range result.DomainRecords.Record {
 		if rec.RR == name && rec.Type == record.Type {
 			records = append(records, rec)
@@ -171,3 +229,18 @@ func (a *AlidnsProvider) findRecords(record utils.DnsRecord) ([]api.RecordType,
 
 	return records, nil
 }
+
+func recordValue(domain string, rec api.RecordType) providers.RecordValue {
+	fqdn := domain + "."
+	if rec.RR != "" {
+		fqdn = fmt.Sprintf("%s.%s.", rec.RR, domain)
+	}
+
+	return providers.RecordValue{
+		ID:    rec.RecordId,
+		Fqdn:  fqdn,
+		Type:  rec.Type,
+		TTL:   int(rec.TTL),
+		Value: rec.Value,
+	}
+}
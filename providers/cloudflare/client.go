@@ -0,0 +1,195 @@
+package cloudflare
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	apiBaseURL = "https://api.cloudflare.com/client/v4"
+	// largest page size the API accepts for DNS records
+	recordsPerPage = 100
+)
+
+// client is a minimal client of the Cloudflare v4 API. It authenticates
+// with a scoped API token or the global API key of an account.
+type client struct {
+	httpClient *http.Client
+	baseURL    string
+	token      string
+	email      string
+	key        string
+}
+
+type cfZone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type cfRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content,omitempty"`
+	TTL     int    `json:"ttl"`
+	Proxied bool   `json:"proxied"`
+	// Priority is the preference of MX records
+	Priority *int `json:"priority,omitempty"`
+	// Data holds the fields of SRV records
+	Data *cfSRVData `json:"data,omitempty"`
+}
+
+type cfSRVData struct {
+	Service  string `json:"service"`
+	Proto    string `json:"proto"`
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+	Weight   int    `json:"weight"`
+	Port     int    `json:"port"`
+	Target   string `json:"target"`
+}
+
+type cfResponse struct {
+	Success    bool            `json:"success"`
+	Errors     []cfError       `json:"errors"`
+	Result     json.RawMessage `json:"result"`
+	ResultInfo *struct {
+		Page       int `json:"page"`
+		TotalPages int `json:"total_pages"`
+	} `json:"result_info"`
+}
+
+type cfError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func newClient(token, email, key string) *client {
+	return &client{
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		baseURL:    apiBaseURL,
+		token:      token,
+		email:      email,
+		key:        key,
+	}
+}
+
+// do sends the request and decodes the result into the given value.
+// It returns the number of result pages.
+func (c *client) do(method, path string, query url.Values, body, result interface{}) (int, error) {
+	var reqBody io.Reader
+	if body != nil {
+		buf := new(bytes.Buffer)
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return 0, err
+		}
+		reqBody = buf
+	}
+
+	reqURL := c.baseURL + path
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, reqURL, reqBody)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else {
+		req.Header.Set("X-Auth-Email", c.email)
+		req.Header.Set("X-Auth-Key", c.key)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var response cfResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("Invalid response with status %s: %v", resp.Status, err)
+	}
+	if !response.Success {
+		if len(response.Errors) > 0 {
+			return 0, fmt.Errorf("%s (code %d)", response.Errors[0].Message, response.Errors[0].Code)
+		}
+		return 0, fmt.Errorf("Request failed with status %s", resp.Status)
+	}
+
+	if result != nil && len(response.Result) > 0 {
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			return 0, err
+		}
+	}
+
+	if response.ResultInfo == nil {
+		return 1, nil
+	}
+	return response.ResultInfo.TotalPages, nil
+}
+
+// findZone returns the zone of the given name
+func (c *client) findZone(name string) (*cfZone, error) {
+	var zones []cfZone
+	if _, err := c.do("GET", "/zones", url.Values{"name": {name}}, nil, &zones); err != nil {
+		return nil, err
+	}
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("Zone %s does not exist", name)
+	}
+	return &zones[0], nil
+}
+
+func (c *client) zoneDetails(zoneID string) error {
+	_, err := c.do("GET", "/zones/"+zoneID, nil, nil, nil)
+	return err
+}
+
+// listRecords returns all DNS records of the zone
+func (c *client) listRecords(zoneID string) ([]cfRecord, error) {
+	var records []cfRecord
+	for page, pages := 1, 1; page <= pages; page++ {
+		query := url.Values{
+			"page":     {strconv.Itoa(page)},
+			"per_page": {strconv.Itoa(recordsPerPage)},
+		}
+		var pageRecords []cfRecord
+		var err error
+		if pages, err = c.do("GET", "/zones/"+zoneID+"/dns_records", query, nil, &pageRecords); err != nil {
+			return nil, err
+		}
+		records = append(records, pageRecords...)
+	}
+	return records, nil
+}
+
+// createRecord creates the record and sets its ID
+func (c *client) createRecord(zoneID string, record *cfRecord) error {
+	var created cfRecord
+	if _, err := c.do("POST", "/zones/"+zoneID+"/dns_records", nil, record, &created); err != nil {
+		return err
+	}
+	record.ID = created.ID
+	return nil
+}
+
+// updateRecord replaces the record of the same ID
+func (c *client) updateRecord(zoneID string, record cfRecord) error {
+	_, err := c.do("PUT", "/zones/"+zoneID+"/dns_records/"+record.ID, nil, record, nil)
+	return err
+}
+
+func (c *client) deleteRecord(zoneID, recordID string) error {
+	_, err := c.do("DELETE", "/zones/"+zoneID+"/dns_records/"+recordID, nil, nil, nil)
+	return err
+}
@@ -0,0 +1,247 @@
+package cloudflare
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rancher/external-dns/utils"
+)
+
+// fakeAPI serves the DNS records of the zone example.com
+// with the given number of records per page
+type fakeAPI struct {
+	mu       sync.Mutex
+	pageSize int
+	records  []cfRecord
+	nextID   int
+	// headers of the requests received
+	headers []http.Header
+	// pages of DNS records listed
+	pages []string
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.headers = append(f.headers, req.Header)
+
+	const recordsPath = "/zones/z1/dns_records"
+	switch {
+	case req.URL.Path == "/zones":
+		writeResult(w, []cfZone{{ID: "z1", Name: req.URL.Query().Get("name")}}, nil)
+	case req.URL.Path == "/zones/z1":
+		writeResult(w, cfZone{ID: "z1", Name: "example.com"}, nil)
+	case req.URL.Path == recordsPath && req.Method == "GET":
+		page, _ := strconv.Atoi(req.URL.Query().Get("page"))
+		f.pages = append(f.pages, fmt.Sprintf("%d/%s", page, req.URL.Query().Get("per_page")))
+		start, end := (page-1)*f.pageSize, page*f.pageSize
+		if end > len(f.records) {
+			end = len(f.records)
+		}
+		totalPages := (len(f.records) + f.pageSize - 1) / f.pageSize
+		writeResult(w, f.records[start:end], &struct {
+			Page       int `json:"page"`
+			TotalPages int `json:"total_pages"`
+		}{page, totalPages})
+	case req.URL.Path == recordsPath && req.Method == "POST":
+		var rec cfRecord
+		json.NewDecoder(req.Body).Decode(&rec)
+		f.nextID++
+		rec.ID = fmt.Sprintf("r%d", f.nextID)
+		f.records = append(f.records, rec)
+		writeResult(w, rec, nil)
+	case strings.HasPrefix(req.URL.Path, recordsPath+"/"):
+		id := strings.TrimPrefix(req.URL.Path, recordsPath+"/")
+		var rec cfRecord
+		json.NewDecoder(req.Body).Decode(&rec)
+		for idx := range f.records {
+			if f.records[idx].ID != id {
+				continue
+			}
+			if req.Method == "DELETE" {
+				f.records = append(f.records[:idx], f.records[idx+1:]...)
+			} else {
+				f.records[idx] = rec
+			}
+			writeResult(w, map[string]string{"id": id}, nil)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"success":false,"errors":[{"code":81044,"message":"Record not found"}]}`)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func writeResult(w http.ResponseWriter, result, resultInfo interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"errors":      []cfError{},
+		"result":      result,
+		"result_info": resultInfo,
+	})
+}
+
+func newTestClient(api *fakeAPI, token, email, key string) (*client, func()) {
+	server := httptest.NewServer(api)
+	c := newClient(token, email, key)
+	c.baseURL = server.URL
+	return c, server.Close
+}
+
+func TestClientAuthentication(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		email, key string
+		want       map[string]string
+	}{
+		{
+			name:  "API token",
+			token: "secret-token",
+			want:  map[string]string{"Authorization": "Bearer secret-token", "X-Auth-Email": "", "X-Auth-Key": ""},
+		},
+		{
+			name:  "global API key",
+			email: "admin@example.com",
+			key:   "secret-key",
+			want:  map[string]string{"Authorization": "", "X-Auth-Email": "admin@example.com", "X-Auth-Key": "secret-key"},
+		},
+	}
+
+	for _, test := range tests {
+		api := &fakeAPI{pageSize: 10}
+		c, closeServer := newTestClient(api, test.token, test.email, test.key)
+		if err := c.zoneDetails("z1"); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		closeServer()
+
+		if len(api.headers) != 1 {
+			t.Fatalf("%s: got %d requests, want 1", test.name, len(api.headers))
+		}
+		for header, value := range test.want {
+			if got := api.headers[0].Get(header); got != value {
+				t.Errorf("%s: got header %s %q, want %q", test.name, header, got, value)
+			}
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	api := &fakeAPI{pageSize: 10}
+	c, closeServer := newTestClient(api, "token", "", "")
+	defer closeServer()
+
+	err := c.deleteRecord("z1", "missing")
+	if err == nil || !strings.Contains(err.Error(), "Record not found (code 81044)") {
+		t.Errorf("got error %v, want the error of the API", err)
+	}
+}
+
+func TestListRecordsPages(t *testing.T) {
+	api := &fakeAPI{pageSize: 2}
+	for idx := 1; idx <= 5; idx++ {
+		api.records = append(api.records, cfRecord{ID: fmt.Sprintf("r%d", idx), Type: "A",
+			Name: "www.example.com", Content: fmt.Sprintf("10.0.0.%d", idx), TTL: 300})
+	}
+	c, closeServer := newTestClient(api, "token", "", "")
+	defer closeServer()
+
+	records, err := c.listRecords("z1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 5 || records[0].ID != "r1" || records[4].ID != "r5" {
+		t.Errorf("got records %+v, want the records of all pages", records)
+	}
+	want := []string{"1/100", "2/100", "3/100"}
+	if fmt.Sprint(api.pages) != fmt.Sprint(want) {
+		t.Errorf("got pages %v, want %v", api.pages, want)
+	}
+}
+
+func newTestProvider(t *testing.T, api *fakeAPI) (*CloudflareProvider, func()) {
+	c, closeServer := newTestClient(api, "token", "", "")
+	p := &CloudflareProvider{
+		client:    c,
+		zoneNames: []string{"example.com"},
+		records:   make(map[string][]cfRecord),
+	}
+	if err := p.setZones(); err != nil {
+		closeServer()
+		t.Fatal(err)
+	}
+	return p, closeServer
+}
+
+func TestProxiedRecords(t *testing.T) {
+	api := &fakeAPI{pageSize: 10}
+	p, closeServer := newTestProvider(t, api)
+	defer closeServer()
+
+	record := utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 300, Proxied: true, Records: []string{"10.0.0.1"}}
+	if err := p.AddRecord(record); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(api.records) != 1 || api.records[0].TTL != autoTTL || !api.records[0].Proxied {
+		t.Errorf("got records %+v, want a proxied record with automatic TTL", api.records)
+	}
+
+	// the record read back matches the record written
+	records, err := p.GetRecords()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rules := p.RecordRules(record.Fqdn)
+	if len(records) != 1 || fmt.Sprint(rules.Normalize(records[0])) != fmt.Sprint(rules.Normalize(record)) {
+		t.Errorf("got records %+v, want %+v", records, rules.Normalize(record))
+	}
+}
+
+func TestRecordCache(t *testing.T) {
+	api := &fakeAPI{pageSize: 10, records: []cfRecord{
+		{ID: "r0", Type: "A", Name: "api.example.com", Content: "10.0.1.1", TTL: 300},
+	}}
+	p, closeServer := newTestProvider(t, api)
+	defer closeServer()
+
+	if _, err := p.GetRecords(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the cache holds the records the API holds after each change
+	steps := []struct {
+		name   string
+		change func() error
+	}{
+		{"create", func() error {
+			return p.AddRecord(utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1", "10.0.0.2"}})
+		}},
+		{"update", func() error {
+			return p.UpdateRecord(utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 600, Records: []string{"10.0.0.2", "10.0.0.3"}})
+		}},
+		{"delete", func() error {
+			return p.RemoveRecord(utils.DnsRecord{Fqdn: "api.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.1.1"}})
+		}},
+	}
+	for _, step := range steps {
+		if err := step.change(); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+		if fmt.Sprint(p.records["z1"]) != fmt.Sprint(api.records) {
+			t.Errorf("%s: got cached records %+v, want %+v", step.name, p.records["z1"], api.records)
+		}
+	}
+
+	// the zone was only listed by GetRecords
+	if len(api.pages) != 1 {
+		t.Errorf("got %d listings, want 1", len(api.pages))
+	}
+}
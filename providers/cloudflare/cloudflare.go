@@ -3,48 +3,62 @@ package cloudflare
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
-	api "github.com/crackcomm/cloudflare"
 	"github.com/rancher/external-dns/providers"
 	"github.com/rancher/external-dns/utils"
-	"golang.org/x/net/context"
+)
+
+const (
+	// TTL of proxied records, which Cloudflare sets automatically
+	autoTTL = 1
 )
 
 type CloudflareProvider struct {
-	client *api.Client
-	zone   *api.Zone
-	ctx    context.Context
-	root   string
+	client *client
+	// zones indexed by name
+	zones     map[string]*cfZone
+	zoneNames []string
+	// records of the zones indexed by zone ID. They are listed once
+	// per sync by GetRecords and kept up to date with the changes.
+	records map[string][]cfRecord
 }
 
 func init() {
-	providers.RegisterProvider("cloudflare", &CloudflareProvider{})
+	providers.RegisterProvider("cloudflare", func() providers.Provider {
+		return &CloudflareProvider{}
+	})
 }
 
-func (c *CloudflareProvider) Init(rootDomainName string) error {
-	var email, apiKey string
-	if email = os.Getenv("CLOUDFLARE_EMAIL"); len(email) == 0 {
-		return fmt.Errorf("CLOUDFLARE_EMAIL is not set")
-	}
+// Init configures the client with the API token CLOUDFLARE_API_TOKEN,
+// which needs the Zone:Read and DNS:Edit permissions, or with the
+// global API key CLOUDFLARE_KEY of the account CLOUDFLARE_EMAIL.
+func (c *CloudflareProvider) Init(rootDomainNames []string) error {
+	var token, email, apiKey string
+	if token = os.Getenv("CLOUDFLARE_API_TOKEN"); len(token) == 0 {
+		if email = os.Getenv("CLOUDFLARE_EMAIL"); len(email) == 0 {
+			return fmt.Errorf("CLOUDFLARE_API_TOKEN or CLOUDFLARE_EMAIL is not set")
+		}
 
-	if apiKey = os.Getenv("CLOUDFLARE_KEY"); len(apiKey) == 0 {
-		return fmt.Errorf("CLOUDFLARE_KEY is not set")
+		if apiKey = os.Getenv("CLOUDFLARE_KEY"); len(apiKey) == 0 {
+			return fmt.Errorf("CLOUDFLARE_KEY is not set")
+		}
 	}
 
-	c.client = api.New(&api.Options{
-		Email: email,
-		Key:   apiKey,
-	})
-
-	c.ctx = context.Background()
-	c.root = utils.UnFqdn(rootDomainName)
+	c.client = newClient(token, email, apiKey)
+	c.records = make(map[string][]cfRecord)
+	c.zoneNames = make([]string, len(rootDomainNames))
+	for idx, rootDomainName := range rootDomainNames {
+		c.zoneNames[idx] = utils.UnFqdn(rootDomainName)
+	}
 
-	if err := c.setZone(); err != nil {
-		return fmt.Errorf("Failed to set zone for root domain %s: %v", c.root, err)
+	if err := c.setZones(); err != nil {
+		return fmt.Errorf("Failed to set zones for root domains %v: %v", c.zoneNames, err)
 	}
 
-	logrus.Infof("Configured %s with zone '%s'", c.GetName(), c.root)
+	logrus.Infof("Configured %s with zones %v", c.GetName(), c.zoneNames)
 	return nil
 }
 
@@ -53,121 +67,184 @@ func (*CloudflareProvider) GetName() string {
 }
 
 func (c *CloudflareProvider) HealthCheck() error {
-	_, err := c.client.Zones.Details(c.ctx, c.zone.ID)
-	return err
+	for _, zone := range c.zones {
+		if err := c.client.zoneDetails(zone.ID); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (c *CloudflareProvider) AddRecord(record utils.DnsRecord) error {
 	for _, rec := range record.Records {
-		r := c.prepareRecord(record)
-		r.Content = rec
-		err := c.client.Records.Create(c.ctx, r)
-		if err != nil {
-			return fmt.Errorf("CloudFlare API call has failed: %v", err)
+		if err := c.CreateValue(record, rec); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// UpdateRecord changes the records of the name and type in place
+// so that the name keeps resolving
 func (c *CloudflareProvider) UpdateRecord(record utils.DnsRecord) error {
-	if err := c.RemoveRecord(record); err != nil {
+	zone, err := c.zoneForRecord(record)
+	if err != nil {
 		return err
 	}
 
-	return c.AddRecord(record)
+	existing, err := c.findRecords(zone, record)
+	if err != nil {
+		return err
+	}
+
+	values := make([]providers.RecordValue, len(existing))
+	for idx, rec := range existing {
+		values[idx] = recordValue(rec)
+	}
+
+	return providers.UpdateRecordValues(c, c.RecordRules(record.Fqdn), values, record)
 }
 
-func (c *CloudflareProvider) RemoveRecord(record utils.DnsRecord) error {
-	records, err := c.findRecords(record)
+func (c *CloudflareProvider) CreateValue(record utils.DnsRecord, value string) error {
+	zone, err := c.zoneForRecord(record)
 	if err != nil {
 		return err
 	}
 
-	for _, rec := range records {
-		err := c.client.Records.Delete(c.ctx, c.zone.ID, rec.ID)
-		if err != nil {
-			return fmt.Errorf("CloudFlare API call has failed: %v", err)
-		}
+	r := c.prepareRecord(record)
+	if err := setValue(&r, value); err != nil {
+		return err
 	}
-
+	if err := c.client.createRecord(zone.ID, &r); err != nil {
+		return fmt.Errorf("CloudFlare API call has failed: %v", err)
+	}
+	c.records[zone.ID] = append(c.records[zone.ID], r)
 	return nil
 }
 
-func (c *CloudflareProvider) GetRecords() ([]utils.DnsRecord, error) {
-	var records []utils.DnsRecord
-	result, err := c.client.Records.List(c.ctx, c.zone.ID)
+func (c *CloudflareProvider) UpdateValue(record utils.DnsRecord, existing providers.RecordValue, value string) error {
+	zone, err := c.zoneForRecord(record)
 	if err != nil {
-		return records, fmt.Errorf("CloudFlare API call has failed: %v", err)
+		return err
 	}
 
-	recordMap := map[string]map[string][]string{}
-	recordTTLs := map[string]map[string]int{}
-
-	for _, rec := range result {
-		fqdn := utils.Fqdn(rec.Name)
-		recordTTLs[fqdn] = map[string]int{}
-		recordTTLs[fqdn][rec.Type] = rec.TTL
-		recordSet, exists := recordMap[fqdn]
-		if exists {
-			recordSlice, sliceExists := recordSet[rec.Type]
-			if sliceExists {
-				recordSlice = append(recordSlice, rec.Content)
-				recordSet[rec.Type] = recordSlice
-			} else {
-				recordSet[rec.Type] = []string{rec.Content}
-			}
-		} else {
-			recordMap[fqdn] = map[string][]string{}
-			recordMap[fqdn][rec.Type] = []string{rec.Content}
+	r := c.prepareRecord(record)
+	r.ID = existing.ID
+	if err := setValue(&r, value); err != nil {
+		return err
+	}
+	if err := c.client.updateRecord(zone.ID, r); err != nil {
+		return fmt.Errorf("CloudFlare API call has failed: %v", err)
+	}
+	for idx, rec := range c.records[zone.ID] {
+		if rec.ID == r.ID {
+			c.records[zone.ID][idx] = r
 		}
 	}
+	return nil
+}
 
-	for fqdn, recordSet := range recordMap {
-		for recordType, recordSlice := range recordSet {
-			ttl := recordTTLs[fqdn][recordType]
-			record := utils.DnsRecord{Fqdn: fqdn, Records: recordSlice, Type: recordType, TTL: ttl}
-			records = append(records, record)
-		}
+func (c *CloudflareProvider) DeleteValue(record utils.DnsRecord, existing providers.RecordValue) error {
+	zone, err := c.zoneForRecord(record)
+	if err != nil {
+		return err
 	}
 
-	return records, nil
+	if err := c.client.deleteRecord(zone.ID, existing.ID); err != nil {
+		return fmt.Errorf("CloudFlare API call has failed: %v", err)
+	}
+	c.forgetRecord(zone, existing.ID)
+	return nil
 }
 
-func (c *CloudflareProvider) setZone() error {
-	zones, err := c.client.Zones.List(c.ctx)
+func (c *CloudflareProvider) RemoveRecord(record utils.DnsRecord) error {
+	zone, err := c.zoneForRecord(record)
 	if err != nil {
-		return fmt.Errorf("CloudFlare API call has failed: %v", err)
+		return err
 	}
 
-	for _, zone := range zones {
-		if zone.Name == c.root {
-			c.zone = zone
-			break
+	records, err := c.findRecords(zone, record)
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		if err := c.client.deleteRecord(zone.ID, rec.ID); err != nil {
+			return fmt.Errorf("CloudFlare API call has failed: %v", err)
+		}
+		c.forgetRecord(zone, rec.ID)
+	}
+
+	return nil
+}
+
+func (c *CloudflareProvider) GetRecords() ([]utils.DnsRecord, error) {
+	var result []cfRecord
+	for _, zoneName := range c.zoneNames {
+		zone := c.zones[zoneName]
+		zoneRecords, err := c.client.listRecords(zone.ID)
+		if err != nil {
+			return nil, fmt.Errorf("CloudFlare API call has failed: %v", err)
 		}
+		c.records[zone.ID] = zoneRecords
+		result = append(result, zoneRecords...)
 	}
-	if c.zone == nil {
-		return fmt.Errorf("Zone %s does not exist", c.root)
+
+	values := make([]providers.RecordValue, len(result))
+	for idx, rec := range result {
+		values[idx] = recordValue(rec)
+	}
+
+	return providers.GroupRecordValues(values), nil
+}
+
+func (c *CloudflareProvider) setZones() error {
+	c.zones = make(map[string]*cfZone, len(c.zoneNames))
+	for _, zoneName := range c.zoneNames {
+		zone, err := c.client.findZone(zoneName)
+		if err != nil {
+			return fmt.Errorf("CloudFlare API call has failed: %v", err)
+		}
+		c.zones[zoneName] = zone
 	}
 
 	return nil
 }
 
-func (c *CloudflareProvider) prepareRecord(record utils.DnsRecord) *api.Record {
+func (c *CloudflareProvider) zoneForRecord(record utils.DnsRecord) (*cfZone, error) {
+	zoneName := utils.ZoneForFqdn(utils.UnFqdn(record.Fqdn), c.zoneNames)
+	if zoneName == "" {
+		return nil, fmt.Errorf("No zone configured for '%s'", record.Fqdn)
+	}
+	return c.zones[zoneName], nil
+}
+
+func (c *CloudflareProvider) prepareRecord(record utils.DnsRecord) cfRecord {
 	name := utils.UnFqdn(record.Fqdn)
-	return &api.Record{
-		Type:   record.Type,
-		Name:   name,
-		TTL:    sanitizeTTL(record.TTL),
-		ZoneID: c.zone.ID,
+	ttl := sanitizeTTL(record.TTL)
+	if record.Proxied {
+		ttl = autoTTL
+	}
+	return cfRecord{
+		Type:    record.Type,
+		Name:    name,
+		TTL:     ttl,
+		Proxied: record.Proxied,
 	}
 }
 
-func (c *CloudflareProvider) findRecords(record utils.DnsRecord) ([]*api.Record, error) {
-	var records []*api.Record
-	result, err := c.client.Records.List(c.ctx, c.zone.ID)
-	if err != nil {
-		return records, fmt.Errorf("CloudFlare API call has failed: %v", err)
+// findRecords returns the records of the zone with the name and type
+// of the record. The zone is only listed if GetRecords has not yet.
+func (c *CloudflareProvider) findRecords(zone *cfZone, record utils.DnsRecord) ([]cfRecord, error) {
+	var records []cfRecord
+	result, ok := c.records[zone.ID]
+	if !ok {
+		var err error
+		if result, err = c.client.listRecords(zone.ID); err != nil {
+			return records, fmt.Errorf("CloudFlare API call has failed: %v", err)
+		}
+		c.records[zone.ID] = result
 	}
 
 	name := utils.UnFqdn(record.Fqdn)
@@ -180,6 +257,93 @@ func (c *CloudflareProvider) findRecords(record utils.DnsRecord) ([]*api.Record,
 	return records, nil
 }
 
+// setValue sets the content of the record to the value. The
+// preference of MX records and the fields of SRV records are
+// set apart from the content.
+func setValue(r *cfRecord, value string) error {
+	fields := strings.Fields(value)
+	switch r.Type {
+	case "MX":
+		if len(fields) != 2 {
+			return fmt.Errorf("Invalid MX record value '%s'", value)
+		}
+		priority, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return fmt.Errorf("Invalid MX record value '%s': %v", value, err)
+		}
+		r.Priority = &priority
+		r.Content = utils.UnFqdn(fields[1])
+	case "SRV":
+		// '_service._proto.name' and 'priority weight port target'
+		labels := strings.SplitN(r.Name, ".", 3)
+		if len(fields) != 4 || len(labels) != 3 {
+			return fmt.Errorf("Invalid SRV record '%s %s'", r.Name, value)
+		}
+		var numbers [3]int
+		for idx := range numbers {
+			n, err := strconv.Atoi(fields[idx])
+			if err != nil {
+				return fmt.Errorf("Invalid SRV record value '%s': %v", value, err)
+			}
+			numbers[idx] = n
+		}
+		r.Data = &cfSRVData{
+			Service:  labels[0],
+			Proto:    labels[1],
+			Name:     labels[2],
+			Priority: numbers[0],
+			Weight:   numbers[1],
+			Port:     numbers[2],
+			Target:   utils.UnFqdn(fields[3]),
+		}
+	default:
+		r.Content = value
+	}
+	return nil
+}
+
+func recordValue(rec cfRecord) providers.RecordValue {
+	value := rec.Content
+	switch {
+	case rec.Type == "MX" && rec.Priority != nil:
+		value = fmt.Sprintf("%d %s", *rec.Priority, rec.Content)
+	case rec.Type == "SRV" && rec.Data != nil:
+		value = fmt.Sprintf("%d %d %d %s", rec.Data.Priority, rec.Data.Weight, rec.Data.Port, rec.Data.Target)
+	}
+	return providers.RecordValue{
+		ID:      rec.ID,
+		Fqdn:    utils.Fqdn(rec.Name),
+		Type:    rec.Type,
+		TTL:     rec.TTL,
+		Proxied: rec.Proxied,
+		Value:   value,
+	}
+}
+
+// forgetRecord removes the deleted record from the records of the zone
+func (c *CloudflareProvider) forgetRecord(zone *cfZone, id string) {
+	records := c.records[zone.ID][:0]
+	for _, rec := range c.records[zone.ID] {
+		if rec.ID != id {
+			records = append(records, rec)
+		}
+	}
+	c.records[zone.ID] = records
+}
+
+// Proxied records have an automatic TTL. TXT content may be
+// returned quoted depending on how the record was created.
+func (*CloudflareProvider) RecordRules(fqdn string) providers.RecordRules {
+	return providers.RecordRules{
+		MinTTL:          120,
+		MaxTTL:          86400,
+		QuotedTXT:       true,
+		CaseInsensitive: true,
+		Proxied:         true,
+		ProxiedTTL:      autoTTL,
+	}
+}
+
 // TTL must be between 120 and 86400 seconds
 func sanitizeTTL(ttl int) int {
 	if ttl < 120 {
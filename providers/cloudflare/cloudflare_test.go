@@ -0,0 +1,51 @@
+package cloudflare
+
+import (
+	"testing"
+
+	"github.com/rancher/external-dns/utils"
+)
+
+func TestRecordValueRoundTrip(t *testing.T) {
+	tests := []struct {
+		fqdn       string
+		recordType string
+		value      string
+	}{
+		{"www.example.com.", "A", "10.0.0.1"},
+		{"www.example.com.", "CNAME", "origin.example.net."},
+		{"example.com.", "MX", "10 mail.example.com."},
+		{"example.com.", "MX", "0 mail.example.com."},
+		{"_sip._tcp.example.com.", "SRV", "10 20 5060 sip.example.com."},
+	}
+
+	c := &CloudflareProvider{}
+	rules := c.RecordRules("example.com.")
+	for _, test := range tests {
+		r := c.prepareRecord(utils.DnsRecord{Fqdn: test.fqdn, Type: test.recordType, TTL: 300})
+		if err := setValue(&r, test.value); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.value, err)
+			continue
+		}
+		value := recordValue(r).Value
+		if rules.NormalizeValue(test.recordType, value) != test.value {
+			t.Errorf("%s: got value %q, want %q", test.recordType, value, test.value)
+		}
+	}
+}
+
+func TestSetValueSRVFields(t *testing.T) {
+	c := &CloudflareProvider{}
+	r := c.prepareRecord(utils.DnsRecord{Fqdn: "_sip._tcp.example.com.", Type: "SRV", TTL: 300})
+	if err := setValue(&r, "10 20 5060 sip.example.com."); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := cfSRVData{Service: "_sip", Proto: "_tcp", Name: "example.com",
+		Priority: 10, Weight: 20, Port: 5060, Target: "sip.example.com"}
+	if r.Data == nil || *r.Data != want {
+		t.Errorf("got data %+v, want %+v", r.Data, want)
+	}
+	if r.Content != "" {
+		t.Errorf("got content %q, want none", r.Content)
+	}
+}
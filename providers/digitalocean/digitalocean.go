@@ -3,6 +3,7 @@ package digitalocean
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
@@ -10,19 +11,22 @@ import (
 	"golang.org/x/oauth2"
 
 	"github.com/juju/ratelimit"
-	"github.com/rancher/external-dns/config"
 	"github.com/rancher/external-dns/providers"
 	"github.com/rancher/external-dns/utils"
 )
 
 type DigitalOceanProvider struct {
-	client         *api.Client
-	rootDomainName string
-	limiter        *ratelimit.Bucket
+	client          *api.Client
+	rootDomainNames []string
+	// domain-wide TTLs indexed by domain name
+	domainTTLs map[string]int
+	limiter    *ratelimit.Bucket
 }
 
 func init() {
-	providers.RegisterProvider("digitalocean", &DigitalOceanProvider{})
+	providers.RegisterProvider("digitalocean", func() providers.Provider {
+		return &DigitalOceanProvider{}
+	})
 }
 
 type TokenSource struct {
@@ -36,7 +40,7 @@ func (t *TokenSource) Token() (*oauth2.Token, error) {
 	return token, nil
 }
 
-func (p *DigitalOceanProvider) Init(rootDomainName string) error {
+func (p *DigitalOceanProvider) Init(rootDomainNames []string) error {
 	var pat string
 	if pat = os.Getenv("DO_PAT"); len(pat) == 0 {
 		return fmt.Errorf("DO_PAT is not set")
@@ -53,7 +57,10 @@ func (p *DigitalOceanProvider) Init(rootDomainName string) error {
 	doqps := (float64)(5000.0 / 3600.0)
 	p.limiter = ratelimit.NewBucketWithRate(doqps, 100)
 
-	p.rootDomainName = utils.UnFqdn(rootDomainName)
+	p.rootDomainNames = make([]string, len(rootDomainNames))
+	for idx, rootDomainName := range rootDomainNames {
+		p.rootDomainNames[idx] = utils.UnFqdn(rootDomainName)
+	}
 
 	// Retrieve email address associated with this PAT.
 	p.limiter.Wait(1)
@@ -62,16 +69,19 @@ func (p *DigitalOceanProvider) Init(rootDomainName string) error {
 		return err
 	}
 
-	// Now confirm that domain is accessible under this PAT.
-	p.limiter.Wait(1)
-	domains, _, err := p.client.Domains.Get(p.rootDomainName)
-	if err != nil {
-		return err
+	// Now confirm that the domains are accessible under this PAT.
+	p.domainTTLs = make(map[string]int, len(p.rootDomainNames))
+	for _, rootDomainName := range p.rootDomainNames {
+		p.limiter.Wait(1)
+		domains, _, err := p.client.Domains.Get(rootDomainName)
+		if err != nil {
+			return err
+		}
+		// DO's TTLs are domain-wide.
+		p.domainTTLs[rootDomainName] = domains.TTL
 	}
 
-	// DO's TTLs are domain-wide.
-	config.TTL = domains.TTL
-	logrus.Infof("Configured %s with email %s and domain %s", p.GetName(), acct.Email, domains.Name)
+	logrus.Infof("Configured %s with email %s and domains %v", p.GetName(), acct.Email, p.rootDomainNames)
 	return nil
 }
 
@@ -80,99 +90,198 @@ func (p *DigitalOceanProvider) GetName() string {
 }
 
 func (p *DigitalOceanProvider) HealthCheck() error {
-	p.limiter.Wait(1)
-	_, _, err := p.client.Domains.Get(p.rootDomainName)
-	return err
+	for _, rootDomainName := range p.rootDomainNames {
+		p.limiter.Wait(1)
+		if _, _, err := p.client.Domains.Get(rootDomainName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *DigitalOceanProvider) domainForRecord(record utils.DnsRecord) (string, error) {
+	domain := utils.ZoneForFqdn(utils.UnFqdn(record.Fqdn), p.rootDomainNames)
+	if domain == "" {
+		return "", fmt.Errorf("No domain configured for '%s'", record.Fqdn)
+	}
+	return domain, nil
+}
+
+// DigitalOcean does not have per-record TTLs, records
+// always get the TTL of their domain. MX and SRV records
+// hold their priority, weight and port in fields of their own.
+func (p *DigitalOceanProvider) RecordRules(fqdn string) providers.RecordRules {
+	rules := providers.RecordRules{RecordTypes: providers.BasicRecordTypes}
+	if domain := utils.ZoneForFqdn(utils.UnFqdn(fqdn), p.rootDomainNames); domain != "" {
+		rules.MinTTL = p.domainTTLs[domain]
+		rules.MaxTTL = p.domainTTLs[domain]
+	}
+	return rules
 }
 
 func (p *DigitalOceanProvider) AddRecord(record utils.DnsRecord) error {
 	for _, r := range record.Records {
-		createRequest := &api.DomainRecordEditRequest{
-			Type: record.Type,
-			Name: record.Fqdn,
-			Data: r,
-		}
-
-		logrus.Debugf("Creating record: %v", createRequest)
-		p.limiter.Wait(1)
-		_, _, err := p.client.Domains.CreateRecord(p.rootDomainName, createRequest)
-		if err != nil {
-			return fmt.Errorf("API call has failed: %v", err)
+		if err := p.CreateValue(record, r); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// UpdateRecord changes the records of the name and type in place
+// so that the name keeps resolving
 func (p *DigitalOceanProvider) UpdateRecord(record utils.DnsRecord) error {
-	if err := p.RemoveRecord(record); err != nil {
-		return err
+	values, err := p.findValues(record)
+	if err != nil {
+		return fmt.Errorf("UpdateRecord: %v", err)
 	}
 
-	return p.AddRecord(record)
+	return providers.UpdateRecordValues(p, p.RecordRules(record.Fqdn), values, record)
 }
 
 func (p *DigitalOceanProvider) RemoveRecord(record utils.DnsRecord) error {
-	// We need to fetch paginated results to get all records
-	doRecords, err := p.fetchDoRecords()
+	values, err := p.findValues(record)
 	if err != nil {
 		return fmt.Errorf("RemoveRecord: %v", err)
 	}
 
-	for _, rec := range doRecords {
-		// DO records don't have fully-qualified names like ours
-		fqdn := p.nameToFqdn(rec.Name)
-		if fqdn == record.Fqdn && rec.Type == record.Type {
-			p.limiter.Wait(1)
-			logrus.Debugf("Deleting record: %v", rec)
-			_, err := p.client.Domains.DeleteRecord(p.rootDomainName, rec.ID)
-			if err != nil {
-				return fmt.Errorf("API call has failed: %v", err)
-			}
+	for _, value := range values {
+		if err := p.DeleteValue(record, value); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+func (p *DigitalOceanProvider) CreateValue(record utils.DnsRecord, value string) error {
+	domain, err := p.domainForRecord(record)
+	if err != nil {
+		return err
+	}
+
+	createRequest := &api.DomainRecordEditRequest{
+		Type: record.Type,
+		Name: record.Fqdn,
+		Data: value,
+	}
+
+	logrus.Debugf("Creating record: %v", createRequest)
+	p.limiter.Wait(1)
+	if _, _, err := p.client.Domains.CreateRecord(domain, createRequest); err != nil {
+		return fmt.Errorf("API call has failed: %v", err)
+	}
+
+	return nil
+}
+
+func (p *DigitalOceanProvider) UpdateValue(record utils.DnsRecord, existing providers.RecordValue, value string) error {
+	domain, err := p.domainForRecord(record)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(existing.ID)
+	if err != nil {
+		return fmt.Errorf("Invalid record ID %s: %v", existing.ID, err)
+	}
+
+	editRequest := &api.DomainRecordEditRequest{
+		Type: record.Type,
+		Name: record.Fqdn,
+		Data: value,
+	}
+
+	logrus.Debugf("Editing record %d: %v", id, editRequest)
+	p.limiter.Wait(1)
+	if _, _, err := p.client.Domains.EditRecord(domain, id, editRequest); err != nil {
+		return fmt.Errorf("API call has failed: %v", err)
+	}
+
+	return nil
+}
+
+func (p *DigitalOceanProvider) DeleteValue(record utils.DnsRecord, existing providers.RecordValue) error {
+	domain, err := p.domainForRecord(record)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(existing.ID)
+	if err != nil {
+		return fmt.Errorf("Invalid record ID %s: %v", existing.ID, err)
+	}
+
+	logrus.Debugf("Deleting record %d: %s", id, existing.Value)
+	p.limiter.Wait(1)
+	if _, err := p.client.Domains.DeleteRecord(domain, id); err != nil {
+		return fmt.Errorf("API call has failed: %v", err)
+	}
+
+	return nil
+}
+
 func (p *DigitalOceanProvider) GetRecords() ([]utils.DnsRecord, error) {
 	dnsRecords := []utils.DnsRecord{}
-	recordMap := map[string]map[string][]string{}
-	doRecords, err := p.fetchDoRecords()
+	for _, domain := range p.rootDomainNames {
+		values, err := p.getDomainValues(domain)
+		if err != nil {
+			return nil, fmt.Errorf("GetRecords: %v", err)
+		}
+		dnsRecords = append(dnsRecords, providers.GroupRecordValues(values)...)
+	}
+
+	return dnsRecords, nil
+}
+
+// findValues returns the values of the record's name and type
+func (p *DigitalOceanProvider) findValues(record utils.DnsRecord) ([]providers.RecordValue, error) {
+	domain, err := p.domainForRecord(record)
 	if err != nil {
-		return nil, fmt.Errorf("GetRecords: %v", err)
-	}
-
-	for _, rec := range doRecords {
-		fqdn := p.nameToFqdn(rec.Name)
-		recordSet, exists := recordMap[fqdn]
-		if exists {
-			recordSlice, sliceExists := recordSet[rec.Type]
-			if sliceExists {
-				recordSlice = append(recordSlice, rec.Data)
-				recordSet[rec.Type] = recordSlice
-			} else {
-				recordSet[rec.Type] = []string{rec.Data}
-			}
-		} else {
-			recordMap[fqdn] = map[string][]string{}
-			recordMap[fqdn][rec.Type] = []string{rec.Data}
+		return nil, err
+	}
+
+	// We need to fetch paginated results to get all records
+	domainValues, err := p.getDomainValues(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []providers.RecordValue
+	for _, value := range domainValues {
+		if value.Fqdn == record.Fqdn && value.Type == record.Type {
+			values = append(values, value)
 		}
 	}
 
-	for fqdn, recordSet := range recordMap {
-		for recordType, recordSlice := range recordSet {
-			// DigitalOcean does not have per-record TTLs.
-			dnsRecord := utils.DnsRecord{Fqdn: fqdn, Records: recordSlice, Type: recordType, TTL: config.TTL}
-			dnsRecords = append(dnsRecords, dnsRecord)
+	return values, nil
+}
+
+func (p *DigitalOceanProvider) getDomainValues(domain string) ([]providers.RecordValue, error) {
+	doRecords, err := p.fetchDoRecords(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]providers.RecordValue, len(doRecords))
+	for idx, rec := range doRecords {
+		// DO records don't have fully-qualified names like ours
+		// and DigitalOcean does not have per-record TTLs.
+		values[idx] = providers.RecordValue{
+			ID:    strconv.Itoa(rec.ID),
+			Fqdn:  p.nameToFqdn(domain, rec.Name),
+			Type:  rec.Type,
+			TTL:   p.domainTTLs[domain],
+			Value: rec.Data,
 		}
 	}
 
-	return dnsRecords, nil
+	return values, nil
 }
 
-// fetchDoRecords retrieves all records for the root domain from Digital Ocean.
-func (p *DigitalOceanProvider) fetchDoRecords() ([]api.DomainRecord, error) {
+// fetchDoRecords retrieves all records for the domain from Digital Ocean.
+func (p *DigitalOceanProvider) fetchDoRecords(domain string) ([]api.DomainRecord, error) {
 	doRecords := []api.DomainRecord{}
 	opt := &api.ListOptions{
 		// Use the maximum of 200 records per page
@@ -180,7 +289,7 @@ func (p *DigitalOceanProvider) fetchDoRecords() ([]api.DomainRecord, error) {
 	}
 	for {
 		p.limiter.Wait(1)
-		records, resp, err := p.client.Domains.Records(p.rootDomainName, opt)
+		records, resp, err := p.client.Domains.Records(domain, opt)
 		if err != nil {
 			return nil, fmt.Errorf("API call has failed: %v", err)
 		}
@@ -205,12 +314,12 @@ func (p *DigitalOceanProvider) fetchDoRecords() ([]api.DomainRecord, error) {
 	return doRecords, nil
 }
 
-func (p *DigitalOceanProvider) nameToFqdn(name string) string {
+func (p *DigitalOceanProvider) nameToFqdn(domain, name string) string {
 	var fqdn string
 	if name == "@" {
-		fqdn = p.rootDomainName
+		fqdn = domain
 	} else {
-		names := []string{name, p.rootDomainName}
+		names := []string{name, domain}
 		fqdn = strings.Join(names, ".")
 	}
 
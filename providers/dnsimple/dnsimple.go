@@ -16,15 +16,17 @@ import (
 type DNSimpleProvider struct {
 	client    *dnsimple.Client
 	accountID string
-	root      string
+	roots     []string
 	limiter   *ratelimit.Bucket
 }
 
 func init() {
-	providers.RegisterProvider("dnsimple", &DNSimpleProvider{})
+	providers.RegisterProvider("dnsimple", func() providers.Provider {
+		return &DNSimpleProvider{}
+	})
 }
 
-func (d *DNSimpleProvider) Init(rootDomainName string) error {
+func (d *DNSimpleProvider) Init(rootDomainNames []string) error {
 	var oauthToken string
 
 	if len(os.Getenv("DNSIMPLE_EMAIL")) > 0 {
@@ -35,7 +37,10 @@ func (d *DNSimpleProvider) Init(rootDomainName string) error {
 		return fmt.Errorf("DNSIMPLE_TOKEN is not set")
 	}
 
-	d.root = utils.UnFqdn(rootDomainName)
+	d.roots = make([]string, len(rootDomainNames))
+	for idx, rootDomainName := range rootDomainNames {
+		d.roots[idx] = utils.UnFqdn(rootDomainName)
+	}
 	d.client = dnsimple.NewClient(dnsimple.NewOauthTokenCredentials(oauthToken))
 	d.limiter = ratelimit.NewBucketWithRate(1.5, 5)
 
@@ -48,12 +53,14 @@ func (d *DNSimpleProvider) Init(rootDomainName string) error {
 	}
 	d.accountID = strconv.FormatInt(whoamiResponse.Data.Account.ID, 10)
 
-	_, err = d.client.Zones.GetZone(d.accountID, d.root)
-	if err != nil {
-		return fmt.Errorf("Failed to get zone for '%s': %v", d.root, err)
+	for _, root := range d.roots {
+		_, err = d.client.Zones.GetZone(d.accountID, root)
+		if err != nil {
+			return fmt.Errorf("Failed to get zone for '%s': %v", root, err)
+		}
 	}
 
-	logrus.Infof("Configured %s with zone '%s'", d.GetName(), d.root)
+	logrus.Infof("Configured %s with zones %v", d.GetName(), d.roots)
 	return nil
 }
 
@@ -61,46 +68,50 @@ func (*DNSimpleProvider) GetName() string {
 	return "DNSimple"
 }
 
+// DNSimple holds the priority of MX and SRV records in a field of its own
+func (*DNSimpleProvider) RecordRules(fqdn string) providers.RecordRules {
+	return providers.RecordRules{RecordTypes: providers.BasicRecordTypes}
+}
+
 func (d *DNSimpleProvider) HealthCheck() error {
 	d.limiter.Wait(1)
 	_, err := d.client.Identity.Whoami()
 	return err
 }
 
-func (d *DNSimpleProvider) parseName(record utils.DnsRecord) string {
-	name := strings.TrimSuffix(record.Fqdn, fmt.Sprintf(".%s.", d.root))
+func (d *DNSimpleProvider) zoneForRecord(record utils.DnsRecord) (string, error) {
+	root := utils.ZoneForFqdn(utils.UnFqdn(record.Fqdn), d.roots)
+	if root == "" {
+		return "", fmt.Errorf("No zone configured for '%s'", record.Fqdn)
+	}
+	return root, nil
+}
+
+func (d *DNSimpleProvider) parseName(root string, record utils.DnsRecord) string {
+	name := strings.TrimSuffix(record.Fqdn, fmt.Sprintf(".%s.", root))
 	return name
 }
 
 func (d *DNSimpleProvider) AddRecord(record utils.DnsRecord) error {
-	name := d.parseName(record)
 	for _, rec := range record.Records {
-		recordInput := dnsimple.ZoneRecord{
-			Name:    name,
-			TTL:     record.TTL,
-			Type:    record.Type,
-			Content: rec,
-		}
-		d.limiter.Wait(1)
-		_, err := d.client.Zones.CreateRecord(d.accountID, d.root, recordInput)
-		if err != nil {
-			return fmt.Errorf("DNSimple API call has failed: %v", err)
+		if err := d.CreateValue(record, rec); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (d *DNSimpleProvider) findRecords(record utils.DnsRecord) ([]dnsimple.ZoneRecord, error) {
+func (d *DNSimpleProvider) findRecords(root string, record utils.DnsRecord) ([]dnsimple.ZoneRecord, error) {
 	var zoneRecords []dnsimple.ZoneRecord
 
 	d.limiter.Wait(1)
-	recordsResponse, err := d.client.Zones.ListRecords(d.accountID, d.root, nil)
+	recordsResponse, err := d.client.Zones.ListRecords(d.accountID, root, nil)
 	if err != nil {
 		return zoneRecords, fmt.Errorf("DNSimple API call has failed: %v", err)
 	}
 
-	name := d.parseName(record)
+	name := d.parseName(root, record)
 	for _, zoneRecord := range recordsResponse.Data {
 		if zoneRecord.Name == name && zoneRecord.Type == record.Type {
 			zoneRecords = append(zoneRecords, zoneRecord)
@@ -110,75 +121,148 @@ func (d *DNSimpleProvider) findRecords(record utils.DnsRecord) ([]dnsimple.ZoneR
 	return zoneRecords, nil
 }
 
+// UpdateRecord changes the records of the name and type in place
+// so that the name keeps resolving
 func (d *DNSimpleProvider) UpdateRecord(record utils.DnsRecord) error {
-	err := d.RemoveRecord(record)
+	root, err := d.zoneForRecord(record)
 	if err != nil {
 		return err
 	}
 
-	return d.AddRecord(record)
+	zoneRecords, err := d.findRecords(root, record)
+	if err != nil {
+		return err
+	}
+
+	values := make([]providers.RecordValue, len(zoneRecords))
+	for idx, zoneRecord := range zoneRecords {
+		values[idx] = recordValue(root, zoneRecord)
+	}
+
+	return providers.UpdateRecordValues(d, providers.GetRecordRules(d, record.Fqdn), values, record)
 }
 
 func (d *DNSimpleProvider) RemoveRecord(record utils.DnsRecord) error {
-	zoneRecords, err := d.findRecords(record)
+	root, err := d.zoneForRecord(record)
+	if err != nil {
+		return err
+	}
+
+	zoneRecords, err := d.findRecords(root, record)
 	if err != nil {
 		return err
 	}
 
 	for _, zoneRecord := range zoneRecords {
-		d.limiter.Wait(1)
-		_, err := d.client.Zones.DeleteRecord(d.accountID, d.root, zoneRecord.ID)
-		if err != nil {
-			return fmt.Errorf("DNSimple API call has failed: %v", err)
+		if err := d.DeleteValue(record, recordValue(root, zoneRecord)); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (d *DNSimpleProvider) GetRecords() ([]utils.DnsRecord, error) {
-	var records []utils.DnsRecord
+func (d *DNSimpleProvider) CreateValue(record utils.DnsRecord, value string) error {
+	root, err := d.zoneForRecord(record)
+	if err != nil {
+		return err
+	}
 
+	recordInput := dnsimple.ZoneRecord{
+		Name:    d.parseName(root, record),
+		TTL:     record.TTL,
+		Type:    record.Type,
+		Content: value,
+	}
 	d.limiter.Wait(1)
-	recordsResponse, err := d.client.Zones.ListRecords(d.accountID, d.root, nil)
+	if _, err := d.client.Zones.CreateRecord(d.accountID, root, recordInput); err != nil {
+		return fmt.Errorf("DNSimple API call has failed: %v", err)
+	}
+
+	return nil
+}
+
+func (d *DNSimpleProvider) UpdateValue(record utils.DnsRecord, existing providers.RecordValue, value string) error {
+	root, err := d.zoneForRecord(record)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseInt(existing.ID, 10, 64)
 	if err != nil {
-		return records, fmt.Errorf("DNSimple API call has failed: %v", err)
+		return fmt.Errorf("Invalid record ID %s: %v", existing.ID, err)
 	}
 
-	recordMap := map[string]map[string][]string{}
-	recordTTLs := map[string]map[string]int{}
+	recordInput := dnsimple.ZoneRecord{
+		Name:    d.parseName(root, record),
+		TTL:     record.TTL,
+		Content: value,
+	}
+	d.limiter.Wait(1)
+	if _, err := d.client.Zones.UpdateRecord(d.accountID, root, id, recordInput); err != nil {
+		return fmt.Errorf("DNSimple API call has failed: %v", err)
+	}
 
-	for _, zoneRecord := range recordsResponse.Data {
-		var fqdn string
-		if zoneRecord.Name == "" {
-			fqdn = d.root + "."
-		} else {
-			fqdn = fmt.Sprintf("%s.%s.", zoneRecord.Name, d.root)
-		}
+	return nil
+}
 
-		recordTTLs[fqdn] = map[string]int{}
-		recordTTLs[fqdn][zoneRecord.Type] = zoneRecord.TTL
-		recordSet, exists := recordMap[fqdn]
-		if exists {
-			recordSlice, sliceExists := recordSet[zoneRecord.Type]
-			if sliceExists {
-				recordSlice = append(recordSlice, zoneRecord.Content)
-				recordSet[zoneRecord.Type] = recordSlice
-			} else {
-				recordSet[zoneRecord.Type] = []string{zoneRecord.Content}
-			}
-		} else {
-			recordMap[fqdn] = map[string][]string{}
-			recordMap[fqdn][zoneRecord.Type] = []string{zoneRecord.Content}
-		}
+func (d *DNSimpleProvider) DeleteValue(record utils.DnsRecord, existing providers.RecordValue) error {
+	root, err := d.zoneForRecord(record)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseInt(existing.ID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid record ID %s: %v", existing.ID, err)
 	}
 
-	for fqdn, recordSet := range recordMap {
-		for recordType, recordSlice := range recordSet {
-			ttl := recordTTLs[fqdn][recordType]
-			record := utils.DnsRecord{Fqdn: fqdn, Records: recordSlice, Type: recordType, TTL: ttl}
-			records = append(records, record)
+	d.limiter.Wait(1)
+	if _, err := d.client.Zones.DeleteRecord(d.accountID, root, id); err != nil {
+		return fmt.Errorf("DNSimple API call has failed: %v", err)
+	}
+
+	return nil
+}
+
+func (d *DNSimpleProvider) GetRecords() ([]utils.DnsRecord, error) {
+	var records []utils.DnsRecord
+	for _, root := range d.roots {
+		zoneRecords, err := d.getZoneRecords(root)
+		if err != nil {
+			return records, err
 		}
+		records = append(records, zoneRecords...)
 	}
 	return records, nil
 }
+
+func (d *DNSimpleProvider) getZoneRecords(root string) ([]utils.DnsRecord, error) {
+	d.limiter.Wait(1)
+	recordsResponse, err := d.client.Zones.ListRecords(d.accountID, root, nil)
+	if err != nil {
+		return nil, fmt.Errorf("DNSimple API call has failed: %v", err)
+	}
+
+	values := make([]providers.RecordValue, len(recordsResponse.Data))
+	for idx, zoneRecord := range recordsResponse.Data {
+		values[idx] = recordValue(root, zoneRecord)
+	}
+
+	return providers.GroupRecordValues(values), nil
+}
+
+func recordValue(root string, zoneRecord dnsimple.ZoneRecord) providers.RecordValue {
+	fqdn := root + "."
+	if zoneRecord.Name != "" {
+		fqdn = fmt.Sprintf("%s.%s.", zoneRecord.Name, root)
+	}
+
+	return providers.RecordValue{
+		ID:    strconv.FormatInt(zoneRecord.ID, 10),
+		Fqdn:  fqdn,
+		Type:  zoneRecord.Type,
+		TTL:   zoneRecord.TTL,
+		Value: zoneRecord.Content,
+	}
+}
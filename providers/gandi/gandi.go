@@ -19,20 +19,29 @@ import (
 type GandiProvider struct {
 	record      *gandiRecord.Record
 	zoneHandler *gandiZone.Zone
-	zone        *gandiZone.ZoneInfoBase
 	zoneVersion *gandiZoneVersion.Version
 	operation   *gandiOperation.Operation
-	root        string
-	zoneDomain  string
-	zoneSuffix  string
-	sub         string
+	roots       []string
+	// zones indexed by root domain
+	zones map[string]*rootZone
+}
+
+// rootZone is the Gandi zone serving a root domain
+type rootZone struct {
+	zone       *gandiZone.ZoneInfoBase
+	root       string
+	zoneDomain string
+	zoneSuffix string
+	sub        string
 }
 
 func init() {
-	providers.RegisterProvider("gandi", &GandiProvider{})
+	providers.RegisterProvider("gandi", func() providers.Provider {
+		return &GandiProvider{}
+	})
 }
 
-func (g *GandiProvider) Init(rootDomainName string) error {
+func (g *GandiProvider) Init(rootDomainNames []string) error {
 	var apiKey string
 	if apiKey = os.Getenv("GANDI_APIKEY"); len(apiKey) == 0 {
 		return fmt.Errorf("GANDI_APIKEY is not set")
@@ -49,68 +58,93 @@ func (g *GandiProvider) Init(rootDomainName string) error {
 	g.record = gandiRecord.New(client)
 	g.zoneVersion = gandiZoneVersion.New(client)
 	g.operation = gandiOperation.New(client)
+	g.zoneHandler = gandiZone.New(client)
 
-	root := utils.UnFqdn(rootDomainName)
-	split_root := strings.Split(root, ".")
-	split_zoneDomain := split_root[len(split_root)-2 : len(split_root)]
-	zoneDomain := strings.Join(split_zoneDomain, ".")
-
-	domain := gandiDomain.New(client)
-	domainInfo, err := domain.Info(zoneDomain)
-	if err != nil {
-		return fmt.Errorf("Failed to get zone ID for domain %s: %v", zoneDomain, err)
-	}
-	zoneId := domainInfo.ZoneId
-
-	zone := gandiZone.New(client)
-	zones, err := zone.List()
+	zones, err := g.zoneHandler.List()
 	if err != nil {
 		return fmt.Errorf("Failed to list hosted zones: %v", err)
 	}
 
-	found := false
-	for _, z := range zones {
-		if z.Id == zoneId {
-			g.root = root
-			g.zone = z
-			found = true
-			break
+	domain := gandiDomain.New(client)
+	g.roots = make([]string, len(rootDomainNames))
+	g.zones = make(map[string]*rootZone, len(rootDomainNames))
+	for idx, rootDomainName := range rootDomainNames {
+		root := utils.UnFqdn(rootDomainName)
+		split_root := strings.Split(root, ".")
+		split_zoneDomain := split_root[len(split_root)-2 : len(split_root)]
+		zoneDomain := strings.Join(split_zoneDomain, ".")
+
+		domainInfo, err := domain.Info(zoneDomain)
+		if err != nil {
+			return fmt.Errorf("Failed to get zone ID for domain %s: %v", zoneDomain, err)
 		}
-	}
+		zoneId := domainInfo.ZoneId
 
-	if !found {
-		return fmt.Errorf("Zone for '%s' not found", root)
-	}
+		var zone *gandiZone.ZoneInfoBase
+		for _, z := range zones {
+			if z.Id == zoneId {
+				zone = z
+				break
+			}
+		}
+
+		if zone == nil {
+			return fmt.Errorf("Zone for '%s' not found", root)
+		}
 
-	g.zoneDomain = zoneDomain
-	g.zoneSuffix = fmt.Sprintf(".%s", zoneDomain)
-	g.sub = strings.TrimSuffix(root, zoneDomain)
-	g.zoneHandler = zone
+		g.roots[idx] = root
+		g.zones[root] = &rootZone{
+			zone:       zone,
+			root:       root,
+			zoneDomain: zoneDomain,
+			zoneSuffix: fmt.Sprintf(".%s", zoneDomain),
+			sub:        strings.TrimSuffix(root, zoneDomain),
+		}
+
+		logrus.Infof("Configured %s for domain '%s' using zone '%s'", g.GetName(), root, zone.Name)
+	}
 
-	logrus.Infof("Configured %s for domain '%s' using zone '%s'", g.GetName(), root, g.zone.Name)
 	return nil
 }
 
+func (g *GandiProvider) zoneForRecord(record utils.DnsRecord) (*rootZone, error) {
+	root := utils.ZoneForFqdn(utils.UnFqdn(record.Fqdn), g.roots)
+	if root == "" {
+		return nil, fmt.Errorf("No zone configured for '%s'", record.Fqdn)
+	}
+	return g.zones[root], nil
+}
+
 func (*GandiProvider) GetName() string {
 	return "Gandi"
 }
 
+// Gandi returns the values of TXT records quoted
+func (*GandiProvider) RecordRules(fqdn string) providers.RecordRules {
+	return providers.RecordRules{QuotedTXT: true}
+}
+
 func (g *GandiProvider) HealthCheck() error {
 	_, err := g.operation.Count()
 	return err
 }
 
 func (g *GandiProvider) AddRecord(record utils.DnsRecord) error {
-	newVersion, err := g.newZoneVersion()
+	z, err := g.zoneForRecord(record)
+	if err != nil {
+		return fmt.Errorf("Failed to add new record: %v", err)
+	}
+
+	newVersion, err := g.newZoneVersion(z)
 	if err != nil {
 		return fmt.Errorf("Failed to add new record: %v", err)
 	}
 
-	if err := g.versionAddRecord(newVersion, record); err != nil {
+	if err := g.versionAddRecord(z, newVersion, record); err != nil {
 		return fmt.Errorf("Failed to add new record: %v", err)
 	}
 
-	if err := g.setZoneVersion(newVersion); err != nil {
+	if err := g.setZoneVersion(z, newVersion); err != nil {
 		return fmt.Errorf("Failed to add new record: %v", err)
 	}
 
@@ -118,20 +152,25 @@ func (g *GandiProvider) AddRecord(record utils.DnsRecord) error {
 }
 
 func (g *GandiProvider) UpdateRecord(record utils.DnsRecord) error {
-	newVersion, err := g.newZoneVersion()
+	z, err := g.zoneForRecord(record)
+	if err != nil {
+		return fmt.Errorf("Failed to update record: %v", err)
+	}
+
+	newVersion, err := g.newZoneVersion(z)
 	if err != nil {
 		return fmt.Errorf("Failed to update record: %v", err)
 	}
 
-	if err := g.versionRemoveRecord(newVersion, record); err != nil {
+	if err := g.versionRemoveRecord(z, newVersion, record); err != nil {
 		return fmt.Errorf("Failed to update record: %v", err)
 	}
 
-	if err := g.versionAddRecord(newVersion, record); err != nil {
+	if err := g.versionAddRecord(z, newVersion, record); err != nil {
 		return fmt.Errorf("Failed to update record: %v", err)
 	}
 
-	if err := g.setZoneVersion(newVersion); err != nil {
+	if err := g.setZoneVersion(z, newVersion); err != nil {
 		return fmt.Errorf("Failed to update record: %v", err)
 	}
 
@@ -139,32 +178,37 @@ func (g *GandiProvider) UpdateRecord(record utils.DnsRecord) error {
 }
 
 func (g *GandiProvider) RemoveRecord(record utils.DnsRecord) error {
-	newVersion, err := g.newZoneVersion()
+	z, err := g.zoneForRecord(record)
+	if err != nil {
+		return fmt.Errorf("Failed to remove record: %v", err)
+	}
+
+	newVersion, err := g.newZoneVersion(z)
 	if err != nil {
 		return fmt.Errorf("Failed to remove record: %v", err)
 	}
 
-	if err := g.versionRemoveRecord(newVersion, record); err != nil {
+	if err := g.versionRemoveRecord(z, newVersion, record); err != nil {
 		return fmt.Errorf("Failed to remove record: %v", err)
 	}
 
-	if err := g.setZoneVersion(newVersion); err != nil {
+	if err := g.setZoneVersion(z, newVersion); err != nil {
 		return fmt.Errorf("Failed to add new record: %v", err)
 	}
 
 	return nil
 }
 
-func (g *GandiProvider) findRecords(record utils.DnsRecord, version int64) ([]gandiRecord.RecordInfo, error) {
+func (g *GandiProvider) findRecords(z *rootZone, record utils.DnsRecord, version int64) ([]gandiRecord.RecordInfo, error) {
 	var records []gandiRecord.RecordInfo
-	resp, err := g.record.List(g.zone.Id, version)
+	resp, err := g.record.List(z.zone.Id, version)
 	if err != nil {
 		return records, fmt.Errorf("Failed to find record in zone: %v", err)
 	}
 
-	name := g.parseName(record)
+	name := g.parseName(z, record)
 	for _, rec := range resp {
-		recName := fmt.Sprintf("%s.%s.", rec.Name, g.zoneDomain)
+		recName := fmt.Sprintf("%s.%s.", rec.Name, z.zoneDomain)
 		if recName == name && rec.Type == record.Type {
 			records = append(records, *rec)
 		}
@@ -176,63 +220,63 @@ func (g *GandiProvider) findRecords(record utils.DnsRecord, version int64) ([]ga
 func (g *GandiProvider) GetRecords() ([]utils.DnsRecord, error) {
 	var records []utils.DnsRecord
 
-	recordResp, err := g.record.List(g.zone.Id, 0)
-	if err != nil {
-		return records, fmt.Errorf("Failed to get records in zone: %v", err)
+	// several root domains may be served by the same zone
+	listed := make(map[int64]struct{})
+	for _, root := range g.roots {
+		z := g.zones[root]
+		if _, ok := listed[z.zone.Id]; ok {
+			continue
+		}
+		listed[z.zone.Id] = struct{}{}
+
+		zoneRecords, err := g.getZoneRecords(z)
+		if err != nil {
+			return records, err
+		}
+		records = append(records, zoneRecords...)
 	}
 
-	recordMap := map[string]map[string][]string{}
-	recordTTLs := map[string]map[string]int{}
+	return records, nil
+}
 
-	for _, rec := range recordResp {
-		var fqdn string
-		if rec.Name == "" {
-			fqdn = fmt.Sprintf("%s.", g.zoneDomain)
-		} else {
-			fqdn = fmt.Sprintf("%s.%s.", rec.Name, g.zoneDomain)
-		}
+func (g *GandiProvider) getZoneRecords(z *rootZone) ([]utils.DnsRecord, error) {
+	recordResp, err := g.record.List(z.zone.Id, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get records in zone: %v", err)
+	}
 
-		recordTTLs[fqdn] = map[string]int{}
-		recordTTLs[fqdn][rec.Type] = int(rec.Ttl)
-		recordSet, exists := recordMap[fqdn]
-		if exists {
-			recordSlice, sliceExists := recordSet[rec.Type]
-			if sliceExists {
-				recordSlice = append(recordSlice, rec.Value)
-				recordSet[rec.Type] = recordSlice
-			} else {
-				recordSet[rec.Type] = []string{rec.Value}
-			}
-		} else {
-			recordMap[fqdn] = map[string][]string{}
-			recordMap[fqdn][rec.Type] = []string{rec.Value}
+	values := make([]providers.RecordValue, len(recordResp))
+	for idx, rec := range recordResp {
+		fqdn := fmt.Sprintf("%s.", z.zoneDomain)
+		if rec.Name != "" {
+			fqdn = fmt.Sprintf("%s.%s.", rec.Name, z.zoneDomain)
 		}
-	}
 
-	for fqdn, recordSet := range recordMap {
-		for recordType, recordSlice := range recordSet {
-			ttl := recordTTLs[fqdn][recordType]
-			record := utils.DnsRecord{Fqdn: fqdn, Records: recordSlice, Type: recordType, TTL: ttl}
-			records = append(records, record)
+		values[idx] = providers.RecordValue{
+			ID:    rec.Id,
+			Fqdn:  fqdn,
+			Type:  rec.Type,
+			TTL:   int(rec.Ttl),
+			Value: rec.Value,
 		}
 	}
 
-	return records, nil
+	return providers.GroupRecordValues(values), nil
 }
 
-func (g *GandiProvider) parseName(record utils.DnsRecord) string {
-	name := strings.TrimSuffix(record.Fqdn, g.zoneSuffix)
+func (g *GandiProvider) parseName(z *rootZone, record utils.DnsRecord) string {
+	name := strings.TrimSuffix(record.Fqdn, z.zoneSuffix)
 	return name
 }
 
-func (g *GandiProvider) versionAddRecord(version int64, record utils.DnsRecord) error {
-	name := g.parseName(record)
+func (g *GandiProvider) versionAddRecord(z *rootZone, version int64, record utils.DnsRecord) error {
+	name := g.parseName(z, record)
 	for _, rec := range record.Records {
-		suffix := fmt.Sprintf(".%s.", g.zoneDomain)
+		suffix := fmt.Sprintf(".%s.", z.zoneDomain)
 		sub := strings.TrimSuffix(name, suffix)
 		logrus.Infof("Adding record %s", sub)
 		args := gandiRecord.RecordAdd{
-			Zone:    g.zone.Id,
+			Zone:    z.zone.Id,
 			Version: version,
 			Name:    sub,
 			Type:    record.Type,
@@ -249,15 +293,15 @@ func (g *GandiProvider) versionAddRecord(version int64, record utils.DnsRecord)
 	return nil
 }
 
-func (g *GandiProvider) versionRemoveRecord(version int64, record utils.DnsRecord) error {
-	records, err := g.findRecords(record, version)
+func (g *GandiProvider) versionRemoveRecord(z *rootZone, version int64, record utils.DnsRecord) error {
+	records, err := g.findRecords(z, record, version)
 	if err != nil {
 		return err
 	}
 
 	for _, rec := range records {
 		logrus.Infof("Removing record %s with ID %v", rec.Name, rec.Id)
-		_, err := g.record.Delete(g.zone.Id, version, rec.Id)
+		_, err := g.record.Delete(z.zone.Id, version, rec.Id)
 		if err != nil {
 			return fmt.Errorf("Failed to remove record: %v", err)
 		}
@@ -266,25 +310,25 @@ func (g *GandiProvider) versionRemoveRecord(version int64, record utils.DnsRecor
 	return nil
 }
 
-func (g *GandiProvider) newZoneVersion() (int64, error) {
+func (g *GandiProvider) newZoneVersion(z *rootZone) (int64, error) {
 	// Get latest zone version
-	zoneInfo, err := g.zoneHandler.Info(g.zone.Id)
+	zoneInfo, err := g.zoneHandler.Info(z.zone.Id)
 	if err != nil {
-		return 0, fmt.Errorf("Failed to refresh information for zone %s: %v", g.zone.Name, err)
+		return 0, fmt.Errorf("Failed to refresh information for zone %s: %v", z.zone.Name, err)
 	}
 
-	newVersion, err := g.zoneVersion.New(g.zone.Id, zoneInfo.Version)
+	newVersion, err := g.zoneVersion.New(z.zone.Id, zoneInfo.Version)
 	if err != nil {
-		return 0, fmt.Errorf("Failed to create new version of zone %s: %v", g.zone.Name, err)
+		return 0, fmt.Errorf("Failed to create new version of zone %s: %v", z.zone.Name, err)
 	}
 
 	return newVersion, nil
 }
 
-func (g *GandiProvider) setZoneVersion(version int64) error {
-	_, err := g.zoneVersion.Set(g.zone.Id, version)
+func (g *GandiProvider) setZoneVersion(z *rootZone, version int64) error {
+	_, err := g.zoneVersion.Set(z.zone.Id, version)
 	if err != nil {
-		return fmt.Errorf("Failed to set version of zone %s to %v: %v", g.zone.Name, version, err)
+		return fmt.Errorf("Failed to set version of zone %s to %v: %v", z.zone.Name, version, err)
 	}
 
 	return nil
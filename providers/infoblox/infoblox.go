@@ -3,10 +3,10 @@ package infoblox
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
-	"io/ioutil"
 
 	"github.com/Sirupsen/logrus"
 	api "github.com/fanatic/go-infoblox"
@@ -15,51 +15,68 @@ import (
 )
 
 const (
-	versionURL   = "/wapi/v1.5/"
-	authURL      = versionURL + "zone_auth"
-	recordURL    = versionURL + "record"
-	recordTxtURL = versionURL + "record:txt"
-	recordAURL   = versionURL + "record:a"
-	maxResults   = "1000"
-	firstPage    = "_return_as_object=1&_max_results=" + maxResults + "&_paging=1"
+	versionURL = "/wapi/v1.5/"
+	authURL    = versionURL + "zone_auth"
+	recordURL  = versionURL + "record"
+	maxResults = "1000"
+	firstPage  = "_return_as_object=1&_max_results=" + maxResults + "&_paging=1"
 )
 
 var (
-	recordAQuery   = "_return_fields=ttl,name,zone,disable,ipv4addr"
-	recordTxtQuery = "_return_fields=ttl,name,zone,disable,text"
-	head           = map[string]string{
+	// recordTypes are the record types read from the zones
+	recordTypes = []string{"A", "AAAA", "CNAME", "TXT", "MX", "SRV"}
+	// recordFields are the fields holding the value of each record type
+	recordFields = map[string]string{
+		"A":     "ipv4addr",
+		"AAAA":  "ipv6addr",
+		"CNAME": "canonical",
+		"TXT":   "text",
+		"MX":    "preference,mail_exchanger",
+		"SRV":   "priority,weight,port,target",
+	}
+	head = map[string]string{
 		"Content-Type": "application/json",
 	}
 )
 
 type Record struct {
-	Ref      string `json:"_ref"`
-	Fqdn     string `json:"fqdn"`
-	Name     string `json:"name"`
-	Zone     string `json:"zone"`
-	TTL      int    `json:"ttl"`
-	Disable  bool   `json:"disable"`
-	IPv4addr string `json:"ipv4addr"`
-	Text     string `json:"text"`
-	Type     string
-	Rec      string
+	Ref           string `json:"_ref"`
+	Fqdn          string `json:"fqdn"`
+	Name          string `json:"name"`
+	Zone          string `json:"zone"`
+	TTL           int    `json:"ttl"`
+	Disable       bool   `json:"disable"`
+	IPv4addr      string `json:"ipv4addr"`
+	IPv6addr      string `json:"ipv6addr"`
+	Canonical     string `json:"canonical"`
+	Text          string `json:"text"`
+	Preference    int    `json:"preference"`
+	MailExchanger string `json:"mail_exchanger"`
+	Priority      int    `json:"priority"`
+	Weight        int    `json:"weight"`
+	Port          int    `json:"port"`
+	Target        string `json:"target"`
+	Type          string
+	Rec           string
 }
 
 type ResultPagination struct {
-	Page_id	string 	  `json:"next_page_id"`
-	Result 	[]*Record `json:"result"`
+	Page_id string    `json:"next_page_id"`
+	Result  []*Record `json:"result"`
 }
 
 type InfobloxProvider struct {
-	client   *api.Client
-	zoneName string
+	client    *api.Client
+	zoneNames []string
 }
 
 func init() {
-	providers.RegisterProvider("infoblox", &InfobloxProvider{})
+	providers.RegisterProvider("infoblox", func() providers.Provider {
+		return &InfobloxProvider{}
+	})
 }
 
-func (d *InfobloxProvider) Init(rootDomainName string) error {
+func (d *InfobloxProvider) Init(rootDomainNames []string) error {
 	var url, userName, password, secretFile string
 	var sslVerify, useCookies bool
 	var err error
@@ -106,14 +123,24 @@ func (d *InfobloxProvider) Init(rootDomainName string) error {
 	}
 
 	d.client = api.NewClient(url, userName, password, sslVerify, useCookies)
-	d.zoneName = utils.UnFqdn(rootDomainName)
-
-	if err = d.validateZoneName(d.zoneName); err != nil {
-		return err
+	d.zoneNames = make([]string, len(rootDomainNames))
+	for idx, rootDomainName := range rootDomainNames {
+		d.zoneNames[idx] = utils.UnFqdn(rootDomainName)
+		if err = d.validateZoneName(d.zoneNames[idx]); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+func (d *InfobloxProvider) zoneForRecord(record utils.DnsRecord) (string, error) {
+	zoneName := utils.ZoneForFqdn(utils.UnFqdn(record.Fqdn), d.zoneNames)
+	if zoneName == "" {
+		return "", fmt.Errorf("No zone configured for '%s'", record.Fqdn)
+	}
+	return zoneName, nil
+}
+
 func (d *InfobloxProvider) validateZoneName(zoneName string) error {
 	zoneAuths, err := d.SendRequest("GET", authURL, "", nil)
 	if err != nil {
@@ -141,24 +168,25 @@ func (d *InfobloxProvider) HealthCheck() error {
 	return err
 }
 
-func (d *InfobloxProvider) AddRecord(record utils.DnsRecord) (err error) {
-	var url, body string
+func (d *InfobloxProvider) AddRecord(record utils.DnsRecord) error {
 	for _, rec := range record.Records {
-		if url, body, err = d.prepareRecord(rec, record.Type, record.Fqdn, record.TTL); err != nil {
+		if err := d.CreateValue(record, rec); err != nil {
 			return err
 		}
-		if _, err = d.SendRequest("POST", url, body, head); err != nil {
-			if strings.Contains(err.Error(), "already exists") {
-				return nil
-			}
-			return fmt.Errorf("Infoblox API call has failed: %v", err)
-		}
 	}
 	return nil
 }
 
 func (d *InfobloxProvider) findRecords(record utils.DnsRecord) ([]*Record, error) {
-	url := recordURL + ":" + strings.ToLower(record.Type) + "?name=" + utils.UnFqdn(record.Fqdn) + "&zone=" + d.zoneName
+	zoneName, err := d.zoneForRecord(record)
+	if err != nil {
+		return nil, err
+	}
+
+	url := recordTypeURL(record.Type) + "?name=" + utils.UnFqdn(record.Fqdn) + "&zone=" + zoneName
+	if _, ok := recordFields[record.Type]; ok {
+		url += "&" + recordQuery(record.Type)
+	}
 
 	records, err := d.SendRequest("GET", url, "", head)
 	if err != nil {
@@ -168,12 +196,26 @@ func (d *InfobloxProvider) findRecords(record utils.DnsRecord) ([]*Record, error
 	return records, nil
 }
 
+// UpdateRecord changes the records of the name and type in place
+// so that the name keeps resolving
 func (d *InfobloxProvider) UpdateRecord(record utils.DnsRecord) error {
-	if err := d.RemoveRecord(record); err != nil {
+	zoneName, err := d.zoneForRecord(record)
+	if err != nil {
 		return err
 	}
 
-	return d.AddRecord(record)
+	records, err := d.findRecords(record)
+	if err != nil {
+		return err
+	}
+
+	d.setRecordType(record.Type, records)
+	values := make([]providers.RecordValue, len(records))
+	for idx, rec := range records {
+		values[idx] = recordValue(zoneName, rec)
+	}
+
+	return providers.UpdateRecordValues(d, providers.GetRecordRules(d, record.Fqdn), values, record)
 }
 
 func (d *InfobloxProvider) RemoveRecord(record utils.DnsRecord) error {
@@ -183,80 +225,124 @@ func (d *InfobloxProvider) RemoveRecord(record utils.DnsRecord) error {
 	}
 
 	for _, rec := range records {
-		if _, err := d.SendRequest("DELETE", versionURL+rec.Ref, "", head); err != nil {
-			return fmt.Errorf("Infoblox API call has failed: %v", err)
+		if err := d.DeleteValue(record, providers.RecordValue{ID: rec.Ref}); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func (d *InfobloxProvider) GetRecords() ([]utils.DnsRecord, error) {
-	var records []utils.DnsRecord
-
-	recordAs, err := d.SendRequest("GET", recordAURL + "?" + recordAQuery + "&zone=" + d.zoneName, "", head)
+func (d *InfobloxProvider) CreateValue(record utils.DnsRecord, value string) error {
+	url, body, err := d.prepareRecord(value, record.Type, record.Fqdn, record.TTL)
 	if err != nil {
-		return records, fmt.Errorf("Infoblox API call decode has failed: %v", err)
+		return err
+	}
+	if _, err = d.SendRequest("POST", url, body, head); err != nil {
+		if strings.Contains(err.Error(), "already exists") {
+			return nil
+		}
+		return fmt.Errorf("Infoblox API call has failed: %v", err)
 	}
+	return nil
+}
 
-	recordTxts, err := d.SendRequest("GET", recordTxtURL + "?" + recordTxtQuery + "&zone=" + d.zoneName, "", head)
+// UpdateValue replaces the object of the existing value, whose ID is its reference
+func (d *InfobloxProvider) UpdateValue(record utils.DnsRecord, existing providers.RecordValue, value string) error {
+	_, body, err := d.prepareRecord(value, record.Type, record.Fqdn, record.TTL)
 	if err != nil {
-		return records, fmt.Errorf("Infoblox API call decode has failed: %v", err)
+		return err
 	}
+	if _, err = d.SendRequest("PUT", versionURL+existing.ID, body, head); err != nil {
+		return fmt.Errorf("Infoblox API call has failed: %v", err)
+	}
+	return nil
+}
 
-	d.setRecordType("A", recordAs)
-	d.setRecordType("TXT", recordTxts)
+func (d *InfobloxProvider) DeleteValue(record utils.DnsRecord, existing providers.RecordValue) error {
+	if _, err := d.SendRequest("DELETE", versionURL+existing.ID, "", head); err != nil {
+		return fmt.Errorf("Infoblox API call has failed: %v", err)
+	}
+	return nil
+}
 
-	recordMap := map[string]map[string][]string{}
-	recordTTLs := map[string]map[string]int{}
-	for _, rec := range append(recordAs, recordTxts...) {
-		if rec.Disable {
-			continue
+func (d *InfobloxProvider) GetRecords() ([]utils.DnsRecord, error) {
+	var records []utils.DnsRecord
+	for _, zoneName := range d.zoneNames {
+		zoneRecords, err := d.getZoneRecords(zoneName)
+		if err != nil {
+			return records, err
 		}
+		records = append(records, zoneRecords...)
+	}
+	return records, nil
+}
 
-		fqdn := fmt.Sprintf("%s.", rec.Name)
-		if rec.Name == "" {
-			fqdn = fmt.Sprintf("%s.", d.zoneName)
+func (d *InfobloxProvider) getZoneRecords(zoneName string) ([]utils.DnsRecord, error) {
+	var values []providers.RecordValue
+	for _, tp := range recordTypes {
+		records, err := d.SendRequest("GET", recordTypeURL(tp)+"?"+recordQuery(tp)+"&zone="+zoneName, "", head)
+		if err != nil {
+			return nil, fmt.Errorf("Infoblox API call decode has failed: %v", err)
 		}
 
-		recordTTLs[fqdn] = map[string]int{}
-		recordTTLs[fqdn][rec.Type] = rec.TTL
-		recordSet, exists := recordMap[fqdn]
-		if exists {
-			recordSlice, sliceExists := recordSet[rec.Type]
-			if sliceExists {
-				recordSlice = append(recordSlice, rec.Rec)
-				recordSet[rec.Type] = recordSlice
-			} else {
-				recordSet[rec.Type] = []string{rec.Rec}
+		d.setRecordType(tp, records)
+		for _, rec := range records {
+			if rec.Disable {
+				continue
 			}
-		} else {
-			recordMap[fqdn] = map[string][]string{}
-			recordMap[fqdn][rec.Type] = []string{rec.Rec}
+			values = append(values, recordValue(zoneName, rec))
 		}
 	}
 
-	for fqdn, recordSet := range recordMap {
-		for recordType, recordSlice := range recordSet {
-			ttl := recordTTLs[fqdn][recordType]
-			record := utils.DnsRecord{Fqdn: fqdn, Records: recordSlice, Type: recordType, TTL: ttl}
-			records = append(records, record)
-		}
-	}
-	return records, nil
+	return providers.GroupRecordValues(values), nil
+}
+
+func recordTypeURL(tp string) string {
+	return recordURL + ":" + strings.ToLower(tp)
+}
+
+func recordQuery(tp string) string {
+	return "_return_fields=ttl,name,zone,disable," + recordFields[tp]
 }
 
 func (d *InfobloxProvider) prepareRecord(rec string, tp string, fqdn string, ttl int) (string, string, error) {
-	var url string
 	body := make(map[string]interface{})
-	if tp == "TXT" {
-		body["text"] = rec
-		url = recordTxtURL
-	} else if tp == "A" {
+	fields := strings.Fields(rec)
+	switch tp {
+	case "A":
 		body["ipv4addr"] = rec
-		url = recordAURL
-	} else {
-		logrus.Warnf("Warning unsupport record type: %s", tp)
-		return "", "", nil
+	case "AAAA":
+		body["ipv6addr"] = rec
+	case "CNAME":
+		body["canonical"] = utils.UnFqdn(rec)
+	case "TXT":
+		body["text"] = rec
+	case "MX":
+		// 'preference host'
+		if len(fields) != 2 {
+			return "", "", fmt.Errorf("Invalid MX record value '%s'", rec)
+		}
+		preference, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return "", "", fmt.Errorf("Invalid MX record value '%s': %v", rec, err)
+		}
+		body["preference"] = preference
+		body["mail_exchanger"] = utils.UnFqdn(fields[1])
+	case "SRV":
+		// 'priority weight port target'
+		if len(fields) != 4 {
+			return "", "", fmt.Errorf("Invalid SRV record value '%s'", rec)
+		}
+		for idx, field := range []string{"priority", "weight", "port"} {
+			n, err := strconv.Atoi(fields[idx])
+			if err != nil {
+				return "", "", fmt.Errorf("Invalid SRV record value '%s': %v", rec, err)
+			}
+			body[field] = n
+		}
+		body["target"] = utils.UnFqdn(fields[3])
+	default:
+		return "", "", fmt.Errorf("Unsupported record type %s", tp)
 	}
 	body["name"] = utils.UnFqdn(fqdn)
 	body["ttl"] = ttl
@@ -265,16 +351,25 @@ func (d *InfobloxProvider) prepareRecord(rec string, tp string, fqdn string, ttl
 	if err != nil {
 		return "", "", fmt.Errorf("Error prepareRecord request: %v", err)
 	}
-	return url, string(bodyJSON), nil
+	return recordTypeURL(tp), string(bodyJSON), nil
 }
 
 func (d *InfobloxProvider) setRecordType(ty string, records []*Record) []*Record {
 	for _, v := range records {
 		v.Type = ty
-		if v.Type == "A" {
+		switch v.Type {
+		case "A":
 			v.Rec = v.IPv4addr
-		} else if v.Type == "TXT" {
+		case "AAAA":
+			v.Rec = v.IPv6addr
+		case "CNAME":
+			v.Rec = v.Canonical
+		case "TXT":
 			v.Rec = v.Text
+		case "MX":
+			v.Rec = fmt.Sprintf("%d %s", v.Preference, v.MailExchanger)
+		case "SRV":
+			v.Rec = fmt.Sprintf("%d %d %d %s", v.Priority, v.Weight, v.Port, v.Target)
 		}
 	}
 	return records
@@ -330,9 +425,24 @@ func (d *InfobloxProvider) SendRequest(method, urlStr, body string, head map[str
 		return records, nil
 	}
 
-	logrus.Debugf("SendRequest to infoblox: [method]%s, [url] %s, [body] %s, [head] %v", method, urlStr, body)
+	logrus.Debugf("SendRequest to infoblox: [method]%s, [url] %s, [body] %s, [head] %v", method, urlStr, body, head)
 	_, err := d.client.SendRequest(method, urlStr, body, head)
 
-	return nil, err	
+	return nil, err
 
 }
+
+func recordValue(zoneName string, rec *Record) providers.RecordValue {
+	fqdn := fmt.Sprintf("%s.", rec.Name)
+	if rec.Name == "" {
+		fqdn = fmt.Sprintf("%s.", zoneName)
+	}
+
+	return providers.RecordValue{
+		ID:    rec.Ref,
+		Fqdn:  fqdn,
+		Type:  rec.Type,
+		TTL:   rec.TTL,
+		Value: rec.Rec,
+	}
+}
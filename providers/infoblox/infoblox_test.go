@@ -0,0 +1,59 @@
+package infoblox
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rancher/external-dns/providers"
+)
+
+func TestRecordValueRoundTrip(t *testing.T) {
+	tests := []struct {
+		recordType string
+		value      string
+	}{
+		{"A", "10.0.0.1"},
+		{"AAAA", "2001:db8::1"},
+		{"CNAME", "origin.example.net."},
+		{"TXT", "v=spf1 -all"},
+		{"MX", "10 mail.example.com."},
+		{"SRV", "10 20 5060 sip.example.com."},
+	}
+
+	d := &InfobloxProvider{}
+	rules := providers.GetRecordRules(d, "www.example.com.")
+	for _, test := range tests {
+		url, body, err := d.prepareRecord(test.value, test.recordType, "www.example.com.", 300)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.recordType, err)
+			continue
+		}
+		if want := recordTypeURL(test.recordType); url != want {
+			t.Errorf("%s: got URL %s, want %s", test.recordType, url, want)
+		}
+
+		// the object written is read back with the same fields
+		var rec Record
+		if err := json.Unmarshal([]byte(body), &rec); err != nil {
+			t.Fatalf("%s: invalid body %s: %v", test.recordType, body, err)
+		}
+		d.setRecordType(test.recordType, []*Record{&rec})
+		value := recordValue("example.com", &rec).Value
+		if rules.NormalizeValue(test.recordType, value) != test.value {
+			t.Errorf("%s: got value %q, want %q", test.recordType, value, test.value)
+		}
+	}
+}
+
+func TestPrepareRecordInvalid(t *testing.T) {
+	d := &InfobloxProvider{}
+	for _, test := range []struct{ recordType, value string }{
+		{"MX", "mail.example.com."},
+		{"SRV", "10 x 5060 sip.example.com."},
+		{"NS", "ns.example.com."},
+	} {
+		if _, _, err := d.prepareRecord(test.value, test.recordType, "www.example.com.", 300); err == nil {
+			t.Errorf("%s %s: invalid record prepared", test.recordType, test.value)
+		}
+	}
+}
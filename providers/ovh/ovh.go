@@ -23,14 +23,16 @@ type Record struct {
 
 type OVHProvider struct {
 	client *api.Client
-	root   string
+	roots  []string
 }
 
 func init() {
-	providers.RegisterProvider("ovh", &OVHProvider{})
+	providers.RegisterProvider("ovh", func() providers.Provider {
+		return &OVHProvider{}
+	})
 }
 
-func (d *OVHProvider) Init(rootDomainName string) error {
+func (d *OVHProvider) Init(rootDomainNames []string) error {
 	var endpoint, applicationKey, applicationSecret, consumerKey string
 	if endpoint = os.Getenv("OVH_ENDPOINT"); len(endpoint) == 0 {
 		return fmt.Errorf("OVH_ENDPOINT is not set")
@@ -48,7 +50,10 @@ func (d *OVHProvider) Init(rootDomainName string) error {
 		return fmt.Errorf("OVH_CONSUMER_KEY is not set")
 	}
 
-	d.root = utils.UnFqdn(rootDomainName)
+	d.roots = make([]string, len(rootDomainNames))
+	for idx, rootDomainName := range rootDomainNames {
+		d.roots[idx] = utils.UnFqdn(rootDomainName)
+	}
 	client, err := api.NewClient(endpoint, applicationKey, applicationSecret, consumerKey)
 	if err != nil {
 		return fmt.Errorf("Failed to create OVH client: %v", err)
@@ -63,19 +68,21 @@ func (d *OVHProvider) Init(rootDomainName string) error {
 		return fmt.Errorf("Failed to list hosted zones: %v", err)
 	}
 
-	found := false
-	for _, zone := range zones {
-		if zone == d.root {
-			found = true
-			break
+	for _, root := range d.roots {
+		found := false
+		for _, zone := range zones {
+			if zone == root {
+				found = true
+				break
+			}
 		}
-	}
 
-	if !found {
-		return fmt.Errorf("Zone for '%s' not found", d.root)
+		if !found {
+			return fmt.Errorf("Zone for '%s' not found", root)
+		}
 	}
 
-	logrus.Infof("Configured %s with zone '%s'", d.GetName(), d.root)
+	logrus.Infof("Configured %s with zones %v", d.GetName(), d.roots)
 	return nil
 }
 
@@ -83,53 +90,59 @@ func (*OVHProvider) GetName() string {
 	return "OVH"
 }
 
+// OVH returns the targets of TXT records quoted
+func (*OVHProvider) RecordRules(fqdn string) providers.RecordRules {
+	return providers.RecordRules{QuotedTXT: true}
+}
+
 func (d *OVHProvider) HealthCheck() error {
 	var me interface{}
 	err := d.client.Get("/me", &me)
 	return err
 }
 
-func (d *OVHProvider) parseName(record utils.DnsRecord) string {
-	name := strings.TrimSuffix(record.Fqdn, fmt.Sprintf(".%s.", d.root))
+func (d *OVHProvider) zoneForRecord(record utils.DnsRecord) (string, error) {
+	root := utils.ZoneForFqdn(utils.UnFqdn(record.Fqdn), d.roots)
+	if root == "" {
+		return "", fmt.Errorf("No zone configured for '%s'", record.Fqdn)
+	}
+	return root, nil
+}
+
+func (d *OVHProvider) parseName(root string, record utils.DnsRecord) string {
+	name := strings.TrimSuffix(record.Fqdn, fmt.Sprintf(".%s.", root))
 	return name
 }
 
-func (d *OVHProvider) AddRecord(record utils.DnsRecord) (err error) {
-	var url string
-	var body interface{}
-	var resType interface{}
+func (d *OVHProvider) AddRecord(record utils.DnsRecord) error {
+	root, err := d.zoneForRecord(record)
+	if err != nil {
+		return err
+	}
 	for _, rec := range record.Records {
-		if url, body, err = d.prepareRecord(rec, record.Type, record.Fqdn, record.TTL); err != nil {
+		if err := d.CreateValue(record, rec); err != nil {
 			return err
 		}
-		if err = d.client.Post(url, body, resType); err != nil {
-			return fmt.Errorf("OVH API call `POST %s` with body `%s` has failed: %v", url, body, err)
-		}
 	}
-	d.refreshZone()
+	d.refreshZone(root)
 	return nil
 }
 
 func (d *OVHProvider) FindRecords(record utils.DnsRecord) ([]*Record, error) {
 	var records []*Record
 
-	urlRecIDs := strings.Join([]string{"/domain/zone/", d.root, "/record"}, "")
-
-	var recIDs []int64
-	err := d.client.Get(urlRecIDs, &recIDs)
-
+	root, err := d.zoneForRecord(record)
 	if err != nil {
-		return records, fmt.Errorf("OVH API call `GET %s` has failed: %v", urlRecIDs, err)
+		return records, err
 	}
 
-	name := d.parseName(record)
-	for _, recID := range recIDs {
-		urlRecord := strings.Join([]string{"/domain/zone/", d.root, "/record/", strconv.FormatInt(recID, 10)}, "")
-		var rec *Record
-		if err = d.client.Get(urlRecord, &rec); err != nil {
-			return records, fmt.Errorf("OVH API call `GET %s` has failed: %v", urlRecord, err)
-		}
+	zoneRecords, err := d.getZoneRecords(root)
+	if err != nil {
+		return records, err
+	}
 
+	name := d.parseName(root, record)
+	for _, rec := range zoneRecords {
 		if rec.SubDomain == name && rec.FieldType == record.Type {
 			records = append(records, rec)
 		}
@@ -138,102 +151,148 @@ func (d *OVHProvider) FindRecords(record utils.DnsRecord) ([]*Record, error) {
 	return records, nil
 }
 
+// UpdateRecord changes the records of the name and type in place
+// so that the name keeps resolving
 func (d *OVHProvider) UpdateRecord(record utils.DnsRecord) error {
-	err := d.RemoveRecord(record)
+	root, err := d.zoneForRecord(record)
+	if err != nil {
+		return err
+	}
+
+	records, err := d.FindRecords(record)
 	if err != nil {
 		return err
 	}
 
-	return d.AddRecord(record)
+	values := make([]providers.RecordValue, len(records))
+	for idx, rec := range records {
+		values[idx] = recordValue(rec)
+	}
+
+	if err := providers.UpdateRecordValues(d, providers.GetRecordRules(d, record.Fqdn), values, record); err != nil {
+		return err
+	}
+
+	d.refreshZone(root)
+
+	return nil
 }
 
 func (d *OVHProvider) RemoveRecord(record utils.DnsRecord) error {
+	root, err := d.zoneForRecord(record)
+	if err != nil {
+		return err
+	}
+
 	records, err := d.FindRecords(record)
 	if err != nil {
 		return err
 	}
 
 	for _, rec := range records {
-		urlRecord := strings.Join([]string{"/domain/zone/", d.root, "/record/", strconv.FormatInt(rec.ID, 10)}, "")
-		var resType interface{}
-		if err := d.client.Delete(urlRecord, &resType); err != nil {
-			return fmt.Errorf("OVH API call `DELETE %s` has failed: %v", urlRecord, err)
+		if err := d.DeleteValue(record, recordValue(rec)); err != nil {
+			return err
 		}
 	}
 
-	d.refreshZone()
+	d.refreshZone(root)
 
 	return nil
 }
 
-func (d *OVHProvider) GetRecords() ([]utils.DnsRecord, error) {
-	var dnsRecords []utils.DnsRecord
-
-	urlRecIDs := strings.Join([]string{"/domain/zone/", d.root, "/record"}, "")
+func (d *OVHProvider) CreateValue(record utils.DnsRecord, value string) error {
+	root, err := d.zoneForRecord(record)
+	if err != nil {
+		return err
+	}
 
-	var recIDs []int64
-	err := d.client.Get(urlRecIDs, &recIDs)
+	url, body, err := d.prepareRecord(root, value, record.Type, record.Fqdn, record.TTL)
+	if err != nil {
+		return err
+	}
+	var resType interface{}
+	if err := d.client.Post(url, body, resType); err != nil {
+		return fmt.Errorf("OVH API call `POST %s` with body `%s` has failed: %v", url, body, err)
+	}
+	return nil
+}
 
+func (d *OVHProvider) UpdateValue(record utils.DnsRecord, existing providers.RecordValue, value string) error {
+	root, err := d.zoneForRecord(record)
 	if err != nil {
-		return dnsRecords, fmt.Errorf("OVH API call `GET %s` has failed: %v", urlRecIDs, err)
+		return err
 	}
 
-	var records []*Record
-	for _, recID := range recIDs {
-		urlRecord := strings.Join([]string{"/domain/zone/", d.root, "/record/", strconv.FormatInt(recID, 10)}, "")
-		var record *Record
-		if err = d.client.Get(urlRecord, &record); err != nil {
-			return dnsRecords, fmt.Errorf("OVH API call `GET %s` has failed: %v", urlRecord, err)
-		}
-		records = append(records, record)
+	url, body, err := d.prepareRecord(root, value, record.Type, record.Fqdn, record.TTL)
+	if err != nil {
+		return err
+	}
+	// The type of a record cannot be changed
+	delete(body, "fieldType")
+	url = strings.Join([]string{url, "/", existing.ID}, "")
+	var resType interface{}
+	if err := d.client.Put(url, body, resType); err != nil {
+		return fmt.Errorf("OVH API call `PUT %s` with body `%s` has failed: %v", url, body, err)
 	}
+	return nil
+}
 
-	recordMap := map[string]map[string][]string{}
-	recordTTLs := map[string]map[string]int{}
+func (d *OVHProvider) DeleteValue(record utils.DnsRecord, existing providers.RecordValue) error {
+	root, err := d.zoneForRecord(record)
+	if err != nil {
+		return err
+	}
 
-	for _, rec := range records {
-		var fqdn string
+	urlRecord := strings.Join([]string{"/domain/zone/", root, "/record/", existing.ID}, "")
+	var resType interface{}
+	if err := d.client.Delete(urlRecord, &resType); err != nil {
+		return fmt.Errorf("OVH API call `DELETE %s` has failed: %v", urlRecord, err)
+	}
+	return nil
+}
 
-		if rec.SubDomain == "" {
-			fqdn = fmt.Sprintf("%s.", rec.Zone)
-		} else {
-			fqdn = fmt.Sprintf("%s.%s.", rec.SubDomain, rec.Zone)
+func (d *OVHProvider) GetRecords() ([]utils.DnsRecord, error) {
+	var values []providers.RecordValue
+	for _, root := range d.roots {
+		records, err := d.getZoneRecords(root)
+		if err != nil {
+			return nil, err
 		}
-
-		recordTTLs[fqdn] = map[string]int{}
-		recordTTLs[fqdn][rec.FieldType] = int(rec.TTL)
-		recordSet, exists := recordMap[fqdn]
-
-		if exists {
-			recordSlice, sliceExists := recordSet[rec.FieldType]
-			if sliceExists {
-				recordSlice = append(recordSlice, rec.Target)
-				recordSet[rec.FieldType] = recordSlice
-			} else {
-				recordSet[rec.FieldType] = []string{rec.Target}
-			}
-		} else {
-			recordMap[fqdn] = map[string][]string{}
-			recordMap[fqdn][rec.FieldType] = []string{rec.Target}
+		for _, rec := range records {
+			values = append(values, recordValue(rec))
 		}
 	}
 
-	for fqdn, recordSet := range recordMap {
-		for recordType, recordSlice := range recordSet {
-			ttl := recordTTLs[fqdn][recordType]
-			record := utils.DnsRecord{Fqdn: fqdn, Records: recordSlice, Type: recordType, TTL: ttl}
-			dnsRecords = append(dnsRecords, record)
+	return providers.GroupRecordValues(values), nil
+}
+
+// getZoneRecords returns all records of the zone
+func (d *OVHProvider) getZoneRecords(root string) ([]*Record, error) {
+	var records []*Record
+	urlRecIDs := strings.Join([]string{"/domain/zone/", root, "/record"}, "")
+
+	var recIDs []int64
+	if err := d.client.Get(urlRecIDs, &recIDs); err != nil {
+		return records, fmt.Errorf("OVH API call `GET %s` has failed: %v", urlRecIDs, err)
+	}
+
+	for _, recID := range recIDs {
+		urlRecord := strings.Join([]string{"/domain/zone/", root, "/record/", strconv.FormatInt(recID, 10)}, "")
+		var rec *Record
+		if err := d.client.Get(urlRecord, &rec); err != nil {
+			return records, fmt.Errorf("OVH API call `GET %s` has failed: %v", urlRecord, err)
 		}
+		records = append(records, rec)
 	}
 
-	return dnsRecords, nil
+	return records, nil
 }
 
-func (d *OVHProvider) prepareRecord(rec string, tp string, fqdn string, ttl int) (string, interface{}, error) {
+func (d *OVHProvider) prepareRecord(root string, rec string, tp string, fqdn string, ttl int) (string, map[string]interface{}, error) {
 	var url string
-	url = strings.Join([]string{"/domain/zone/", d.root, "/record"}, "")
+	url = strings.Join([]string{"/domain/zone/", root, "/record"}, "")
 	body := make(map[string]interface{})
-	name := strings.TrimSuffix(fqdn, fmt.Sprintf(".%s.", d.root))
+	name := strings.TrimSuffix(fqdn, fmt.Sprintf(".%s.", root))
 	body["fieldType"] = tp
 	body["subDomain"] = name
 	body["ttl"] = ttl
@@ -241,11 +300,26 @@ func (d *OVHProvider) prepareRecord(rec string, tp string, fqdn string, ttl int)
 	return url, body, nil
 }
 
-func (d *OVHProvider) refreshZone() error {
-	url := strings.Join([]string{"/domain/zone/", d.root, "/refresh"}, "")
+func (d *OVHProvider) refreshZone(root string) error {
+	url := strings.Join([]string{"/domain/zone/", root, "/refresh"}, "")
 	var resType interface{}
 	if err := d.client.Post(url, nil, &resType); err != nil {
 		return fmt.Errorf("OVH API call `POST %s` has failed: %v", url, err)
 	}
 	return nil
 }
+
+func recordValue(rec *Record) providers.RecordValue {
+	fqdn := fmt.Sprintf("%s.", rec.Zone)
+	if rec.SubDomain != "" {
+		fqdn = fmt.Sprintf("%s.%s.", rec.SubDomain, rec.Zone)
+	}
+
+	return providers.RecordValue{
+		ID:    strconv.FormatInt(rec.ID, 10),
+		Fqdn:  fqdn,
+		Type:  rec.FieldType,
+		TTL:   int(rec.TTL),
+		Value: rec.Target,
+	}
+}
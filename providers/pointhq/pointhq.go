@@ -3,6 +3,7 @@ package pointhq
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
@@ -13,15 +14,18 @@ import (
 
 type PointHQProvider struct {
 	client *pointdns.PointClient
-	root   string
-	zone   pointdns.Zone
+	roots  []string
+	// zones indexed by name
+	zones map[string]pointdns.Zone
 }
 
 func init() {
-	providers.RegisterProvider("pointhq", &PointHQProvider{})
+	providers.RegisterProvider("pointhq", func() providers.Provider {
+		return &PointHQProvider{}
+	})
 }
 
-func (d *PointHQProvider) Init(rootDomainName string) error {
+func (d *PointHQProvider) Init(rootDomainNames []string) error {
 	var email, apiToken string
 	if email = os.Getenv("POINTHQ_EMAIL"); len(email) == 0 {
 		return fmt.Errorf("POINTHQ_EMAIL is not set")
@@ -31,7 +35,10 @@ func (d *PointHQProvider) Init(rootDomainName string) error {
 		return fmt.Errorf("POINTHQ_TOKEN is not set")
 	}
 
-	d.root = utils.UnFqdn(rootDomainName)
+	d.roots = make([]string, len(rootDomainNames))
+	for idx, rootDomainName := range rootDomainNames {
+		d.roots[idx] = utils.UnFqdn(rootDomainName)
+	}
 	d.client = pointdns.NewClient(email, apiToken)
 
 	zones, err := d.client.Zones()
@@ -39,20 +46,22 @@ func (d *PointHQProvider) Init(rootDomainName string) error {
 		return fmt.Errorf("Failed to list hosted zones: %v", err)
 	}
 
-	found := false
+	d.zones = make(map[string]pointdns.Zone, len(d.roots))
 	for _, zone := range zones {
-		if zone.Name == d.root {
-			found = true
-			d.zone = zone
-			break
+		for _, root := range d.roots {
+			if zone.Name == root {
+				d.zones[root] = zone
+			}
 		}
 	}
 
-	if !found {
-		return fmt.Errorf("Zone for '%s' not found", d.root)
+	for _, root := range d.roots {
+		if _, ok := d.zones[root]; !ok {
+			return fmt.Errorf("Zone for '%s' not found", root)
+		}
 	}
 
-	logrus.Infof("Configured %s with zone '%s'", d.GetName(), d.root)
+	logrus.Infof("Configured %s with zones %v", d.GetName(), d.roots)
 	return nil
 }
 
@@ -60,29 +69,33 @@ func (*PointHQProvider) GetName() string {
 	return "PointHQ"
 }
 
+// PointHQ holds the priority of MX and SRV records in a field of its own
+func (*PointHQProvider) RecordRules(fqdn string) providers.RecordRules {
+	return providers.RecordRules{RecordTypes: providers.BasicRecordTypes}
+}
+
 func (d *PointHQProvider) HealthCheck() error {
 	_, err := d.client.Zones()
 	return err
 }
 
-func (d *PointHQProvider) parseName(record utils.DnsRecord) string {
-	name := strings.TrimSuffix(record.Fqdn, fmt.Sprintf(".%s.", d.root))
+func (d *PointHQProvider) zoneForRecord(record utils.DnsRecord) (pointdns.Zone, error) {
+	root := utils.ZoneForFqdn(utils.UnFqdn(record.Fqdn), d.roots)
+	if root == "" {
+		return pointdns.Zone{}, fmt.Errorf("No zone configured for '%s'", record.Fqdn)
+	}
+	return d.zones[root], nil
+}
+
+func (d *PointHQProvider) parseName(zone pointdns.Zone, record utils.DnsRecord) string {
+	name := strings.TrimSuffix(record.Fqdn, fmt.Sprintf(".%s.", zone.Name))
 	return name
 }
 
 func (d *PointHQProvider) AddRecord(record utils.DnsRecord) error {
-	name := d.parseName(record)
 	for _, rec := range record.Records {
-		recordInput := pointdns.Record{
-			Name:       name,
-			Ttl:        record.TTL,
-			RecordType: record.Type,
-			Data:       rec,
-			ZoneId:     d.zone.Id,
-		}
-		_, err := d.client.CreateRecord(&recordInput)
-		if err != nil {
-			return fmt.Errorf("PointHQ API call has failed: %v", err)
+		if err := d.CreateValue(record, rec); err != nil {
+			return err
 		}
 	}
 
@@ -91,7 +104,12 @@ func (d *PointHQProvider) AddRecord(record utils.DnsRecord) error {
 
 func (d *PointHQProvider) FindRecords(record utils.DnsRecord) ([]pointdns.Record, error) {
 	var records []pointdns.Record
-	resp, err := d.zone.Records()
+	zone, err := d.zoneForRecord(record)
+	if err != nil {
+		return records, err
+	}
+
+	resp, err := zone.Records()
 	if err != nil {
 		return records, fmt.Errorf("PointHQ API call has failed: %v", err)
 	}
@@ -105,13 +123,25 @@ func (d *PointHQProvider) FindRecords(record utils.DnsRecord) ([]pointdns.Record
 	return records, nil
 }
 
+// UpdateRecord changes the records of the name and type in place
+// so that the name keeps resolving
 func (d *PointHQProvider) UpdateRecord(record utils.DnsRecord) error {
-	err := d.RemoveRecord(record)
+	zone, err := d.zoneForRecord(record)
 	if err != nil {
 		return err
 	}
 
-	return d.AddRecord(record)
+	records, err := d.FindRecords(record)
+	if err != nil {
+		return err
+	}
+
+	values := make([]providers.RecordValue, len(records))
+	for idx, rec := range records {
+		values[idx] = recordValue(zone, rec)
+	}
+
+	return providers.UpdateRecordValues(d, providers.GetRecordRules(d, record.Fqdn), values, record)
 }
 
 func (d *PointHQProvider) RemoveRecord(record utils.DnsRecord) error {
@@ -130,49 +160,91 @@ func (d *PointHQProvider) RemoveRecord(record utils.DnsRecord) error {
 	return nil
 }
 
-func (d *PointHQProvider) GetRecords() ([]utils.DnsRecord, error) {
-	var records []utils.DnsRecord
-	recordResp, err := d.zone.Records()
+func (d *PointHQProvider) CreateValue(record utils.DnsRecord, value string) error {
+	return d.saveValue(record, 0, value)
+}
+
+func (d *PointHQProvider) UpdateValue(record utils.DnsRecord, existing providers.RecordValue, value string) error {
+	id, err := strconv.Atoi(existing.ID)
 	if err != nil {
-		return records, fmt.Errorf("PointHQ API call has failed: %v", err)
+		return fmt.Errorf("Invalid record ID %s: %v", existing.ID, err)
 	}
+	return d.saveValue(record, id, value)
+}
 
-	recordMap := map[string]map[string][]string{}
-	recordTTLs := map[string]map[string]int{}
+func (d *PointHQProvider) DeleteValue(record utils.DnsRecord, existing providers.RecordValue) error {
+	zone, err := d.zoneForRecord(record)
+	if err != nil {
+		return err
+	}
 
-	for _, rec := range recordResp {
-		var fqdn string
-		if rec.Name == "" {
-			fqdn = d.root + "."
-		} else {
-			fqdn = rec.Name
-		}
+	if err := d.client.Delete(fmt.Sprintf("zones/%d/records/%s", zone.Id, existing.ID), nil); err != nil {
+		return fmt.Errorf("PointHQ API call has failed: %v", err)
+	}
 
-		recordTTLs[fqdn] = map[string]int{}
-		recordTTLs[fqdn][rec.RecordType] = rec.Ttl
-		recordSet, exists := recordMap[fqdn]
-
-		if exists {
-			recordSlice, sliceExists := recordSet[rec.RecordType]
-			if sliceExists {
-				recordSlice = append(recordSlice, rec.Data)
-				recordSet[rec.RecordType] = recordSlice
-			} else {
-				recordSet[rec.RecordType] = []string{rec.Data}
-			}
-		} else {
-			recordMap[fqdn] = map[string][]string{}
-			recordMap[fqdn][rec.RecordType] = []string{rec.Data}
-		}
+	return nil
+}
+
+// saveValue creates a record holding the value, or replaces
+// the record of the given ID unless the ID is 0
+func (d *PointHQProvider) saveValue(record utils.DnsRecord, id int, value string) error {
+	zone, err := d.zoneForRecord(record)
+	if err != nil {
+		return err
 	}
 
-	for fqdn, recordSet := range recordMap {
-		for recordType, recordSlice := range recordSet {
-			ttl := recordTTLs[fqdn][recordType]
-			record := utils.DnsRecord{Fqdn: fqdn, Records: recordSlice, Type: recordType, TTL: ttl}
-			records = append(records, record)
-		}
+	recordInput := pointdns.Record{
+		Id:         id,
+		Name:       d.parseName(zone, record),
+		Ttl:        record.TTL,
+		RecordType: record.Type,
+		Data:       value,
+		ZoneId:     zone.Id,
+	}
+	if _, err := d.client.CreateRecord(&recordInput); err != nil {
+		return fmt.Errorf("PointHQ API call has failed: %v", err)
 	}
 
+	return nil
+}
+
+func (d *PointHQProvider) GetRecords() ([]utils.DnsRecord, error) {
+	var records []utils.DnsRecord
+	for _, root := range d.roots {
+		zoneRecords, err := d.getZoneRecords(d.zones[root])
+		if err != nil {
+			return records, err
+		}
+		records = append(records, zoneRecords...)
+	}
 	return records, nil
 }
+
+func (d *PointHQProvider) getZoneRecords(zone pointdns.Zone) ([]utils.DnsRecord, error) {
+	recordResp, err := zone.Records()
+	if err != nil {
+		return nil, fmt.Errorf("PointHQ API call has failed: %v", err)
+	}
+
+	values := make([]providers.RecordValue, len(recordResp))
+	for idx, rec := range recordResp {
+		values[idx] = recordValue(zone, rec)
+	}
+
+	return providers.GroupRecordValues(values), nil
+}
+
+func recordValue(zone pointdns.Zone, rec pointdns.Record) providers.RecordValue {
+	fqdn := rec.Name
+	if rec.Name == "" {
+		fqdn = zone.Name + "."
+	}
+
+	return providers.RecordValue{
+		ID:    strconv.Itoa(rec.Id),
+		Fqdn:  fqdn,
+		Type:  rec.RecordType,
+		TTL:   rec.Ttl,
+		Value: rec.Data,
+	}
+}
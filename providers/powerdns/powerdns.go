@@ -11,15 +11,18 @@ import (
 )
 
 type PdnsProvider struct {
-	client *powerdns.PowerDNS
-	root   string
+	// clients indexed by zone name
+	clients map[string]*powerdns.PowerDNS
+	roots   []string
 }
 
 func init() {
-	providers.RegisterProvider("powerdns", &PdnsProvider{})
+	providers.RegisterProvider("powerdns", func() providers.Provider {
+		return &PdnsProvider{}
+	})
 }
 
-func (d *PdnsProvider) Init(rootDomainName string) error {
+func (d *PdnsProvider) Init(rootDomainNames []string) error {
 	var url, apiKey string
 	if url = os.Getenv("POWERDNS_URL"); len(url) == 0 {
 		return fmt.Errorf("POWERDNS_URL is not set")
@@ -29,19 +32,25 @@ func (d *PdnsProvider) Init(rootDomainName string) error {
 		return fmt.Errorf("POWERDNS_API_KEY is not set")
 	}
 
-	var err error
-	d.root = utils.UnFqdn(rootDomainName)
-	d.client, err = powerdns.New(url, "", d.root, apiKey)
-	if err != nil {
-		return fmt.Errorf("Failed to initialize provider for '%s': %v", d.root, err)
-	}
+	d.clients = make(map[string]*powerdns.PowerDNS, len(rootDomainNames))
+	d.roots = make([]string, len(rootDomainNames))
+	for idx, rootDomainName := range rootDomainNames {
+		root := utils.UnFqdn(rootDomainName)
+		client, err := powerdns.New(url, "", root, apiKey)
+		if err != nil {
+			return fmt.Errorf("Failed to initialize provider for '%s': %v", root, err)
+		}
 
-	_, err = d.client.GetRecords()
-	if err != nil {
-		return fmt.Errorf("Failed to list records for '%s': %v", d.root, err)
+		_, err = client.GetRecords()
+		if err != nil {
+			return fmt.Errorf("Failed to list records for '%s': %v", root, err)
+		}
+
+		d.clients[root] = client
+		d.roots[idx] = root
 	}
 
-	logrus.Infof("Configured %s with zone '%s'", d.GetName(), d.root)
+	logrus.Infof("Configured %s with zones %v", d.GetName(), d.roots)
 	return nil
 }
 
@@ -50,8 +59,20 @@ func (*PdnsProvider) GetName() string {
 }
 
 func (d *PdnsProvider) HealthCheck() error {
-	_, err := d.client.GetRecords()
-	return err
+	for _, client := range d.clients {
+		if _, err := client.GetRecords(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *PdnsProvider) clientForRecord(record utils.DnsRecord) (*powerdns.PowerDNS, error) {
+	root := utils.ZoneForFqdn(d.parseName(record), d.roots)
+	if root == "" {
+		return nil, fmt.Errorf("No zone configured for '%s'", record.Fqdn)
+	}
+	return d.clients[root], nil
 }
 
 // utils.DnsRecord.Fqdn has a trailing. | powerdns.Record.Name doesn't
@@ -61,8 +82,13 @@ func (d *PdnsProvider) parseName(record utils.DnsRecord) string {
 
 func (d *PdnsProvider) AddRecord(record utils.DnsRecord) error {
 	logrus.Debugf("Called AddRecord with: %v\n", record)
+	client, err := d.clientForRecord(record)
+	if err != nil {
+		return err
+	}
+
 	name := d.parseName(record)
-	err := d.client.AddRecord(name, record.Type, record.TTL, record.Records)
+	err = client.AddRecord(name, record.Type, record.TTL, record.Records)
 	if err != nil {
 		logrus.Errorf("Failed to add Record for %s : %v", name, err)
 		return err
@@ -70,9 +96,9 @@ func (d *PdnsProvider) AddRecord(record utils.DnsRecord) error {
 	return nil
 }
 
-func (d *PdnsProvider) findRecords(record utils.DnsRecord) ([]powerdns.Record, error) {
+func (d *PdnsProvider) findRecords(client *powerdns.PowerDNS, record utils.DnsRecord) ([]powerdns.Record, error) {
 	var records []powerdns.Record
-	resp, err := d.client.GetRecords()
+	resp, err := client.GetRecords()
 	if err != nil {
 		return records, fmt.Errorf("PowerDNS API call has failed: %v", err)
 	}
@@ -89,14 +115,11 @@ func (d *PdnsProvider) findRecords(record utils.DnsRecord) ([]powerdns.Record, e
 	return records, nil
 }
 
+// UpdateRecord replaces the RRset of the name and type in a single
+// change, which PowerDNS applies atomically
 func (d *PdnsProvider) UpdateRecord(record utils.DnsRecord) error {
 	logrus.Debugf("Called UpdateRecord with: %v\n", record)
 
-	err := d.RemoveRecord(record)
-	if err != nil {
-		return err
-	}
-
 	return d.AddRecord(record)
 }
 
@@ -104,14 +127,19 @@ func (d *PdnsProvider) UpdateRecord(record utils.DnsRecord) error {
 func (d *PdnsProvider) RemoveRecord(record utils.DnsRecord) error {
 	logrus.Debugf("Called RemoveRecord with: %v\n", record)
 
-	records, err := d.findRecords(record)
+	client, err := d.clientForRecord(record)
+	if err != nil {
+		return err
+	}
+
+	records, err := d.findRecords(client, record)
 	if err != nil {
 		return err
 	}
 
 	name := d.parseName(record)
 	for _, rec := range records {
-		err := d.client.DeleteRecord(name, record.Type, record.TTL, []string{rec.Content})
+		err := client.DeleteRecord(name, record.Type, record.TTL, []string{rec.Content})
 		if err != nil {
 			return fmt.Errorf("PowerDNS API call has failed: %v", err)
 		}
@@ -122,43 +150,28 @@ func (d *PdnsProvider) RemoveRecord(record utils.DnsRecord) error {
 
 func (d *PdnsProvider) GetRecords() ([]utils.DnsRecord, error) {
 	logrus.Debug("Called GetRecords")
-	var records []utils.DnsRecord
-
-	pdnsRecords, err := d.client.GetRecords()
-	if err != nil {
-		return records, fmt.Errorf("PowerDNS API call has failed: %v", err)
+	var pdnsRecords []powerdns.Record
+	for _, root := range d.roots {
+		zoneRecords, err := d.clients[root].GetRecords()
+		if err != nil {
+			return nil, fmt.Errorf("PowerDNS API call has failed: %v", err)
+		}
+		pdnsRecords = append(pdnsRecords, zoneRecords...)
 	}
 
+	var values []providers.RecordValue
 	for _, rec := range pdnsRecords {
 		logrus.Debugf("%v\n", rec)
 		if rec.Disabled == true {
 			continue
 		}
 
-		name := fmt.Sprintf("%s.", rec.Name)
-		// need to combine records with the same name
-		found := false
-		for i, re := range records {
-			if re.Fqdn == name {
-				found = true
-				cont := append(re.Records, rec.Content)
-				records[i] = utils.DnsRecord{
-					Fqdn:    name,
-					Records: cont,
-					Type:    rec.Type,
-					TTL:     rec.TTL,
-				}
-			}
-		}
-		if !found {
-			r := utils.DnsRecord{
-				Fqdn:    name,
-				Records: []string{rec.Content},
-				Type:    rec.Type,
-				TTL:     rec.TTL,
-			}
-			records = append(records, r)
-		}
+		values = append(values, providers.RecordValue{
+			Fqdn:  fmt.Sprintf("%s.", rec.Name),
+			Type:  rec.Type,
+			TTL:   rec.TTL,
+			Value: rec.Content,
+		})
 	}
-	return records, nil
+	return providers.GroupRecordValues(values), nil
 }
@@ -7,7 +7,7 @@ import (
 )
 
 type Provider interface {
-	Init(rootDomainName string) error
+	Init(rootDomainNames []string) error
 	GetName() string
 	HealthCheck() error
 	AddRecord(record utils.DnsRecord) error
@@ -16,13 +16,33 @@ type Provider interface {
 	GetRecords() ([]utils.DnsRecord, error)
 }
 
+// ChangeWaiter is implemented by providers whose changes take
+// effect asynchronously. WaitForChanges blocks until the changes
+// made since it was last called are live.
+type ChangeWaiter interface {
+	WaitForChanges() error
+}
+
+// OwnerSetter is implemented by providers that create resources besides
+// records. SetOwner is called with the identity of the owner of the
+// records so that the provider only reuses and deletes its resources.
+type OwnerSetter interface {
+	SetOwner(owner string)
+}
+
+// Factory returns a new, uninitialized provider
+type Factory func() Provider
+
 var (
-	providers = make(map[string]Provider)
+	providers = make(map[string]Factory)
 )
 
-func GetProvider(name, rootDomainName string) (Provider, error) {
-	if provider, ok := providers[name]; ok {
-		if err := provider.Init(rootDomainName); err != nil {
+// GetProvider returns a new instance of the named provider
+// initialized with the given root domains.
+func GetProvider(name string, rootDomainNames []string) (Provider, error) {
+	if factory, ok := providers[name]; ok {
+		provider := factory()
+		if err := provider.Init(rootDomainNames); err != nil {
 			return nil, err
 		}
 		return provider, nil
@@ -30,9 +50,9 @@ func GetProvider(name, rootDomainName string) (Provider, error) {
 	return nil, fmt.Errorf("No such provider '%s'", name)
 }
 
-func RegisterProvider(name string, provider Provider) {
+func RegisterProvider(name string, factory Factory) {
 	if _, exists := providers[name]; exists {
 		logrus.Fatalf("Provider '%s' tried to register twice", name)
 	}
-	providers[name] = provider
+	providers[name] = factory
 }
@@ -5,6 +5,7 @@ import (
 	"net"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -16,22 +17,30 @@ import (
 const (
 	// maximum size of a UDP transport message in DNS protocol
 	udpMaxMsgSize = 512
+	// timeout of each exchange with the name server
+	exchangeTimeout = 10 * time.Second
 )
 
 type RFC2136Provider struct {
-	nameserver  string
-	zoneName    string
-	tsigKeyName string
-	tsigSecret  string
-	insecure    bool
+	nameserver string
+	zoneNames  []string
+	// key signing the messages, nil if insecure
+	key *tsigKey
 }
 
 func init() {
-	providers.RegisterProvider("rfc2136", &RFC2136Provider{})
+	providers.RegisterProvider("rfc2136", func() providers.Provider {
+		return &RFC2136Provider{}
+	})
 }
 
-func (r *RFC2136Provider) Init(rootDomainName string) error {
-	var host, port, keyName, secret string
+// Init configures the name server and the TSIG key, which is read
+// from the BIND key file RFC2136_TSIG_KEYFILE or given by the name
+// RFC2136_TSIG_KEYNAME, the secret RFC2136_TSIG_SECRET and the
+// algorithm RFC2136_TSIG_ALGORITHM, hmac-md5 by default. The key
+// must authorise updates of all zones.
+func (r *RFC2136Provider) Init(rootDomainNames []string) error {
+	var host, port string
 	var insecure bool
 	var err error
 
@@ -52,49 +61,112 @@ func (r *RFC2136Provider) Init(rootDomainName string) error {
 	}
 
 	if !insecure {
-		if keyName = os.Getenv("RFC2136_TSIG_KEYNAME"); len(keyName) == 0 {
-			return fmt.Errorf("RFC2136_TSIG_KEYNAME is not set")
-		}
-
-		if secret = os.Getenv("RFC2136_TSIG_SECRET"); len(secret) == 0 {
-			return fmt.Errorf("RFC2136_TSIG_SECRET is not set")
+		if r.key, err = tsigKeyFromEnv(); err != nil {
+			return err
 		}
 	}
 
 	r.nameserver = net.JoinHostPort(host, port)
-	r.zoneName = dns.Fqdn(rootDomainName)
-	if !insecure {
-		r.tsigKeyName = dns.Fqdn(keyName)
-		r.tsigSecret = secret
+	r.zoneNames = make([]string, len(rootDomainNames))
+	for idx, rootDomainName := range rootDomainNames {
+		r.zoneNames[idx] = dns.Fqdn(rootDomainName)
 	}
 
-	r.insecure = insecure
+	for _, zoneName := range r.zoneNames {
+		if err := r.checkUpdates(zoneName); err != nil {
+			return fmt.Errorf("Updates of zone '%s' are not authorised: %v", zoneName, err)
+		}
+	}
 
-	logrus.Infof("Configured %s with zone '%s' and nameserver '%s'",
-		r.GetName(), r.zoneName, r.nameserver)
+	if r.key != nil {
+		logrus.Infof("Configured %s with zones %v, nameserver '%s' and key '%s' using %s",
+			r.GetName(), r.zoneNames, r.nameserver, r.key.name, strings.TrimSuffix(r.key.algorithm, "."))
+	} else {
+		logrus.Infof("Configured %s with zones %v and nameserver '%s'",
+			r.GetName(), r.zoneNames, r.nameserver)
+	}
 
 	return nil
 }
 
+func tsigKeyFromEnv() (*tsigKey, error) {
+	if keyFile := os.Getenv("RFC2136_TSIG_KEYFILE"); len(keyFile) > 0 {
+		key, err := readKeyFile(keyFile, os.Getenv("RFC2136_TSIG_KEYNAME"))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading RFC2136_TSIG_KEYFILE %s: %v", keyFile, err)
+		}
+		return key, nil
+	}
+
+	var keyName, secret, algorithm string
+	if keyName = os.Getenv("RFC2136_TSIG_KEYNAME"); len(keyName) == 0 {
+		return nil, fmt.Errorf("RFC2136_TSIG_KEYNAME is not set")
+	}
+
+	if secret = os.Getenv("RFC2136_TSIG_SECRET"); len(secret) == 0 {
+		return nil, fmt.Errorf("RFC2136_TSIG_SECRET is not set")
+	}
+
+	if algorithm = os.Getenv("RFC2136_TSIG_ALGORITHM"); len(algorithm) == 0 {
+		algorithm = defaultTsigAlgorithm
+	}
+
+	return newTsigKey(keyName, algorithm, secret)
+}
+
+// checkUpdates sends an update of the zone that has only the
+// prerequisite that the SOA record of the zone exists, which
+// the name server refuses unless the key may update the zone.
+// BIND zones configured with update-policy instead of allow-update
+// accept such an update from any valid key as it changes no record,
+// so there the check only proves the key is known to the server.
+func (r *RFC2136Provider) checkUpdates(zoneName string) error {
+	m := new(dns.Msg)
+	m.SetUpdate(zoneName)
+	m.RRsetUsed([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: zoneName, Rrtype: dns.TypeSOA}}})
+	return r.sendMessage(m)
+}
+
 func (*RFC2136Provider) GetName() string {
 	return "RFC2136"
 }
 
+// Name servers may return names and
+// targets in a different case
+func (*RFC2136Provider) RecordRules(fqdn string) providers.RecordRules {
+	return providers.RecordRules{CaseInsensitive: true}
+}
+
 func (r *RFC2136Provider) HealthCheck() error {
-	m := new(dns.Msg)
-	m.SetQuestion(r.zoneName, dns.TypeSOA)
-	err := r.sendMessage(m)
-	if err != nil {
-		return fmt.Errorf("Failed to query zone SOA record: %v", err)
+	for _, zoneName := range r.zoneNames {
+		m := new(dns.Msg)
+		m.SetQuestion(zoneName, dns.TypeSOA)
+		err := r.sendMessage(m)
+		if err != nil {
+			return fmt.Errorf("Failed to query zone SOA record of '%s': %v", zoneName, err)
+		}
 	}
 
 	return nil
 }
 
+func (r *RFC2136Provider) zoneForRecord(record utils.DnsRecord) (string, error) {
+	zoneName := utils.ZoneForFqdn(record.Fqdn, r.zoneNames)
+	if zoneName == "" {
+		return "", fmt.Errorf("No zone configured for '%s'", record.Fqdn)
+	}
+	return zoneName, nil
+}
+
 func (r *RFC2136Provider) AddRecord(record utils.DnsRecord) error {
 	logrus.Debugf("Adding RRset '%s %s'", record.Fqdn, record.Type)
+	zoneName, err := r.zoneForRecord(record)
+	if err != nil {
+		return err
+	}
+
 	m := new(dns.Msg)
-	m.SetUpdate(r.zoneName)
+	m.SetUpdate(zoneName)
 	rrs := make([]dns.RR, 0)
 	for _, rec := range record.Records {
 		logrus.Debugf("Adding RR: '%s %d %s %s'", record.Fqdn, record.TTL, record.Type, rec)
@@ -106,7 +178,7 @@ func (r *RFC2136Provider) AddRecord(record utils.DnsRecord) error {
 	}
 
 	m.Insert(rrs)
-	err := r.sendMessage(m)
+	err = r.sendMessage(m)
 	if err != nil {
 		return fmt.Errorf("RFC2136 query failed: %v", err)
 	}
@@ -116,16 +188,20 @@ func (r *RFC2136Provider) AddRecord(record utils.DnsRecord) error {
 
 func (r *RFC2136Provider) RemoveRecord(record utils.DnsRecord) error {
 	logrus.Debugf("Removing RRset '%s %s'", record.Fqdn, record.Type)
-	m := new(dns.Msg)
-	m.SetUpdate(r.zoneName)
-	rr, err := dns.NewRR(fmt.Sprintf("%s 0 %s 0.0.0.0", record.Fqdn, record.Type))
+	zoneName, err := r.zoneForRecord(record)
 	if err != nil {
-		return fmt.Errorf("Could not construct RR: %v", err)
+		return err
 	}
 
-	rrs := make([]dns.RR, 1)
-	rrs[0] = rr
-	m.RemoveRRset(rrs)
+	m := new(dns.Msg)
+	m.SetUpdate(zoneName)
+	// the RRset is removed by name and type, the data is ignored
+	rrType, ok := dns.StringToType[record.Type]
+	if !ok {
+		return fmt.Errorf("Could not construct RR: unknown type %s", record.Type)
+	}
+	rr := &dns.ANY{Hdr: dns.RR_Header{Name: record.Fqdn, Rrtype: rrType, Class: dns.ClassINET}}
+	m.RemoveRRset([]dns.RR{rr})
 	err = r.sendMessage(m)
 	if err != nil {
 		return fmt.Errorf("RFC2136 query failed: %v", err)
@@ -145,9 +221,13 @@ func (r *RFC2136Provider) UpdateRecord(record utils.DnsRecord) error {
 
 func (r *RFC2136Provider) GetRecords() ([]utils.DnsRecord, error) {
 	records := make([]utils.DnsRecord, 0)
-	list, err := r.list()
-	if err != nil {
-		return records, err
+	var list []dns.RR
+	for _, zoneName := range r.zoneNames {
+		zoneList, err := r.list(zoneName)
+		if err != nil {
+			return records, err
+		}
+		list = append(list, zoneList...)
 	}
 
 OuterLoop:
@@ -173,6 +253,14 @@ OuterLoop:
 		case dns.TypeTXT:
 			rrValues = rr.(*dns.TXT).Txt
 			rrType = "TXT"
+		case dns.TypeMX:
+			mx := rr.(*dns.MX)
+			rrValues = []string{fmt.Sprintf("%d %s", mx.Preference, mx.Mx)}
+			rrType = "MX"
+		case dns.TypeSRV:
+			srv := rr.(*dns.SRV)
+			rrValues = []string{fmt.Sprintf("%d %d %d %s", srv.Priority, srv.Weight, srv.Port, srv.Target)}
+			rrType = "SRV"
 		default:
 			continue // Unhandled record type
 		}
@@ -197,61 +285,165 @@ OuterLoop:
 	return records, nil
 }
 
+// sendMessage exchanges the message with the name server
+// and checks the response is successful and signed
 func (r *RFC2136Provider) sendMessage(msg *dns.Msg) error {
-	c := new(dns.Client)
-	c.SingleInflight = true
-
-	if !r.insecure {
-		c.TsigSecret = map[string]string{r.tsigKeyName: r.tsigSecret}
-		msg.SetTsig(r.tsigKeyName, dns.HmacMD5, 300, time.Now().Unix())
+	buf, mac, err := r.pack(msg)
+	if err != nil {
+		return err
 	}
 
 	// use TCP transport if message exceeds the UDP payload limit
-	if msg.Len() > udpMaxMsgSize {
-		c.Net = "tcp"
+	network := "udp"
+	if len(buf) > udpMaxMsgSize {
+		network = "tcp"
 	}
 
-	resp, _, err := c.Exchange(msg, r.nameserver)
+	resp, respBuf, err := r.exchange(network, buf, msg.Id)
 	if err != nil {
 		return err
 	}
 
-	if resp != nil && resp.Rcode != dns.RcodeSuccess {
-		return fmt.Errorf("Bad return code: %s", dns.RcodeToString[resp.Rcode])
+	// retry over TCP if the response did not fit into a UDP message
+	if resp.Truncated && network == "udp" {
+		logrus.Debugf("Response from '%s' is truncated, retrying over TCP", r.nameserver)
+		if resp, respBuf, err = r.exchange("tcp", buf, msg.Id); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	_, err = r.checkResponse(resp, respBuf, mac)
+	return err
+}
+
+// exchange sends the message in wire format to the name server
+// over the network and reads the response to it
+func (r *RFC2136Provider) exchange(network string, buf []byte, id uint16) (*dns.Msg, []byte, error) {
+	co, err := dns.DialTimeout(network, r.nameserver, exchangeTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer co.Close()
+
+	co.SetDeadline(time.Now().Add(exchangeTimeout))
+	if _, err := co.Write(buf); err != nil {
+		return nil, nil, err
+	}
+
+	return readResponse(co, id)
+}
+
+// pack returns the message in wire format and its MAC,
+// which are signed with the key unless it is nil
+func (r *RFC2136Provider) pack(msg *dns.Msg) ([]byte, []byte, error) {
+	if r.key == nil {
+		buf, err := msg.Pack()
+		return buf, nil, err
+	}
+	return r.key.sign(msg, nil, false)
+}
+
+// readResponse reads the response to the message of the given ID
+// and returns it along with its wire format
+func readResponse(co *dns.Conn, id uint16) (*dns.Msg, []byte, error) {
+	buf, err := co.ReadMsgHeader(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// truncated responses may be cut off anywhere after the header
+	resp := new(dns.Msg)
+	if err := resp.Unpack(buf); err != nil && !resp.Truncated {
+		return nil, nil, err
+	}
+	if resp.Id != id {
+		return nil, nil, fmt.Errorf("Response has ID %d instead of %d", resp.Id, id)
+	}
+
+	return resp, buf, nil
 }
 
-func (r *RFC2136Provider) list() ([]dns.RR, error) {
-	logrus.Debugf("Fetching records for '%s'", r.zoneName)
-	t := new(dns.Transfer)
-	if !r.insecure {
-		t.TsigSecret = map[string]string{r.tsigKeyName: r.tsigSecret}
+// checkResponse returns the MAC of the response to the message with
+// the given MAC, and an error unless the response is successful and
+// signed with the key, if any
+func (r *RFC2136Provider) checkResponse(resp *dns.Msg, buf, requestMAC []byte) ([]byte, error) {
+	var mac []byte
+	var err error
+	if r.key != nil {
+		mac, err = r.key.verify(buf, requestMAC, nil, false)
+	}
+
+	if resp.Rcode != dns.RcodeSuccess {
+		if err != nil {
+			return nil, fmt.Errorf("Bad return code: %s (%v)", dns.RcodeToString[resp.Rcode], err)
+		}
+		return nil, fmt.Errorf("Bad return code: %s", dns.RcodeToString[resp.Rcode])
 	}
 
+	return mac, err
+}
+
+// list transfers the records of the zone. Each message of the transfer
+// after the first one is signed with the MAC of the previous signed
+// message, and unsigned messages in between are covered by the next
+// signature.
+func (r *RFC2136Provider) list(zoneName string) ([]dns.RR, error) {
+	logrus.Debugf("Fetching records for '%s'", zoneName)
 	m := new(dns.Msg)
-	m.SetAxfr(r.zoneName)
-	if !r.insecure {
-		m.SetTsig(r.tsigKeyName, dns.HmacMD5, 300, time.Now().Unix())
+	m.SetAxfr(zoneName)
+	buf, mac, err := r.pack(m)
+	if err != nil {
+		return nil, err
 	}
 
-	env, err := t.In(m, r.nameserver)
+	co, err := dns.DialTimeout("tcp", r.nameserver, exchangeTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to fetch records via AXFR: %v", err)
 	}
+	defer co.Close()
+
+	co.SetDeadline(time.Now().Add(exchangeTimeout))
+	if _, err := co.Write(buf); err != nil {
+		return nil, fmt.Errorf("Failed to fetch records via AXFR: %v", err)
+	}
 
 	records := make([]dns.RR, 0)
-	for e := range env {
-		if e.Error != nil {
-			if e.Error == dns.ErrSoa {
-				logrus.Error("AXFR error: unexpected response received from the server")
-			} else {
-				logrus.Errorf("AXFR error: %v", e.Error)
+	var unsigned []byte
+	for first := true; ; first = false {
+		co.SetReadDeadline(time.Now().Add(exchangeTimeout))
+		resp, respBuf, err := readResponse(co, m.Id)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to fetch records via AXFR: %v", err)
+		}
+
+		if first {
+			if mac, err = r.checkResponse(resp, respBuf, mac); err != nil {
+				return nil, fmt.Errorf("Failed to fetch records via AXFR: %v", err)
 			}
-			continue
+			if len(resp.Answer) == 0 || resp.Answer[0].Header().Rrtype != dns.TypeSOA {
+				return nil, fmt.Errorf("Failed to fetch records via AXFR: unexpected response received from the server")
+			}
+		} else if resp.Rcode != dns.RcodeSuccess {
+			return nil, fmt.Errorf("Failed to fetch records via AXFR: Bad return code: %s", dns.RcodeToString[resp.Rcode])
+		} else if r.key != nil && resp.IsTsig() == nil {
+			unsigned = append(unsigned, respBuf...)
+		} else if r.key != nil {
+			if mac, err = r.key.verify(respBuf, mac, unsigned, true); err != nil {
+				return nil, fmt.Errorf("Failed to fetch records via AXFR: %v", err)
+			}
+			unsigned = nil
 		}
-		records = append(records, e.RR...)
+
+		records = append(records, resp.Answer...)
+
+		last := len(resp.Answer) - 1
+		if last >= 0 && (!first || last > 0) && resp.Answer[last].Header().Rrtype == dns.TypeSOA {
+			break
+		}
+	}
+
+	if unsigned != nil {
+		return nil, fmt.Errorf("Failed to fetch records via AXFR: last message is not signed")
 	}
 
 	return records, nil
@@ -0,0 +1,201 @@
+package rfc2136
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rancher/external-dns/utils"
+)
+
+// testServer is a name server on the loopback interface
+// that signs its responses with the test secret
+type testServer struct {
+	addr string
+	udp  *dns.Server
+	tcp  *dns.Server
+
+	mu sync.Mutex
+	// networks the updates were received over
+	updates []string
+	// truncate makes UDP responses to updates truncated
+	truncate bool
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{}
+	tsigSecret := map[string]string{"key.": testSecret}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Fatal(err)
+	}
+	s.addr = pc.LocalAddr().String()
+
+	started := make(chan struct{}, 2)
+	s.udp = &dns.Server{PacketConn: pc, TsigSecret: tsigSecret, NotifyStartedFunc: func() { started <- struct{}{} },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) { s.serve(w, req, "udp") })}
+	s.tcp = &dns.Server{Listener: l, TsigSecret: tsigSecret, NotifyStartedFunc: func() { started <- struct{}{} },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) { s.serve(w, req, "tcp") })}
+	go s.udp.ActivateAndServe()
+	go s.tcp.ActivateAndServe()
+	<-started
+	<-started
+	return s
+}
+
+func (s *testServer) close() {
+	s.udp.Shutdown()
+	s.tcp.Shutdown()
+}
+
+func (s *testServer) serve(w dns.ResponseWriter, req *dns.Msg, network string) {
+	ts := req.IsTsig()
+	if ts == nil || w.TsigStatus() != nil {
+		m := new(dns.Msg)
+		m.SetRcode(req, dns.RcodeNotAuth)
+		w.WriteMsg(m)
+		return
+	}
+
+	if req.Question[0].Qtype == dns.TypeAXFR {
+		soa, _ := dns.NewRR("example.com. 300 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 300")
+		a, _ := dns.NewRR("www.example.com. 300 IN A 10.0.0.1")
+		b, _ := dns.NewRR("www.example.com. 300 IN A 10.0.0.2")
+		txt, _ := dns.NewRR(`external-dns-uuid.example.com. 300 IN TXT "A:www.example.com."`)
+		mx, _ := dns.NewRR("example.com. 300 IN MX 10 mail.example.com.")
+		srv, _ := dns.NewRR("_sip._tcp.example.com. 300 IN SRV 10 20 5060 sip.example.com.")
+		for _, answer := range [][]dns.RR{{soa, a}, {b, txt}, {mx, srv}, {soa}} {
+			m := new(dns.Msg)
+			m.SetReply(req)
+			m.Answer = answer
+			m.SetTsig(ts.Hdr.Name, ts.Algorithm, tsigFudge, time.Now().Unix())
+			w.WriteMsg(m)
+			// later messages of the transfer are signed with the timers only
+			w.TsigTimersOnly(true)
+		}
+		return
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(req)
+	if req.Opcode == dns.OpcodeUpdate {
+		s.mu.Lock()
+		s.updates = append(s.updates, network)
+		m.Truncated = s.truncate && network == "udp"
+		s.mu.Unlock()
+	}
+	m.SetTsig(ts.Hdr.Name, ts.Algorithm, tsigFudge, time.Now().Unix())
+	w.WriteMsg(m)
+}
+
+func newTestProvider(t *testing.T, addr, secret string) *RFC2136Provider {
+	key, err := newTsigKey("key", "hmac-sha256", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &RFC2136Provider{
+		nameserver: addr,
+		zoneNames:  []string{"example.com."},
+		key:        key,
+	}
+}
+
+func TestGetRecordsAXFR(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+	r := newTestProvider(t, s.addr, testSecret)
+
+	records, err := r.GetRecords()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string][]string{
+		"www.example.com. A":                 {"10.0.0.1", "10.0.0.2"},
+		"external-dns-uuid.example.com. TXT": {"A:www.example.com."},
+		"example.com. MX":                    {"10 mail.example.com."},
+		"_sip._tcp.example.com. SRV":         {"10 20 5060 sip.example.com."},
+	}
+	if len(records) != len(want) {
+		t.Errorf("got records %v, want %v", records, want)
+	}
+	for _, rec := range records {
+		values, ok := want[utils.RecordKey(rec)]
+		if !ok || !sameStrings(rec.Records, values) {
+			t.Errorf("got record %v, want values %v", rec, values)
+		}
+	}
+}
+
+func TestGetRecordsAXFRBadKey(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+	r := newTestProvider(t, s.addr, "b3RoZXJzZWNyZXQ=")
+
+	if _, err := r.GetRecords(); err == nil {
+		t.Errorf("transfer with an unknown secret succeeded")
+	}
+}
+
+func TestCheckUpdates(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+
+	if err := newTestProvider(t, s.addr, testSecret).checkUpdates("example.com."); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := newTestProvider(t, s.addr, "b3RoZXJzZWNyZXQ=").checkUpdates("example.com."); err == nil {
+		t.Errorf("update with an unknown secret succeeded")
+	}
+}
+
+func TestSendMessageRetriesTruncatedOverTCP(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+	s.mu.Lock()
+	s.truncate = true
+	s.mu.Unlock()
+	r := newTestProvider(t, s.addr, testSecret)
+
+	record := utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}}
+	if err := r.AddRecord(record); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !sameStrings(s.updates, []string{"udp", "tcp"}) {
+		t.Errorf("got updates over %v, want udp followed by tcp", s.updates)
+	}
+}
+
+func TestRemoveRecordMX(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+	r := newTestProvider(t, s.addr, testSecret)
+
+	record := utils.DnsRecord{Fqdn: "example.com.", Type: "MX", TTL: 300, Records: []string{"10 mail.example.com."}}
+	if err := r.RemoveRecord(record); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
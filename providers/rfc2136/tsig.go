@@ -0,0 +1,359 @@
+package rfc2136
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultTsigAlgorithm = "hmac-md5"
+	// seconds the clocks of both ends may differ by
+	tsigFudge = 300
+)
+
+// The vendored DNS library only knows some of the algorithms
+// servers offer, so messages are signed and verified here
+var tsigAlgorithms = map[string]func() hash.Hash{
+	dns.HmacMD5:    md5.New,
+	dns.HmacSHA1:   sha1.New,
+	"hmac-sha224.": sha256.New224,
+	dns.HmacSHA256: sha256.New,
+	"hmac-sha384.": sha512.New384,
+	dns.HmacSHA512: sha512.New,
+}
+
+// tsigKey is a key shared with the name server that
+// signs the messages sent to it (RFC 2845)
+type tsigKey struct {
+	name      string
+	algorithm string
+	secret    []byte
+}
+
+// newTsigKey returns the key of the given name, algorithm,
+// such as hmac-sha256, and base64 encoded secret
+func newTsigKey(name, algorithm, secret string) (*tsigKey, error) {
+	algorithm = strings.ToLower(dns.Fqdn(algorithm))
+	if algorithm == "hmac-md5." {
+		algorithm = dns.HmacMD5
+	}
+	if _, ok := tsigAlgorithms[algorithm]; !ok {
+		return nil, fmt.Errorf("Unsupported TSIG algorithm '%s'", strings.TrimSuffix(algorithm, "."))
+	}
+
+	rawSecret, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TSIG secret of key '%s': %v", name, err)
+	}
+
+	return &tsigKey{
+		name:      strings.ToLower(dns.Fqdn(name)),
+		algorithm: algorithm,
+		secret:    rawSecret,
+	}, nil
+}
+
+// sign returns the message in wire format with a TSIG record
+// appended and the MAC of the record. Messages following the
+// first one of a zone transfer are signed with the MAC of the
+// previous message and only the timers.
+func (k *tsigKey) sign(msg *dns.Msg, previousMAC []byte, timersOnly bool) ([]byte, []byte, error) {
+	buf, err := msg.Pack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rr := &dns.TSIG{
+		Hdr:        dns.RR_Header{Name: k.name, Rrtype: dns.TypeTSIG, Class: dns.ClassANY},
+		Algorithm:  k.algorithm,
+		TimeSigned: uint64(time.Now().Unix()),
+		Fudge:      tsigFudge,
+		OrigId:     msg.Id,
+	}
+
+	mac, err := k.mac(rr, previousMAC, timersOnly, buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	rr.MAC = hex.EncodeToString(mac)
+	rr.MACSize = uint16(len(mac))
+
+	rrBuf := make([]byte, dns.MaxMsgSize)
+	off, err := dns.PackRR(rr, rrBuf, 0, nil, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	buf = append(buf, rrBuf[:off]...)
+	binary.BigEndian.PutUint16(buf[10:], binary.BigEndian.Uint16(buf[10:])+1)
+
+	return buf, mac, nil
+}
+
+// verify checks the TSIG record of a response in wire format to a
+// message with the given MAC and returns the MAC of the response.
+// The unsigned messages of a zone transfer received since the last
+// signed one are passed as well, as they are covered by the MAC.
+func (k *tsigKey) verify(buf, requestMAC, unsigned []byte, timersOnly bool) ([]byte, error) {
+	stripped, rr, err := stripTsig(buf)
+	if err != nil {
+		return nil, err
+	}
+	if rr == nil {
+		return nil, fmt.Errorf("Response is not signed")
+	}
+	if strings.ToLower(rr.Hdr.Name) != k.name || strings.ToLower(rr.Algorithm) != k.algorithm {
+		return nil, fmt.Errorf("Response is signed with key '%s' using %s", rr.Hdr.Name, rr.Algorithm)
+	}
+	if rr.Error != dns.RcodeSuccess {
+		return nil, fmt.Errorf("Signature was rejected: %s", dns.RcodeToString[int(rr.Error)])
+	}
+
+	now := time.Now().Unix()
+	if skew := now - int64(rr.TimeSigned); skew > int64(rr.Fudge) || -skew > int64(rr.Fudge) {
+		return nil, fmt.Errorf("Response was signed %d seconds apart from local time", skew)
+	}
+
+	mac, err := hex.DecodeString(rr.MAC)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := k.mac(rr, requestMAC, timersOnly, unsigned, stripped)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, expected) {
+		return nil, fmt.Errorf("Response has a bad signature")
+	}
+
+	return mac, nil
+}
+
+// mac computes the MAC of the messages in wire format
+// and the TSIG variables of the record (RFC 2845 3.4)
+func (k *tsigKey) mac(rr *dns.TSIG, requestMAC []byte, timersOnly bool, msgs ...[]byte) ([]byte, error) {
+	h := hmac.New(tsigAlgorithms[k.algorithm], k.secret)
+	if requestMAC != nil {
+		h.Write(appendUint16(nil, uint16(len(requestMAC))))
+		h.Write(requestMAC)
+	}
+	for _, msg := range msgs {
+		h.Write(msg)
+	}
+
+	vars := make([]byte, 0, 2*256+16)
+	if !timersOnly {
+		name, err := packName(rr.Hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, name...)
+		vars = appendUint16(vars, dns.ClassANY)
+		vars = append(vars, 0, 0, 0, 0) // TTL
+		algorithm, err := packName(rr.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, algorithm...)
+	}
+	vars = append(vars, byte(rr.TimeSigned>>40), byte(rr.TimeSigned>>32), byte(rr.TimeSigned>>24),
+		byte(rr.TimeSigned>>16), byte(rr.TimeSigned>>8), byte(rr.TimeSigned))
+	vars = appendUint16(vars, rr.Fudge)
+	if !timersOnly {
+		otherData, err := hex.DecodeString(rr.OtherData)
+		if err != nil {
+			return nil, err
+		}
+		vars = appendUint16(vars, rr.Error)
+		vars = appendUint16(vars, uint16(len(otherData)))
+		vars = append(vars, otherData...)
+	}
+	h.Write(vars)
+
+	return h.Sum(nil), nil
+}
+
+// stripTsig returns the message in wire format without its TSIG
+// record, which is nil if the message is not signed
+func stripTsig(buf []byte) ([]byte, *dns.TSIG, error) {
+	if len(buf) < 12 {
+		return nil, nil, fmt.Errorf("Message is too short")
+	}
+	qdCount := int(binary.BigEndian.Uint16(buf[4:]))
+	rrCount := int(binary.BigEndian.Uint16(buf[6:])) +
+		int(binary.BigEndian.Uint16(buf[8:])) +
+		int(binary.BigEndian.Uint16(buf[10:]))
+
+	off := 12
+	for i := 0; i < qdCount; i++ {
+		var err error
+		if _, off, err = dns.UnpackDomainName(buf, off); err != nil {
+			return nil, nil, err
+		}
+		off += 4 // type and class
+	}
+
+	for i := 0; i < rrCount; i++ {
+		start := off
+		rr, next, err := dns.UnpackRR(buf, off)
+		if err != nil {
+			return nil, nil, err
+		}
+		off = next
+		if tsig, ok := rr.(*dns.TSIG); ok {
+			if i != rrCount-1 {
+				return nil, nil, fmt.Errorf("TSIG is not the last record")
+			}
+			// the MAC covers the message with its original ID
+			stripped := append([]byte{}, buf[:start]...)
+			binary.BigEndian.PutUint16(stripped, tsig.OrigId)
+			binary.BigEndian.PutUint16(stripped[10:], binary.BigEndian.Uint16(stripped[10:])-1)
+			return stripped, tsig, nil
+		}
+	}
+
+	return buf, nil, nil
+}
+
+// packName returns the name in canonical wire format
+func packName(name string) ([]byte, error) {
+	buf := make([]byte, 256)
+	off, err := dns.PackDomainName(strings.ToLower(dns.Fqdn(name)), buf, 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:off], nil
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+// readKeyFile returns the key of the given name, or the first key
+// if the name is empty, from a file of BIND key statements like
+//
+//	key "name" {
+//		algorithm hmac-sha256;
+//		secret "c2VjcmV0";
+//	};
+//
+// as written by tsig-keygen and ddns-confgen
+func readKeyFile(path, name string) (*tsigKey, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := tokenizeKeyFile(string(content))
+	next := func() string {
+		if len(tokens) == 0 {
+			return ""
+		}
+		token := tokens[0]
+		tokens = tokens[1:]
+		return token
+	}
+	expect := func(want string) error {
+		if got := next(); got != want {
+			return fmt.Errorf("Expected '%s' but found '%s'", want, got)
+		}
+		return nil
+	}
+
+	for len(tokens) > 0 {
+		if err := expect("key"); err != nil {
+			return nil, err
+		}
+		keyName := next()
+		if err := expect("{"); err != nil {
+			return nil, err
+		}
+
+		algorithm, secret := defaultTsigAlgorithm, ""
+		for len(tokens) > 0 && tokens[0] != "}" {
+			switch option := next(); option {
+			case "algorithm":
+				algorithm = next()
+			case "secret":
+				secret = next()
+			default:
+				return nil, fmt.Errorf("Unknown option '%s' of key '%s'", option, keyName)
+			}
+			if err := expect(";"); err != nil {
+				return nil, err
+			}
+		}
+		if err := expect("}"); err != nil {
+			return nil, err
+		}
+		if err := expect(";"); err != nil {
+			return nil, err
+		}
+
+		if name != "" && dns.Fqdn(strings.ToLower(keyName)) != dns.Fqdn(strings.ToLower(name)) {
+			continue
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("Key '%s' has no secret", keyName)
+		}
+		return newTsigKey(keyName, algorithm, secret)
+	}
+
+	if name != "" {
+		return nil, fmt.Errorf("No key '%s' found", name)
+	}
+	return nil, fmt.Errorf("No key found")
+}
+
+// tokenizeKeyFile splits the configuration into words, the contents
+// of quoted strings and the characters {, } and ; leaving out comments
+func tokenizeKeyFile(content string) []string {
+	var tokens []string
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '#' || strings.HasPrefix(content[i:], "//"):
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(content[i:], "/*"):
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+		case c == '{' || c == '}' || c == ';':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			end := strings.IndexByte(content[i+1:], '"')
+			if end < 0 {
+				return append(tokens, content[i+1:])
+			}
+			tokens = append(tokens, content[i+1:i+1+end])
+			i += end + 2
+		default:
+			start := i
+			for i < len(content) && !unicode.IsSpace(rune(content[i])) && !strings.ContainsRune("{};\"#", rune(content[i])) {
+				i++
+			}
+			tokens = append(tokens, content[start:i])
+		}
+	}
+	return tokens
+}
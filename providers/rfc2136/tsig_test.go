@@ -0,0 +1,237 @@
+package rfc2136
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const testSecret = "c2VjcmV0c2VjcmV0"
+
+func TestTokenizeKeyFile(t *testing.T) {
+	tests := []struct {
+		content string
+		tokens  []string
+	}{
+		{
+			content: `key "name" { algorithm hmac-sha256; secret "c2VjcmV0"; };`,
+			tokens:  []string{"key", "name", "{", "algorithm", "hmac-sha256", ";", "secret", "c2VjcmV0", ";", "}", ";"},
+		},
+		{
+			content: "# comment\nkey name{// comment\nsecret \"a b\";/* multi\nline */};",
+			tokens:  []string{"key", "name", "{", "secret", "a b", ";", "}", ";"},
+		},
+		{
+			content: `key "unterminated`,
+			tokens:  []string{"key", "unterminated"},
+		},
+		{
+			content: "key /* unterminated",
+			tokens:  []string{"key"},
+		},
+		{
+			content: " \n\t",
+		},
+	}
+
+	for _, test := range tests {
+		if tokens := tokenizeKeyFile(test.content); !reflect.DeepEqual(tokens, test.tokens) {
+			t.Errorf("tokenizeKeyFile(%q) = %q, want %q", test.content, tokens, test.tokens)
+		}
+	}
+}
+
+func TestReadKeyFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		keyName   string
+		algorithm string
+		err       string
+	}{
+		{
+			name: "tsig-keygen output",
+			content: `key "update-key" {
+	algorithm hmac-sha256;
+	secret "` + testSecret + `";
+};
+`,
+			keyName:   "update-key.",
+			algorithm: dns.HmacSHA256,
+		},
+		{
+			name: "comments and default algorithm",
+			content: `# generated
+// by hand
+/* key "commented" { secret "x"; }; */
+key update-key { secret "` + testSecret + `"; };
+`,
+			keyName:   "update-key.",
+			algorithm: dns.HmacMD5,
+		},
+		{
+			name: "first of several keys",
+			content: `key "first" { algorithm hmac-sha1; secret "` + testSecret + `"; };
+key "second" { algorithm hmac-sha512; secret "` + testSecret + `"; };`,
+			keyName:   "first.",
+			algorithm: dns.HmacSHA1,
+		},
+		{
+			name:    "missing secret",
+			content: `key "update-key" { algorithm hmac-sha256; };`,
+			err:     "Key 'update-key' has no secret",
+		},
+		{
+			name:    "unknown option",
+			content: `key "update-key" { secret "` + testSecret + `"; port 53; };`,
+			err:     "Unknown option 'port' of key 'update-key'",
+		},
+		{
+			name:    "missing semicolon",
+			content: `key "update-key" { secret "` + testSecret + `" }`,
+			err:     "Expected ';' but found '}'",
+		},
+		{
+			name:    "no key",
+			content: "# empty\n",
+			err:     "No key found",
+		},
+	}
+
+	for _, test := range tests {
+		key, err := readTestKeyFile(t, test.content, "")
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got error %v, want %s", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if key.name != test.keyName || key.algorithm != test.algorithm || string(key.secret) != "secretsecret" {
+			t.Errorf("%s: got key %s using %s with secret %q", test.name, key.name, key.algorithm, key.secret)
+		}
+	}
+}
+
+func TestReadKeyFileByName(t *testing.T) {
+	content := `key "first" { algorithm hmac-sha1; secret "` + testSecret + `"; };
+key "second" { algorithm hmac-sha512; secret "` + testSecret + `"; };
+key "third" { algorithm hmac-sha256; };`
+
+	key, err := readTestKeyFile(t, content, "Second.")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if key.name != "second." || key.algorithm != dns.HmacSHA512 {
+		t.Errorf("got key %s using %s, want second. using %s", key.name, key.algorithm, dns.HmacSHA512)
+	}
+
+	if _, err := readTestKeyFile(t, content, "third"); err == nil || err.Error() != "Key 'third' has no secret" {
+		t.Errorf("got error %v for key without secret", err)
+	}
+	if _, err := readTestKeyFile(t, content, "fourth"); err == nil || err.Error() != "No key 'fourth' found" {
+		t.Errorf("got error %v for missing key", err)
+	}
+}
+
+func readTestKeyFile(t *testing.T, content, name string) (*tsigKey, error) {
+	f, err := ioutil.TempFile("", "tsig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	return readKeyFile(f.Name(), name)
+}
+
+var testAlgorithms = []string{dns.HmacMD5, dns.HmacSHA1, dns.HmacSHA256, dns.HmacSHA512}
+
+func testMessage() *dns.Msg {
+	m := new(dns.Msg)
+	m.SetUpdate("example.com.")
+	rr, _ := dns.NewRR("www.example.com. 300 IN A 10.0.0.1")
+	m.Insert([]dns.RR{rr})
+	return m
+}
+
+// TestSignVerifiedByLibrary checks that the DNS library accepts the
+// signatures of messages and of the following messages of a transfer
+func TestSignVerifiedByLibrary(t *testing.T) {
+	for _, algorithm := range testAlgorithms {
+		key, err := newTsigKey("key", algorithm, testSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf, mac, err := key.sign(testMessage(), nil, false)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", algorithm, err)
+			continue
+		}
+		if err := dns.TsigVerify(buf, testSecret, "", false); err != nil {
+			t.Errorf("%s: signature rejected: %v", algorithm, err)
+		}
+
+		buf, _, err = key.sign(testMessage(), mac, true)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", algorithm, err)
+			continue
+		}
+		if err := dns.TsigVerify(buf, testSecret, hex.EncodeToString(mac), true); err != nil {
+			t.Errorf("%s: timers only signature rejected: %v", algorithm, err)
+		}
+	}
+}
+
+// TestVerifySignedByLibrary checks that responses signed by
+// the DNS library are accepted and tampered ones are not
+func TestVerifySignedByLibrary(t *testing.T) {
+	requestMAC := []byte("request-mac")
+	for _, algorithm := range testAlgorithms {
+		key, err := newTsigKey("key", algorithm, testSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, timersOnly := range []bool{false, true} {
+			m := testMessage()
+			m.SetTsig("key.", algorithm, tsigFudge, time.Now().Unix())
+			buf, libraryMAC, err := dns.TsigGenerate(m, testSecret, hex.EncodeToString(requestMAC), timersOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mac, err := key.verify(buf, requestMAC, nil, timersOnly)
+			if err != nil {
+				t.Errorf("%s (timers only %v): signature rejected: %v", algorithm, timersOnly, err)
+				continue
+			}
+			if hex.EncodeToString(mac) != libraryMAC {
+				t.Errorf("%s (timers only %v): got MAC %x, want %s", algorithm, timersOnly, mac, libraryMAC)
+			}
+
+			if _, err := key.verify(buf, []byte("other-mac"), nil, timersOnly); err == nil {
+				t.Errorf("%s (timers only %v): signature of another request accepted", algorithm, timersOnly)
+			}
+
+			tampered := append([]byte{}, buf...)
+			idx := bytes.Index(tampered, []byte{10, 0, 0, 1})
+			tampered[idx+3] = 2
+			if _, err := key.verify(tampered, requestMAC, nil, timersOnly); err == nil {
+				t.Errorf("%s (timers only %v): tampered message accepted", algorithm, timersOnly)
+			}
+		}
+	}
+}
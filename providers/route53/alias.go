@@ -0,0 +1,69 @@
+package route53
+
+import (
+	"strings"
+
+	"github.com/rancher/external-dns/utils"
+)
+
+const (
+	// hosted zone ID of all CloudFront distributions
+	cloudFrontHostedZoneId = "Z2FDTNDATAQYW2"
+)
+
+// canonical hosted zone IDs of classic and application
+// load balancers indexed by region
+var elbHostedZoneIds = map[string]string{
+	"us-east-1":      "Z35SXDOTRQ7X7K",
+	"us-east-2":      "Z3AADJGX6KTTL2",
+	"us-west-1":      "Z368ELLRRE2KJ0",
+	"us-west-2":      "Z1H1FL5HABSF5",
+	"ca-central-1":   "ZQSVJUPU6J1EY",
+	"eu-central-1":   "Z215JYRZR1TBD5",
+	"eu-west-1":      "Z32O12XQLNTSW2",
+	"eu-west-2":      "ZHURV8PSTC4K8",
+	"eu-west-3":      "Z3Q77PNBQS71R4",
+	"ap-south-1":     "ZP97RAFLXTNZK",
+	"ap-northeast-1": "Z14GRHDCWA56QT",
+	"ap-northeast-2": "ZWKZPGTI48KDX",
+	"ap-southeast-1": "Z1LMS91P8CMLE5",
+	"ap-southeast-2": "Z1GM3OXH4ZPM65",
+	"sa-east-1":      "Z2P70J7HTTTPLU",
+}
+
+// canonical hosted zone IDs of network load balancers indexed by region
+var nlbHostedZoneIds = map[string]string{
+	"us-east-1":      "Z26RNL4JYFTOTI",
+	"us-east-2":      "ZLMOA37VPKANP",
+	"us-west-1":      "Z24FKFUX50B4VW",
+	"us-west-2":      "Z18D5FSROUN65G",
+	"ca-central-1":   "Z2EPGBW3API2WT",
+	"eu-central-1":   "Z3F0SRJ5LGBH90",
+	"eu-west-1":      "Z2IFOLAFXWLO4F",
+	"eu-west-2":      "ZD4D7Y8KGAS4G",
+	"eu-west-3":      "Z1CMS0P5QUZ6D5",
+	"ap-south-1":     "ZVDDRBQ08TROA",
+	"ap-northeast-1": "Z31USIVHYNEOWT",
+	"ap-northeast-2": "ZIBE1TIR4HY56",
+	"ap-southeast-1": "ZKVM4W9LS7TM",
+	"ap-southeast-2": "ZCT6FZBF4DROD",
+	"sa-east-1":      "ZTK26PT1VY4CU",
+}
+
+// canonicalHostedZoneId returns the hosted zone ID of a known AWS
+// alias target, or an empty string if it cannot be detected
+func canonicalHostedZoneId(dnsName string) string {
+	labels := strings.Split(strings.ToLower(utils.UnFqdn(dnsName)), ".")
+	n := len(labels)
+	switch {
+	case n >= 2 && labels[n-2] == "cloudfront" && labels[n-1] == "net":
+		return cloudFrontHostedZoneId
+	case n >= 4 && labels[n-3] == "elb" && labels[n-2] == "amazonaws" && labels[n-1] == "com":
+		// [dualstack.]name-id.region.elb.amazonaws.com
+		return elbHostedZoneIds[labels[n-4]]
+	case n >= 4 && labels[n-4] == "elb" && labels[n-2] == "amazonaws" && labels[n-1] == "com":
+		// name-id.elb.region.amazonaws.com
+		return nlbHostedZoneIds[labels[n-3]]
+	}
+	return ""
+}
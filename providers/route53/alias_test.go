@@ -0,0 +1,31 @@
+package route53
+
+import (
+	"testing"
+)
+
+func TestCanonicalHostedZoneId(t *testing.T) {
+	tests := []struct {
+		dnsName string
+		zoneId  string
+	}{
+		{"d111111abcdef8.cloudfront.net", cloudFrontHostedZoneId},
+		{"D111111ABCDEF8.CloudFront.net.", cloudFrontHostedZoneId},
+		{"my-lb-1234567890.eu-west-1.elb.amazonaws.com", "Z32O12XQLNTSW2"},
+		{"dualstack.my-lb-1234567890.us-east-1.elb.amazonaws.com.", "Z35SXDOTRQ7X7K"},
+		{"internal-my-lb-1234567890.ap-southeast-2.elb.amazonaws.com", "Z1GM3OXH4ZPM65"},
+		{"my-nlb-1234567890.elb.us-west-2.amazonaws.com", "Z18D5FSROUN65G"},
+		{"my-lb-1234567890.xx-nowhere-1.elb.amazonaws.com", ""},
+		{"my-nlb-1234567890.elb.xx-nowhere-1.amazonaws.com", ""},
+		{"elb.amazonaws.com", ""},
+		{"cloudfront.net.example.com", ""},
+		{"www.example.com.", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if zoneId := canonicalHostedZoneId(test.dnsName); zoneId != test.zoneId {
+			t.Errorf("canonicalHostedZoneId(%q) = %q, want %q", test.dnsName, zoneId, test.zoneId)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package route53
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const (
+	defaultRoleSessionName = "rancher-external-dns"
+)
+
+// newSession creates an AWS session with credentials from one of these
+// locations in that priority order:
+// 1) Web identity token: AWS_WEB_IDENTITY_TOKEN_FILE, AWS_ROLE_ARN
+// 2) Environment variables: AWS_ACCESS_KEY, AWS_SECRET_KEY
+// 3) Shared credentials and config files, profile AWS_PROFILE
+// 4) ECS task role or EC2 IAM role
+// Unless a web identity token is used, the role AWS_ROLE_ARN is assumed
+// with these credentials, optionally with the external ID AWS_EXTERNAL_ID.
+// The region is read from ROUTE53_REGION or AWS_REGION.
+func newSession(config *aws.Config) (*session.Session, error) {
+	if region := os.Getenv("ROUTE53_REGION"); region != "" {
+		config = config.WithRegion(region)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *config,
+		Profile:           os.Getenv("AWS_PROFILE"),
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	roleARN := os.Getenv("AWS_ROLE_ARN")
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = defaultRoleSessionName
+	}
+
+	if tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); tokenFile != "" {
+		if roleARN == "" {
+			return nil, fmt.Errorf("AWS_ROLE_ARN is required with AWS_WEB_IDENTITY_TOKEN_FILE")
+		}
+		// the token itself authenticates the request
+		client := sts.New(sess, aws.NewConfig().WithCredentials(credentials.AnonymousCredentials))
+		creds := credentials.NewCredentials(&webIdentityProvider{
+			client:          client,
+			roleARN:         roleARN,
+			roleSessionName: sessionName,
+			tokenFile:       tokenFile,
+		})
+		return sess.Copy(aws.NewConfig().WithCredentials(creds)), nil
+	}
+
+	if roleARN != "" {
+		creds := stscreds.NewCredentials(sess, roleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = sessionName
+			if externalID := os.Getenv("AWS_EXTERNAL_ID"); externalID != "" {
+				p.ExternalID = aws.String(externalID)
+			}
+		})
+		return sess.Copy(aws.NewConfig().WithCredentials(creds)), nil
+	}
+
+	return sess, nil
+}
+
+// webIdentityProvider retrieves credentials by assuming a role with
+// the OIDC token read from a file, e.g. a projected service account
+// token of a Kubernetes pod. The file is read again on every refresh
+// as the token is rotated.
+type webIdentityProvider struct {
+	credentials.Expiry
+
+	client          *sts.STS
+	roleARN         string
+	roleSessionName string
+	tokenFile       string
+}
+
+func (p *webIdentityProvider) Retrieve() (credentials.Value, error) {
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("Failed to read web identity token: %v", err)
+	}
+
+	resp, err := p.client.AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.roleARN),
+		RoleSessionName:  aws.String(p.roleSessionName),
+		WebIdentityToken: aws.String(strings.TrimSpace(string(token))),
+	})
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("Failed to assume role with web identity: %v", err)
+	}
+
+	// refresh the credentials before they expire
+	p.SetExpiration(*resp.Credentials.Expiration, time.Minute)
+
+	return credentials.Value{
+		AccessKeyID:     *resp.Credentials.AccessKeyId,
+		SecretAccessKey: *resp.Credentials.SecretAccessKey,
+		SessionToken:    *resp.Credentials.SessionToken,
+		ProviderName:    "WebIdentityProvider",
+	}, nil
+}
@@ -0,0 +1,249 @@
+package route53
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	awsRoute53 "github.com/aws/aws-sdk-go/service/route53"
+	"github.com/rancher/external-dns/utils"
+)
+
+// Health checks created for the endpoints of a hosted zone have a caller
+// reference starting with this prefix followed by a hash of their owner
+// and the ID of the zone. Only the health checks of the owner set up for
+// the provider are reused, and they are deleted once the RRSets changed
+// by the provider do not refer to them anymore.
+const healthCheckReferencePrefix = "external-dns/"
+
+// endpoint is an address of a record in a hosted zone
+// that is checked by a health check
+type endpoint struct {
+	zoneId string
+	ip     string
+	check  utils.HealthCheck
+}
+
+// loadHealthChecks indexes the health checks of the account
+func (r *Route53Provider) loadHealthChecks() error {
+	endpointChecks := make(map[endpoint]string)
+	healthCheckConfigs := make(map[string]utils.HealthCheck)
+
+	r.limiter.Wait(1)
+	err := r.client.ListHealthChecksPages(&awsRoute53.ListHealthChecksInput{},
+		func(page *awsRoute53.ListHealthChecksOutput, lastPage bool) bool {
+			for _, hc := range page.HealthChecks {
+				config := hc.HealthCheckConfig
+				check := utils.HealthCheck{
+					Protocol: aws.StringValue(config.Type),
+					Port:     int(aws.Int64Value(config.Port)),
+					Path:     aws.StringValue(config.ResourcePath),
+				}
+				healthCheckConfigs[*hc.Id] = check
+
+				if owner, zoneId, ok := parseHealthCheckReference(*hc.CallerReference); ok && owner == r.owner {
+					ep := endpoint{zoneId: zoneId, ip: aws.StringValue(config.IPAddress), check: check}
+					endpointChecks[ep] = *hc.Id
+				}
+			}
+			if !lastPage {
+				r.limiter.Wait(1)
+			}
+			return !lastPage
+		})
+	if err != nil {
+		return fmt.Errorf("Could not list health checks: %v", err)
+	}
+
+	r.endpointChecks = endpointChecks
+	r.healthCheckConfigs = healthCheckConfigs
+	return nil
+}
+
+// SetOwner sets the owner of the health checks created by the provider
+func (r *Route53Provider) SetOwner(owner string) {
+	sum := sha1.Sum([]byte(owner))
+	r.owner = hex.EncodeToString(sum[:4])
+}
+
+// parseHealthCheckReference returns the owner and the ID of the hosted
+// zone the health check with the caller reference was created for
+func parseHealthCheckReference(callerReference string) (string, string, bool) {
+	if !strings.HasPrefix(callerReference, healthCheckReferencePrefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(callerReference, healthCheckReferencePrefix), "/", 3)
+	if len(parts) != 3 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// ensureHealthCheck returns the ID of the health check of the endpoint,
+// creating the health check if it does not exist yet
+func (r *Route53Provider) ensureHealthCheck(zoneId, ip string, check utils.HealthCheck) (string, error) {
+	ep := endpoint{zoneId: zoneId, ip: ip, check: check}
+	if id, ok := r.endpointChecks[ep]; ok {
+		return id, nil
+	}
+
+	config := &awsRoute53.HealthCheckConfig{
+		IPAddress: aws.String(ip),
+		Port:      aws.Int64(int64(check.Port)),
+		Type:      aws.String(check.Protocol),
+	}
+	if check.Path != "" {
+		config.ResourcePath = aws.String(check.Path)
+	}
+
+	r.limiter.Wait(1)
+	callerReference := healthCheckReferencePrefix + r.owner + "/" + zoneId + "/" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	resp, err := r.client.CreateHealthCheck(&awsRoute53.CreateHealthCheckInput{
+		CallerReference:   aws.String(callerReference),
+		HealthCheckConfig: config,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Could not create health check of %s: %v", ip, err)
+	}
+
+	id := *resp.HealthCheck.Id
+	logrus.Infof("Created health check %s of %s:%d", id, ip, check.Port)
+	r.endpointChecks[ep] = id
+	r.healthCheckConfigs[id] = check
+	return id, nil
+}
+
+// releaseHealthChecks counts the references to health checks after the
+// RRSets of a record were replaced and deletes the health checks of the
+// owner that none of the RRSets refer to anymore
+func (r *Route53Provider) releaseHealthChecks(replaced, rrSets []*awsRoute53.ResourceRecordSet) {
+	for _, rrSet := range rrSets {
+		if rrSet.HealthCheckId != nil {
+			r.healthCheckRefs[*rrSet.HealthCheckId]++
+		}
+	}
+
+	for _, rrSet := range replaced {
+		if rrSet.HealthCheckId == nil {
+			continue
+		}
+		id := *rrSet.HealthCheckId
+		if r.healthCheckRefs[id]--; r.healthCheckRefs[id] > 0 {
+			continue
+		}
+		delete(r.healthCheckRefs, id)
+
+		for ep, epId := range r.endpointChecks {
+			if epId != id {
+				continue
+			}
+			r.limiter.Wait(1)
+			_, err := r.client.DeleteHealthCheck(&awsRoute53.DeleteHealthCheckInput{
+				HealthCheckId: aws.String(id),
+			})
+			if err != nil {
+				logrus.Warnf("Could not delete unused health check %s: %v", id, err)
+				break
+			}
+			logrus.Infof("Deleted unused health check %s of %s", id, ep.ip)
+			delete(r.endpointChecks, ep)
+			delete(r.healthCheckConfigs, id)
+			break
+		}
+	}
+}
+
+// changeMultiValueRecord publishes each value of a record with a health
+// check as multivalue answer RRSet bound to a health check of the value,
+// and other records as a simple RRSet. RRSets of the same name and type
+// that are not part of the record anymore are deleted in the same batch
+// so that a record can switch between both forms.
+func (r *Route53Provider) changeMultiValueRecord(zoneId string, record utils.DnsRecord, action string) error {
+	existing, err := r.listRRSets(zoneId, record.Fqdn, record.Type)
+	if err != nil {
+		return err
+	}
+
+	var rrSets []*awsRoute53.ResourceRecordSet
+	if action != "DELETE" && record.HealthCheck == nil {
+		rrSet, err := newRRSet(record)
+		if err != nil {
+			return err
+		}
+		rrSets = append(rrSets, rrSet)
+	} else if action != "DELETE" {
+		for _, value := range record.Records {
+			id, err := r.ensureHealthCheck(zoneId, value, *record.HealthCheck)
+			if err != nil {
+				return err
+			}
+			rrSet, err := newRRSet(utils.DnsRecord{
+				Fqdn:    record.Fqdn,
+				Type:    record.Type,
+				TTL:     record.TTL,
+				Records: []string{value},
+			})
+			if err != nil {
+				return err
+			}
+			rrSet.SetIdentifier = aws.String(value)
+			rrSet.MultiValueAnswer = aws.Bool(true)
+			rrSet.HealthCheckId = aws.String(id)
+			rrSets = append(rrSets, rrSet)
+		}
+	}
+
+	identifiers := make(map[string]struct{}, len(rrSets))
+	for _, rrSet := range rrSets {
+		identifiers[aws.StringValue(rrSet.SetIdentifier)] = struct{}{}
+	}
+
+	var changes []*awsRoute53.Change
+	var replaced []*awsRoute53.ResourceRecordSet
+	for _, rrSet := range existing {
+		// RRSets of routing policies are not ours to replace
+		if rrSet.SetIdentifier != nil && !aws.BoolValue(rrSet.MultiValueAnswer) {
+			continue
+		}
+		replaced = append(replaced, rrSet)
+		if _, ok := identifiers[aws.StringValue(rrSet.SetIdentifier)]; !ok {
+			changes = append(changes, &awsRoute53.Change{
+				Action:            aws.String("DELETE"),
+				ResourceRecordSet: rrSet,
+			})
+		}
+	}
+	for _, rrSet := range rrSets {
+		changes = append(changes, &awsRoute53.Change{
+			Action:            aws.String("UPSERT"),
+			ResourceRecordSet: rrSet,
+		})
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := r.changeRRSets(zoneId, changes); err != nil {
+		return err
+	}
+
+	key := multiValueKey(zoneId, record.Fqdn, record.Type)
+	if action != "DELETE" && record.HealthCheck != nil {
+		r.multiValueRRSets[key] = struct{}{}
+	} else {
+		delete(r.multiValueRRSets, key)
+	}
+	r.releaseHealthChecks(replaced, rrSets)
+	return nil
+}
+
+// multiValueKey identifies the multivalue answer
+// RRSets of a name and type in a hosted zone
+func multiValueKey(zoneId, fqdn, recordType string) string {
+	return zoneId + " " + strings.ToLower(utils.Fqdn(fqdn)) + " " + recordType
+}
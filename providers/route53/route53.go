@@ -5,13 +5,10 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go/aws/session"
 	awsRoute53 "github.com/aws/aws-sdk-go/service/route53"
 	"github.com/juju/ratelimit"
 	"github.com/rancher/external-dns/providers"
@@ -23,20 +20,43 @@ var (
 )
 
 type Route53Provider struct {
-	client       *awsRoute53.Route53
-	hostedZoneId string
-	limiter      *ratelimit.Bucket
+	client *awsRoute53.Route53
+	// hosted zones indexed by zone name, a public and a
+	// private one for split-horizon domains
+	hostedZones map[string][]hostedZone
+	zoneNames   []string
+	limiter     *ratelimit.Bucket
+	// healthChecks enables health checks of record endpoints
+	healthChecks bool
+	// owner is the hash of the owner of the health checks
+	owner string
+	// IDs of the health checks of the owner indexed by endpoint
+	endpointChecks map[endpoint]string
+	// configurations of all health checks indexed by ID
+	healthCheckConfigs map[string]utils.HealthCheck
+	// numbers of RRSets referring to each health check
+	healthCheckRefs map[string]int
+	// names and types that have multivalue answer RRSets
+	multiValueRRSets map[string]struct{}
+	// waitForSync enables waiting for changes to propagate
+	waitForSync bool
+	syncTimeout time.Duration
+	// changes submitted since changes were last waited for
+	pendingChanges []pendingChange
 }
 
 func init() {
-	providers.RegisterProvider("route53", &Route53Provider{})
+	providers.RegisterProvider("route53", func() providers.Provider {
+		return &Route53Provider{}
+	})
 }
 
-// Init creates a Route53 client with credentials from one of these
-// two locations in that priority order:
-// 1) Environment variables: AWS_ACCESS_KEY, AWS_SECRET_KEY
-// 2) EC2 IAM role
-func (r *Route53Provider) Init(rootDomainName string) error {
+// Init creates a Route53 client with the credentials described
+// at newSession. ROUTE53_ENDPOINT overrides the API endpoint.
+// ROUTE53_HEALTH_CHECKS enables health checks of record endpoints.
+// ROUTE53_WAIT_FOR_SYNC enables waiting up to ROUTE53_SYNC_TIMEOUT
+// seconds for the changes of an update to propagate.
+func (r *Route53Provider) Init(rootDomainNames []string) error {
 	// Comply with the API's 5 req/s rate limit. If there are other
 	// clients using the same account the AWS SDK will throttle the
 	// requests automatically if the global rate limit is exhausted.
@@ -51,80 +71,51 @@ func (r *Route53Provider) Init(rootDomainName string) error {
 		}
 	}
 
-	creds := credentials.NewChainCredentials(
-		[]credentials.Provider{
-			&credentials.EnvProvider{},
-			&ec2rolecreds.EC2RoleProvider{
-				Client: ec2metadata.New(session.Must(session.NewSession())),
-			},
-		})
-
-	config := aws.NewConfig().WithMaxRetries(route53MaxRetries).
-		WithCredentials(creds)
-
-	sess, err := session.NewSession(config)
-	if err != nil {
-		return fmt.Errorf("Failed to create Route53 session: %v", err)
+	if envVal := os.Getenv("ROUTE53_HEALTH_CHECKS"); envVal != "" {
+		enabled, err := strconv.ParseBool(envVal)
+		if err != nil {
+			return fmt.Errorf("Invalid value for ROUTE53_HEALTH_CHECKS: '%s'", envVal)
+		}
+		r.healthChecks = enabled
 	}
-
-	r.client = awsRoute53.New(sess)
-	if err := r.setHostedZone(rootDomainName); err != nil {
-		return fmt.Errorf("Failed to configure hosted zone: %v", err)
+	r.endpointChecks = make(map[endpoint]string)
+	r.healthCheckConfigs = make(map[string]utils.HealthCheck)
+	r.healthCheckRefs = make(map[string]int)
+	r.multiValueRRSets = make(map[string]struct{})
+
+	if envVal := os.Getenv("ROUTE53_WAIT_FOR_SYNC"); envVal != "" {
+		enabled, err := strconv.ParseBool(envVal)
+		if err != nil {
+			return fmt.Errorf("Invalid value for ROUTE53_WAIT_FOR_SYNC: '%s'", envVal)
+		}
+		r.waitForSync = enabled
 	}
-
-	logrus.Infof("Configured %s with hosted zone %s",
-		r.GetName(), rootDomainName)
-
-	return nil
-}
-
-func (r *Route53Provider) setHostedZone(rootDomainName string) error {
-	if envVal := os.Getenv("ROUTE53_ZONE_ID"); envVal != "" {
-		r.hostedZoneId = strings.TrimSpace(envVal)
-		if err := r.validateHostedZoneId(rootDomainName); err != nil {
-			return err
+	r.syncTimeout = defaultSyncTimeout
+	if envVal := os.Getenv("ROUTE53_SYNC_TIMEOUT"); envVal != "" {
+		i, err := strconv.Atoi(envVal)
+		if err != nil || i <= 0 {
+			return fmt.Errorf("Invalid value for ROUTE53_SYNC_TIMEOUT: '%s'", envVal)
 		}
-		return nil
+		r.syncTimeout = time.Duration(i) * time.Second
 	}
 
-	r.limiter.Wait(1)
-	params := &awsRoute53.ListHostedZonesByNameInput{
-		DNSName:  aws.String(utils.UnFqdn(rootDomainName)),
-		MaxItems: aws.String("1"),
-	}
-	resp, err := r.client.ListHostedZonesByName(params)
+	sess, err := newSession(aws.NewConfig().WithMaxRetries(route53MaxRetries))
 	if err != nil {
-		return fmt.Errorf("Could not list hosted zones: %v", err)
+		return fmt.Errorf("Failed to create Route53 session: %v", err)
 	}
 
-	if len(resp.HostedZones) == 0 || *resp.HostedZones[0].Name != rootDomainName {
-		return fmt.Errorf("Hosted zone for '%s' not found", rootDomainName)
+	config := aws.NewConfig()
+	if endpoint := os.Getenv("ROUTE53_ENDPOINT"); endpoint != "" {
+		config = config.WithEndpoint(endpoint)
 	}
 
-	zoneId := *resp.HostedZones[0].Id
-	if strings.HasPrefix(zoneId, "/hostedzone/") {
-		zoneId = strings.TrimPrefix(zoneId, "/hostedzone/")
+	r.client = awsRoute53.New(sess, config)
+	if err := r.setHostedZones(rootDomainNames); err != nil {
+		return fmt.Errorf("Failed to configure hosted zones: %v", err)
 	}
 
-	r.hostedZoneId = zoneId
-	return nil
-}
-
-func (r *Route53Provider) validateHostedZoneId(rootDomainName string) error {
-	r.limiter.Wait(1)
-	params := &awsRoute53.GetHostedZoneInput{
-		Id: aws.String(r.hostedZoneId),
-	}
-	resp, err := r.client.GetHostedZone(params)
-	if err != nil {
-		return fmt.Errorf("Could not look up hosted zone ID %s: %v",
-			r.hostedZoneId, err)
-	}
-
-	if *resp.HostedZone.Name != rootDomainName {
-		return fmt.Errorf("Hosted zone ID '%s' does not match name '%s'",
-			r.hostedZoneId, rootDomainName)
-	}
+	logrus.Infof("Configured %s with hosted zones %v",
+		r.GetName(), rootDomainNames)
 
 	return nil
 }
@@ -133,6 +124,23 @@ func (*Route53Provider) GetName() string {
 	return "Route 53"
 }
 
+// Route 53 stores names in lower case
+func (r *Route53Provider) RecordRules(fqdn string) providers.RecordRules {
+	rules := providers.RecordRules{
+		CaseInsensitive: true,
+		Alias:           true,
+		RoutingPolicy:   true,
+		HealthChecks:    r.healthChecks,
+	}
+	zones := r.hostedZones[utils.ZoneForFqdn(fqdn, r.zoneNames)]
+	if len(zones) > 1 {
+		rules.SplitHorizon = true
+	} else if len(zones) == 1 && zones[0].private {
+		rules.ZoneVisibility = utils.VisibilityPrivate
+	}
+	return rules
+}
+
 func (r *Route53Provider) HealthCheck() error {
 	var params *awsRoute53.GetHostedZoneCountInput
 	_, err := r.client.GetHostedZoneCount(params)
@@ -152,60 +160,201 @@ func (r *Route53Provider) RemoveRecord(record utils.DnsRecord) error {
 }
 
 func (r *Route53Provider) changeRecord(record utils.DnsRecord, action string) error {
-	r.limiter.Wait(1)
-	records := make([]*awsRoute53.ResourceRecord, len(record.Records))
-	for idx, value := range record.Records {
-		if record.Type == "TXT" {
-			value = `"` + value + `"`
+	zones, err := r.zonesForRecord(record)
+	if err != nil {
+		return err
+	}
+
+	rrSet, err := newRRSet(record)
+	if err != nil {
+		return err
+	}
+
+	for _, zone := range zones {
+		// records with a health check are published as multivalue
+		// answer RRSets, which are replaced as a whole, as are the
+		// records that are switched away from a health check
+		if record.Alias == nil && record.RoutingPolicy == nil {
+			_, multiValue := r.multiValueRRSets[multiValueKey(zone.id, record.Fqdn, record.Type)]
+			if record.HealthCheck != nil || multiValue {
+				if err := r.changeMultiValueRecord(zone.id, record, action); err != nil {
+					return err
+				}
+				continue
+			}
 		}
-		records[idx] = &awsRoute53.ResourceRecord{
-			Value: aws.String(value),
+
+		changes := []*awsRoute53.Change{
+			{
+				Action:            aws.String(action),
+				ResourceRecordSet: rrSet,
+			},
+		}
+		if err := r.changeRRSets(zone.id, changes); err != nil {
+			return err
 		}
 	}
 
+	return nil
+}
+
+func (r *Route53Provider) changeRRSets(zoneId string, changes []*awsRoute53.Change) error {
+	r.limiter.Wait(1)
 	params := &awsRoute53.ChangeResourceRecordSetsInput{
-		HostedZoneId: aws.String(r.hostedZoneId),
+		HostedZoneId: aws.String(zoneId),
 		ChangeBatch: &awsRoute53.ChangeBatch{
 			Comment: aws.String("Managed by Rancher"),
-			Changes: []*awsRoute53.Change{
-				{
-					Action: aws.String(action),
-					ResourceRecordSet: &awsRoute53.ResourceRecordSet{
-						Name:            aws.String(record.Fqdn),
-						Type:            aws.String(record.Type),
-						TTL:             aws.Int64(int64(record.TTL)),
-						ResourceRecords: records,
-					},
-				},
-			},
+			Changes: changes,
 		},
 	}
 
-	_, err := r.client.ChangeResourceRecordSets(params)
-	return err
+	resp, err := r.client.ChangeResourceRecordSets(params)
+	if err != nil {
+		return err
+	}
+
+	if r.waitForSync {
+		r.pendingChanges = append(r.pendingChanges, pendingChange{
+			id:        *resp.ChangeInfo.Id,
+			submitted: time.Now(),
+		})
+	}
+	return nil
+}
+
+// newRRSet returns the resource record set of the record
+func newRRSet(record utils.DnsRecord) (*awsRoute53.ResourceRecordSet, error) {
+	rrSet := &awsRoute53.ResourceRecordSet{
+		Name: aws.String(record.Fqdn),
+		Type: aws.String(record.Type),
+	}
+
+	if policy := record.RoutingPolicy; policy != nil {
+		rrSet.SetIdentifier = aws.String(record.SetIdentifier)
+		switch {
+		case policy.Weight != nil:
+			rrSet.Weight = aws.Int64(*policy.Weight)
+		case policy.Region != "":
+			rrSet.Region = aws.String(policy.Region)
+		case policy.Failover != "":
+			rrSet.Failover = aws.String(policy.Failover)
+		}
+	}
+
+	if record.Alias != nil {
+		aliasZoneId := record.Alias.HostedZoneId
+		if aliasZoneId == "" {
+			if aliasZoneId = canonicalHostedZoneId(record.Alias.DNSName); aliasZoneId == "" {
+				return nil, fmt.Errorf("Cannot detect the hosted zone ID of alias target '%s'", record.Alias.DNSName)
+			}
+		}
+		rrSet.AliasTarget = &awsRoute53.AliasTarget{
+			DNSName:              aws.String(record.Alias.DNSName),
+			HostedZoneId:         aws.String(aliasZoneId),
+			EvaluateTargetHealth: aws.Bool(record.Alias.EvaluateTargetHealth),
+		}
+	} else {
+		records := make([]*awsRoute53.ResourceRecord, len(record.Records))
+		for idx, value := range record.Records {
+			if record.Type == "TXT" {
+				value = `"` + value + `"`
+			}
+			records[idx] = &awsRoute53.ResourceRecord{
+				Value: aws.String(value),
+			}
+		}
+		rrSet.TTL = aws.Int64(int64(record.TTL))
+		rrSet.ResourceRecords = records
+	}
+
+	return rrSet, nil
 }
 
 func (r *Route53Provider) GetRecords() ([]utils.DnsRecord, error) {
-	r.limiter.Wait(1)
 	dnsRecords := []utils.DnsRecord{}
+	if r.healthChecks {
+		if err := r.loadHealthChecks(); err != nil {
+			return dnsRecords, err
+		}
+	}
+
+	healthCheckRefs := make(map[string]int)
+	multiValueRRSets := make(map[string]struct{})
+	for _, zoneName := range r.zoneNames {
+		zones := r.hostedZones[zoneName]
+		zoneRecords := make([][]utils.DnsRecord, len(zones))
+		for idx, zone := range zones {
+			rrSets, err := r.listRRSets(zone.id, "", "")
+			if err != nil {
+				return dnsRecords, err
+			}
+			zoneRecords[idx] = r.toDnsRecords(rrSets)
+			for _, rrSet := range rrSets {
+				if rrSet.HealthCheckId != nil {
+					healthCheckRefs[*rrSet.HealthCheckId]++
+				}
+				if aws.BoolValue(rrSet.MultiValueAnswer) {
+					multiValueRRSets[multiValueKey(zone.id, *rrSet.Name, *rrSet.Type)] = struct{}{}
+				}
+			}
+		}
+
+		if len(zones) == 2 {
+			if zones[0].private {
+				zoneRecords[0], zoneRecords[1] = zoneRecords[1], zoneRecords[0]
+			}
+			dnsRecords = append(dnsRecords, mergeSplitHorizon(zoneRecords[0], zoneRecords[1])...)
+		} else {
+			dnsRecords = append(dnsRecords, zoneRecords[0]...)
+		}
+	}
+
+	r.healthCheckRefs = healthCheckRefs
+	r.multiValueRRSets = multiValueRRSets
+	return dnsRecords, nil
+}
+
+// listRRSets returns the resource record sets of the hosted zone,
+// only those of the given name and type if these are set
+func (r *Route53Provider) listRRSets(zoneId, name, recordType string) ([]*awsRoute53.ResourceRecordSet, error) {
 	rrSets := []*awsRoute53.ResourceRecordSet{}
+	r.limiter.Wait(1)
 	params := &awsRoute53.ListResourceRecordSetsInput{
-		HostedZoneId: aws.String(r.hostedZoneId),
+		HostedZoneId: aws.String(zoneId),
 		MaxItems:     aws.String("100"),
 	}
+	if name != "" {
+		params.StartRecordName = aws.String(name)
+		params.StartRecordType = aws.String(recordType)
+	}
 
 	err := r.client.ListResourceRecordSetsPages(params,
 		func(page *awsRoute53.ListResourceRecordSetsOutput, lastPage bool) bool {
-			rrSets = append(rrSets, page.ResourceRecordSets...)
+			for _, rrSet := range page.ResourceRecordSets {
+				if name != "" && (!strings.EqualFold(*rrSet.Name, name) || *rrSet.Type != recordType) {
+					// the sets are listed in the order of their names
+					return false
+				}
+				rrSets = append(rrSets, rrSet)
+			}
 			if !lastPage {
 				r.limiter.Wait(1)
 			}
 			return !lastPage
 		})
 	if err != nil {
-		return dnsRecords, fmt.Errorf("Route 53 API call has failed: %v", err)
+		return rrSets, fmt.Errorf("Route 53 API call has failed: %v", err)
 	}
 
+	return rrSets, nil
+}
+
+// toDnsRecords returns the records of the resource record sets.
+// Multivalue answer RRSets of the same name and type are returned
+// as one record.
+func (r *Route53Provider) toDnsRecords(rrSets []*awsRoute53.ResourceRecordSet) []utils.DnsRecord {
+	dnsRecords := []utils.DnsRecord{}
+	multiValueIdx := make(map[string]int)
 	for _, rrSet := range rrSets {
 		// skip proprietary Route 53 resource record sets
 		if IsProprietary(rrSet) {
@@ -229,14 +378,56 @@ func (r *Route53Provider) GetRecords() ([]utils.DnsRecord, error) {
 			Fqdn:    *rrSet.Name,
 			Records: records,
 			Type:    *rrSet.Type,
-			TTL:     int(*rrSet.TTL),
+		}
+		if rrSet.TTL != nil {
+			dnsRecord.TTL = int(*rrSet.TTL)
+		}
+
+		if aws.BoolValue(rrSet.MultiValueAnswer) {
+			var check *utils.HealthCheck
+			if rrSet.HealthCheckId != nil {
+				// an unknown health check differs from any
+				config := r.healthCheckConfigs[*rrSet.HealthCheckId]
+				check = &config
+			}
+
+			key := utils.RecordKey(dnsRecord)
+			if idx, ok := multiValueIdx[key]; ok {
+				dnsRecords[idx].Records = append(dnsRecords[idx].Records, records...)
+				// values with different health checks give the
+				// record a health check that differs from any
+				if !providers.SameHealthCheck(dnsRecords[idx].HealthCheck, check) {
+					dnsRecords[idx].HealthCheck = &utils.HealthCheck{}
+				}
+				continue
+			}
+			dnsRecord.HealthCheck = check
+			multiValueIdx[key] = len(dnsRecords)
+			dnsRecords = append(dnsRecords, dnsRecord)
+			continue
+		}
+
+		if rrSet.SetIdentifier != nil {
+			dnsRecord.SetIdentifier = *rrSet.SetIdentifier
+			dnsRecord.RoutingPolicy = &utils.RoutingPolicy{
+				Weight:   rrSet.Weight,
+				Region:   aws.StringValue(rrSet.Region),
+				Failover: aws.StringValue(rrSet.Failover),
+			}
+		}
+		if target := rrSet.AliasTarget; target != nil {
+			dnsRecord.Alias = &utils.Alias{
+				DNSName:              aws.StringValue(target.DNSName),
+				HostedZoneId:         aws.StringValue(target.HostedZoneId),
+				EvaluateTargetHealth: aws.BoolValue(target.EvaluateTargetHealth),
+			}
 		}
 		dnsRecords = append(dnsRecords, dnsRecord)
 	}
 
-	return dnsRecords, nil
+	return dnsRecords
 }
 
 func IsProprietary(rr *awsRoute53.ResourceRecordSet) bool {
-	return (rr.AliasTarget != nil || rr.TrafficPolicyInstanceId != nil)
+	return rr.TrafficPolicyInstanceId != nil
 }
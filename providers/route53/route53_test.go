@@ -0,0 +1,80 @@
+package route53
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awsRoute53 "github.com/aws/aws-sdk-go/service/route53"
+	"github.com/rancher/external-dns/utils"
+)
+
+func multiValueRRSet(value, healthCheckId string) *awsRoute53.ResourceRecordSet {
+	rrSet := &awsRoute53.ResourceRecordSet{
+		Name:             aws.String("www.example.com."),
+		Type:             aws.String("A"),
+		TTL:              aws.Int64(300),
+		SetIdentifier:    aws.String(value),
+		MultiValueAnswer: aws.Bool(true),
+		ResourceRecords:  []*awsRoute53.ResourceRecord{{Value: aws.String(value)}},
+	}
+	if healthCheckId != "" {
+		rrSet.HealthCheckId = aws.String(healthCheckId)
+	}
+	return rrSet
+}
+
+func TestToDnsRecordsMultiValueHealthChecks(t *testing.T) {
+	httpCheck := utils.HealthCheck{Protocol: "HTTP", Port: 80, Path: "/"}
+	r := &Route53Provider{
+		healthCheckConfigs: map[string]utils.HealthCheck{
+			"http":  httpCheck,
+			"other": {Protocol: "HTTP", Port: 8080, Path: "/"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		rrSets []*awsRoute53.ResourceRecordSet
+		check  *utils.HealthCheck
+	}{
+		{
+			name:   "same health checks",
+			rrSets: []*awsRoute53.ResourceRecordSet{multiValueRRSet("10.0.0.1", "http"), multiValueRRSet("10.0.0.2", "http")},
+			check:  &httpCheck,
+		},
+		{
+			name:   "drift of a later value",
+			rrSets: []*awsRoute53.ResourceRecordSet{multiValueRRSet("10.0.0.1", "http"), multiValueRRSet("10.0.0.2", "other")},
+			check:  &utils.HealthCheck{},
+		},
+		{
+			name:   "later value without health check",
+			rrSets: []*awsRoute53.ResourceRecordSet{multiValueRRSet("10.0.0.1", "http"), multiValueRRSet("10.0.0.2", "")},
+			check:  &utils.HealthCheck{},
+		},
+		{
+			name:   "unknown health check",
+			rrSets: []*awsRoute53.ResourceRecordSet{multiValueRRSet("10.0.0.1", "deleted")},
+			check:  &utils.HealthCheck{},
+		},
+		{
+			name:   "no health checks",
+			rrSets: []*awsRoute53.ResourceRecordSet{multiValueRRSet("10.0.0.1", ""), multiValueRRSet("10.0.0.2", "")},
+		},
+	}
+
+	for _, test := range tests {
+		records := r.toDnsRecords(test.rrSets)
+		if len(records) != 1 {
+			t.Errorf("%s: got %d records, want 1", test.name, len(records))
+			continue
+		}
+		if len(records[0].Records) != len(test.rrSets) {
+			t.Errorf("%s: got values %v", test.name, records[0].Records)
+		}
+		check := records[0].HealthCheck
+		if (check == nil) != (test.check == nil) || (check != nil && *check != *test.check) {
+			t.Errorf("%s: got health check %v, want %v", test.name, check, test.check)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package route53
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	awsRoute53 "github.com/aws/aws-sdk-go/service/route53"
+	"github.com/rancher/external-dns/metrics"
+)
+
+const (
+	defaultSyncTimeout = 5 * time.Minute
+	syncPollInterval   = 5 * time.Second
+)
+
+var propagationSeconds = metrics.NewHistogram("external_dns_route53_change_propagation_seconds",
+	"Time it took changes to Route 53 to propagate to all name servers",
+	[]float64{5, 10, 20, 30, 45, 60, 90, 120, 180, 300})
+
+// pendingChange is a change that has not been seen INSYNC yet
+type pendingChange struct {
+	id        string
+	submitted time.Time
+}
+
+// WaitForChanges polls the status of the changes submitted since it was
+// last called until all of them are INSYNC or the sync timeout expires.
+// Changes that did not propagate in time are waited for again by the
+// next call.
+func (r *Route53Provider) WaitForChanges() error {
+	pending := r.pendingChanges
+	r.pendingChanges = nil
+	if len(pending) == 0 {
+		return nil
+	}
+
+	logrus.Debugf("Waiting for %d Route 53 changes to propagate", len(pending))
+	deadline := time.Now().Add(r.syncTimeout)
+	for {
+		var remaining []pendingChange
+		for idx, change := range pending {
+			r.limiter.Wait(1)
+			resp, err := r.client.GetChange(&awsRoute53.GetChangeInput{
+				Id: aws.String(change.id),
+			})
+			if err != nil {
+				// changes seen INSYNC are not waited for again
+				r.pendingChanges = append(r.pendingChanges, remaining...)
+				r.pendingChanges = append(r.pendingChanges, pending[idx:]...)
+				return fmt.Errorf("Could not get the status of change %s: %v", change.id, err)
+			}
+
+			if aws.StringValue(resp.ChangeInfo.Status) == awsRoute53.ChangeStatusInsync {
+				latency := time.Since(change.submitted)
+				propagationSeconds.Observe(latency.Seconds())
+				logrus.Debugf("Route 53 change %s propagated after %v", change.id, latency)
+				continue
+			}
+			remaining = append(remaining, change)
+		}
+
+		if len(remaining) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			r.pendingChanges = append(r.pendingChanges, remaining...)
+			return fmt.Errorf("%d changes did not propagate within %v", len(remaining), r.syncTimeout)
+		}
+
+		pending = remaining
+		time.Sleep(syncPollInterval)
+	}
+}
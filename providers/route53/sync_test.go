@@ -0,0 +1,73 @@
+package route53
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awsRoute53 "github.com/aws/aws-sdk-go/service/route53"
+	"github.com/juju/ratelimit"
+)
+
+// newTestClient returns a provider using the Route 53 API served by the handler
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Route53Provider, func()) {
+	server := httptest.NewServer(handler)
+	sess, err := session.NewSession(aws.NewConfig().
+		WithRegion("us-east-1").
+		WithEndpoint(server.URL).
+		WithMaxRetries(0).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return &Route53Provider{
+		client:      awsRoute53.New(sess),
+		limiter:     ratelimit.NewBucketWithRate(1000, 1000),
+		syncTimeout: time.Minute,
+	}, server.Close
+}
+
+func TestWaitForChangesRequeuesUnseenChanges(t *testing.T) {
+	r, closeServer := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		id := path.Base(req.URL.Path)
+		if id == "C2" {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>InternalError</Code><Message>failed</Message></Error></ErrorResponse>`)
+			return
+		}
+		status := "INSYNC"
+		if id == "C1" {
+			status = "PENDING"
+		}
+		fmt.Fprintf(w, `<GetChangeResponse><ChangeInfo><Id>/change/%s</Id><Status>%s</Status>`+
+			`<SubmittedAt>2017-01-01T00:00:00Z</SubmittedAt></ChangeInfo></GetChangeResponse>`, id, status)
+	})
+	defer closeServer()
+
+	submitted := time.Now()
+	r.pendingChanges = []pendingChange{
+		{id: "C0", submitted: submitted},
+		{id: "C1", submitted: submitted},
+		{id: "C2", submitted: submitted},
+		{id: "C3", submitted: submitted},
+	}
+	if err := r.WaitForChanges(); err == nil {
+		t.Fatalf("failed status request was not reported")
+	}
+
+	// C0 propagated, C1 is still pending and C2 and C3 were not seen
+	var ids []string
+	for _, change := range r.pendingChanges {
+		ids = append(ids, change.id)
+	}
+	if fmt.Sprint(ids) != "[C1 C2 C3]" {
+		t.Errorf("got pending changes %v, want [C1 C2 C3]", ids)
+	}
+}
@@ -0,0 +1,271 @@
+package route53
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awsRoute53 "github.com/aws/aws-sdk-go/service/route53"
+	"github.com/rancher/external-dns/utils"
+)
+
+// hostedZone is a hosted zone holding one of the root domains
+type hostedZone struct {
+	id      string
+	private bool
+}
+
+func (z hostedZone) visibility() string {
+	if z.private {
+		return utils.VisibilityPrivate
+	}
+	return utils.VisibilityPublic
+}
+
+// setHostedZones looks up the hosted zones of the root domains.
+// IDs given in ROUTE53_ZONE_ID (comma separated) take precedence over a
+// lookup by name. The lookup only considers zones of the type given in
+// ROUTE53_ZONE_TYPE ('public' or 'private') and private zones associated
+// with the VPC ROUTE53_VPC_ID if these are set. A domain may only match
+// a single zone unless ROUTE53_SPLIT_HORIZON is true, in which case both
+// its public and its private zone are managed.
+func (r *Route53Provider) setHostedZones(rootDomainNames []string) error {
+	r.hostedZones = make(map[string][]hostedZone, len(rootDomainNames))
+	r.zoneNames = rootDomainNames
+
+	zoneType := strings.ToLower(os.Getenv("ROUTE53_ZONE_TYPE"))
+	switch zoneType {
+	case "", utils.VisibilityPublic, utils.VisibilityPrivate:
+	default:
+		return fmt.Errorf("Invalid value for ROUTE53_ZONE_TYPE: '%s'", zoneType)
+	}
+
+	vpcId := os.Getenv("ROUTE53_VPC_ID")
+
+	var splitHorizon bool
+	if envVal := os.Getenv("ROUTE53_SPLIT_HORIZON"); envVal != "" {
+		var err error
+		if splitHorizon, err = strconv.ParseBool(envVal); err != nil {
+			return fmt.Errorf("Invalid value for ROUTE53_SPLIT_HORIZON: '%s'", envVal)
+		}
+	}
+
+	if envVal := os.Getenv("ROUTE53_ZONE_ID"); envVal != "" {
+		for _, zoneId := range strings.Split(envVal, ",") {
+			zoneId = strings.TrimSpace(zoneId)
+			if zoneId == "" {
+				continue
+			}
+			resp, err := r.getHostedZone(zoneId)
+			if err != nil {
+				return err
+			}
+			name := *resp.HostedZone.Name
+			if utils.ZoneForFqdn(name, rootDomainNames) != name {
+				return fmt.Errorf("Hosted zone ID '%s' does not match any of %v",
+					zoneId, rootDomainNames)
+			}
+			r.hostedZones[name] = append(r.hostedZones[name], newHostedZone(resp.HostedZone))
+		}
+		// zones given by ID are managed as given
+		for name, zones := range r.hostedZones {
+			if _, err := selectHostedZones(name, zones, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, rootDomainName := range rootDomainNames {
+		if _, ok := r.hostedZones[rootDomainName]; ok {
+			continue
+		}
+		zones, err := r.findHostedZones(rootDomainName, zoneType, vpcId)
+		if err != nil {
+			return err
+		}
+		if r.hostedZones[rootDomainName], err = selectHostedZones(rootDomainName, zones, splitHorizon); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// selectHostedZones returns the zones to manage for the root domain,
+// which is a single zone or a public and a private one if split horizon
+// is enabled
+func selectHostedZones(rootDomainName string, zones []hostedZone, splitHorizon bool) ([]hostedZone, error) {
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("Hosted zone for '%s' not found", rootDomainName)
+	}
+	if !splitHorizon {
+		if len(zones) > 1 {
+			return nil, fmt.Errorf("Found %d hosted zones for '%s', set ROUTE53_ZONE_ID, "+
+				"ROUTE53_ZONE_TYPE or ROUTE53_VPC_ID to select one", len(zones), rootDomainName)
+		}
+		return zones, nil
+	}
+
+	visibilities := make(map[string]struct{}, len(zones))
+	for _, zone := range zones {
+		if _, ok := visibilities[zone.visibility()]; ok {
+			return nil, fmt.Errorf("Found more than one %s hosted zone for '%s', set ROUTE53_ZONE_ID "+
+				"or ROUTE53_VPC_ID to select one", zone.visibility(), rootDomainName)
+		}
+		visibilities[zone.visibility()] = struct{}{}
+	}
+	return zones, nil
+}
+
+// findHostedZones returns the zones named after the root domain
+// that match the zone type and VPC, if given
+func (r *Route53Provider) findHostedZones(rootDomainName, zoneType, vpcId string) ([]hostedZone, error) {
+	var zones []hostedZone
+	params := &awsRoute53.ListHostedZonesByNameInput{
+		DNSName:  aws.String(utils.UnFqdn(rootDomainName)),
+		MaxItems: aws.String("100"),
+	}
+
+	// zones are listed in the order of their names
+	// starting with the first zone of the given name
+	done := false
+	for !done {
+		r.limiter.Wait(1)
+		resp, err := r.client.ListHostedZonesByName(params)
+		if err != nil {
+			return nil, fmt.Errorf("Could not list hosted zones: %v", err)
+		}
+
+		for _, z := range resp.HostedZones {
+			if *z.Name != rootDomainName {
+				done = true
+				break
+			}
+			zone := newHostedZone(z)
+			if zoneType != "" && zone.visibility() != zoneType {
+				continue
+			}
+			if zone.private && vpcId != "" {
+				associated, err := r.associatedWithVPC(zone.id, vpcId)
+				if err != nil {
+					return nil, err
+				}
+				if !associated {
+					continue
+				}
+			}
+			zones = append(zones, zone)
+		}
+
+		if !aws.BoolValue(resp.IsTruncated) {
+			break
+		}
+		params.DNSName = resp.NextDNSName
+		params.HostedZoneId = resp.NextHostedZoneId
+	}
+
+	return zones, nil
+}
+
+func (r *Route53Provider) associatedWithVPC(zoneId, vpcId string) (bool, error) {
+	resp, err := r.getHostedZone(zoneId)
+	if err != nil {
+		return false, err
+	}
+	for _, vpc := range resp.VPCs {
+		if aws.StringValue(vpc.VPCId) == vpcId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *Route53Provider) getHostedZone(zoneId string) (*awsRoute53.GetHostedZoneOutput, error) {
+	r.limiter.Wait(1)
+	params := &awsRoute53.GetHostedZoneInput{
+		Id: aws.String(zoneId),
+	}
+	resp, err := r.client.GetHostedZone(params)
+	if err != nil {
+		return nil, fmt.Errorf("Could not look up hosted zone ID %s: %v",
+			zoneId, err)
+	}
+
+	return resp, nil
+}
+
+func newHostedZone(zone *awsRoute53.HostedZone) hostedZone {
+	return hostedZone{
+		id:      strings.TrimPrefix(*zone.Id, "/hostedzone/"),
+		private: zone.Config != nil && aws.BoolValue(zone.Config.PrivateZone),
+	}
+}
+
+// zonesForRecord returns the hosted zones the record is published to
+func (r *Route53Provider) zonesForRecord(record utils.DnsRecord) ([]hostedZone, error) {
+	zone := utils.ZoneForFqdn(record.Fqdn, r.zoneNames)
+	if zone == "" {
+		return nil, fmt.Errorf("No hosted zone configured for '%s'", record.Fqdn)
+	}
+
+	var zones []hostedZone
+	for _, z := range r.hostedZones[zone] {
+		if record.Visibility == "" || record.Visibility == z.visibility() {
+			zones = append(zones, z)
+		}
+	}
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("No %s hosted zone configured for '%s'", record.Visibility, record.Fqdn)
+	}
+	return zones, nil
+}
+
+// mergeSplitHorizon returns the records of the public and the private
+// zone of a split-horizon domain. Records that are identical in both
+// zones are returned once without a visibility.
+func mergeSplitHorizon(public, private []utils.DnsRecord) []utils.DnsRecord {
+	privateRecs := make(map[string]utils.DnsRecord, len(private))
+	for _, rec := range private {
+		privateRecs[utils.RecordKey(rec)] = rec
+	}
+
+	var merged []utils.DnsRecord
+	for _, rec := range public {
+		key := utils.RecordKey(rec)
+		if privateRec, ok := privateRecs[key]; ok && sameRecord(rec, privateRec) {
+			delete(privateRecs, key)
+			merged = append(merged, rec)
+			continue
+		}
+		rec.Visibility = utils.VisibilityPublic
+		merged = append(merged, rec)
+	}
+
+	for _, rec := range private {
+		if _, ok := privateRecs[utils.RecordKey(rec)]; ok {
+			rec.Visibility = utils.VisibilityPrivate
+			merged = append(merged, rec)
+		}
+	}
+
+	return merged
+}
+
+// sameRecord returns true if the records are equal regardless
+// of the order their values are returned in
+func sameRecord(a, b utils.DnsRecord) bool {
+	a.Records = sortedValues(a.Records)
+	b.Records = sortedValues(b.Records)
+	return reflect.DeepEqual(a, b)
+}
+
+func sortedValues(values []string) []string {
+	sorted := make([]string, len(values))
+	copy(sorted, values)
+	sort.Strings(sorted)
+	return sorted
+}
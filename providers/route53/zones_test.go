@@ -0,0 +1,31 @@
+package route53
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rancher/external-dns/utils"
+)
+
+func TestMergeSplitHorizon(t *testing.T) {
+	public := []utils.DnsRecord{
+		{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1", "10.0.0.2"}},
+		{Fqdn: "api.example.com.", Type: "A", TTL: 300, Records: []string{"203.0.113.1"}},
+	}
+	private := []utils.DnsRecord{
+		// the same values listed in another order
+		{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.2", "10.0.0.1"}},
+		{Fqdn: "api.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.1.1"}},
+		{Fqdn: "db.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.2.1"}},
+	}
+
+	want := []utils.DnsRecord{
+		{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1", "10.0.0.2"}},
+		{Fqdn: "api.example.com.", Type: "A", TTL: 300, Records: []string{"203.0.113.1"}, Visibility: utils.VisibilityPublic},
+		{Fqdn: "api.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.1.1"}, Visibility: utils.VisibilityPrivate},
+		{Fqdn: "db.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.2.1"}, Visibility: utils.VisibilityPrivate},
+	}
+	if merged := mergeSplitHorizon(public, private); !reflect.DeepEqual(merged, want) {
+		t.Errorf("got records %+v, want %+v", merged, want)
+	}
+}
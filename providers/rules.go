@@ -0,0 +1,217 @@
+package providers
+
+import (
+	"strings"
+
+	"github.com/rancher/external-dns/utils"
+)
+
+// BasicRecordTypes are the record types whose values are written
+// as is. Some providers hold the fields of MX and SRV records apart.
+var BasicRecordTypes = []string{"A", "AAAA", "CNAME", "TXT"}
+
+// RecordRules describe how a provider stores records. Records are
+// compared after applying the rules so that records which the provider
+// stores differently from how they were written converge.
+type RecordRules struct {
+	// MinTTL and MaxTTL bound the TTLs the provider stores.
+	// Zero means no bound.
+	MinTTL int
+	MaxTTL int
+	// QuotedTXT is set if the provider returns the values
+	// of TXT records enclosed in double quotes.
+	QuotedTXT bool
+	// CaseInsensitive is set if the provider does not
+	// preserve the case of names and values.
+	CaseInsensitive bool
+	// RecordTypes are the record types the provider can
+	// publish. Empty means all types.
+	RecordTypes []string
+	// Alias is set if the provider supports alias records
+	Alias bool
+	// RoutingPolicy is set if the provider supports routing policies
+	RoutingPolicy bool
+	// ZoneVisibility is the visibility of the zone holding the FQDN.
+	// Empty means public.
+	ZoneVisibility string
+	// SplitHorizon is set if both a public and a private zone hold
+	// the FQDN, in which case records may be restricted to either.
+	SplitHorizon bool
+	// HealthChecks is set if the provider checks the endpoints of
+	// records with a health check. Other providers publish them as is.
+	HealthChecks bool
+	// Proxied is set if the provider can proxy records, in
+	// which case it stores ProxiedTTL as their TTL. Other
+	// providers publish proxied records as is.
+	Proxied    bool
+	ProxiedTTL int
+}
+
+// RecordRulesProvider is implemented by providers that declare
+// rules for the records of a FQDN
+type RecordRulesProvider interface {
+	RecordRules(fqdn string) RecordRules
+}
+
+// GetRecordRules returns the rules the provider applies to records
+// of the FQDN. Providers that declare no rules store records as is.
+func GetRecordRules(provider Provider, fqdn string) RecordRules {
+	if rp, ok := provider.(RecordRulesProvider); ok {
+		return rp.RecordRules(fqdn)
+	}
+	return RecordRules{}
+}
+
+// NormalizeTTL returns the TTL the provider stores for the given TTL
+func (r RecordRules) NormalizeTTL(ttl int) int {
+	if r.MinTTL > 0 && ttl < r.MinTTL {
+		ttl = r.MinTTL
+	}
+	if r.MaxTTL > 0 && ttl > r.MaxTTL {
+		ttl = r.MaxTTL
+	}
+	return ttl
+}
+
+// NormalizeValue returns the value of a record of the given
+// type in the form it is compared in
+func (r RecordRules) NormalizeValue(recordType, value string) string {
+	// TXT values are case sensitive
+	if recordType == "TXT" {
+		if r.QuotedTXT && len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value = value[1 : len(value)-1]
+		}
+		return value
+	}
+	if r.CaseInsensitive {
+		value = strings.ToLower(value)
+	}
+	return normalizeTarget(recordType, value)
+}
+
+// normalizeTarget returns the value with the host name it targets as
+// FQDN, as providers return host names with or without trailing dot
+func normalizeTarget(recordType, value string) string {
+	var hostField int
+	switch recordType {
+	case "CNAME":
+		return utils.Fqdn(value)
+	case "MX":
+		// 'preference host'
+		hostField = 1
+	case "SRV":
+		// 'priority weight port target'
+		hostField = 3
+	default:
+		return value
+	}
+	fields := strings.Fields(value)
+	if len(fields) != hostField+1 {
+		return value
+	}
+	fields[hostField] = utils.Fqdn(fields[hostField])
+	return strings.Join(fields, " ")
+}
+
+// Normalize returns the record the provider stores when the given
+// record is written. Values of records read from the provider are
+// normalized the same way, the TTL is left alone.
+func (r RecordRules) Normalize(record utils.DnsRecord) utils.DnsRecord {
+	normalized := utils.DnsRecord{
+		Fqdn:          record.Fqdn,
+		Type:          record.Type,
+		TTL:           r.NormalizeTTL(record.TTL),
+		Proxied:       record.Proxied,
+		Records:       make([]string, len(record.Records)),
+		SetIdentifier: record.SetIdentifier,
+		RoutingPolicy: record.RoutingPolicy,
+		Visibility:    record.Visibility,
+		HealthCheck:   record.HealthCheck,
+	}
+	if record.Proxied && r.Proxied {
+		normalized.TTL = r.ProxiedTTL
+	}
+	if r.CaseInsensitive {
+		normalized.Fqdn = strings.ToLower(normalized.Fqdn)
+	}
+	for idx, value := range record.Records {
+		normalized.Records[idx] = r.NormalizeValue(record.Type, value)
+	}
+	if record.Alias != nil {
+		alias := *record.Alias
+		alias.DNSName = utils.Fqdn(strings.ToLower(alias.DNSName))
+		normalized.Alias = &alias
+	}
+	return normalized
+}
+
+// Unsupported returns the reason why the provider cannot
+// publish the record, or an empty string if it can
+func (r RecordRules) Unsupported(record utils.DnsRecord) string {
+	if !r.supportsType(record.Type) {
+		return record.Type + " records are not supported"
+	}
+	if record.Alias != nil && !r.Alias {
+		return "alias records are not supported"
+	}
+	if record.RoutingPolicy != nil && !r.RoutingPolicy {
+		return "routing policies are not supported"
+	}
+	return ""
+}
+
+func (r RecordRules) supportsType(recordType string) bool {
+	if len(r.RecordTypes) == 0 {
+		return true
+	}
+	for _, t := range r.RecordTypes {
+		if t == recordType {
+			return true
+		}
+	}
+	return false
+}
+
+// Visibility returns the visibility the record is published with,
+// which is empty unless the FQDN is held by a split-horizon zone,
+// and false if the provider has no zone the record is restricted to
+func (r RecordRules) Visibility(record utils.DnsRecord) (string, bool) {
+	if record.Visibility == "" || r.SplitHorizon {
+		return record.Visibility, true
+	}
+	zoneVisibility := r.ZoneVisibility
+	if zoneVisibility == "" {
+		zoneVisibility = utils.VisibilityPublic
+	}
+	return "", record.Visibility == zoneVisibility
+}
+
+// SameRoutingPolicy returns true if both routing policies are equal
+func SameRoutingPolicy(a, b *utils.RoutingPolicy) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if (a.Weight == nil) != (b.Weight == nil) || (a.Weight != nil && *a.Weight != *b.Weight) {
+		return false
+	}
+	return a.Region == b.Region && a.Failover == b.Failover
+}
+
+// SameHealthCheck returns true if both health checks are equal
+func SameHealthCheck(a, b *utils.HealthCheck) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// SameAlias returns true if the normalized records have the same
+// alias target. A target without hosted zone ID matches any zone.
+func SameAlias(desired, actual *utils.Alias) bool {
+	if desired == nil || actual == nil {
+		return desired == actual
+	}
+	return desired.DNSName == actual.DNSName &&
+		desired.EvaluateTargetHealth == actual.EvaluateTargetHealth &&
+		(desired.HostedZoneId == "" || desired.HostedZoneId == actual.HostedZoneId)
+}
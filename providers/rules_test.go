@@ -0,0 +1,157 @@
+package providers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rancher/external-dns/utils"
+)
+
+func TestRecordRulesNormalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  RecordRules
+		record utils.DnsRecord
+		want   utils.DnsRecord
+	}{
+		{
+			name:   "no rules",
+			record: utils.DnsRecord{Fqdn: "WWW.example.com.", Type: "CNAME", TTL: 5, Records: []string{"Target.example.com."}},
+			want:   utils.DnsRecord{Fqdn: "WWW.example.com.", Type: "CNAME", TTL: 5, Records: []string{"Target.example.com."}},
+		},
+		{
+			name:   "TTL below the minimum",
+			rules:  RecordRules{MinTTL: 60, MaxTTL: 3600},
+			record: utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 5, Records: []string{"10.0.0.1"}},
+			want:   utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 60, Records: []string{"10.0.0.1"}},
+		},
+		{
+			name:   "TTL above the maximum",
+			rules:  RecordRules{MinTTL: 60, MaxTTL: 3600},
+			record: utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 86400, Records: []string{"10.0.0.1"}},
+			want:   utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 3600, Records: []string{"10.0.0.1"}},
+		},
+		{
+			name:   "TTL within the bounds",
+			rules:  RecordRules{MinTTL: 60, MaxTTL: 3600},
+			record: utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}},
+			want:   utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}},
+		},
+		{
+			name:   "case insensitive provider",
+			rules:  RecordRules{CaseInsensitive: true},
+			record: utils.DnsRecord{Fqdn: "WWW.example.com.", Type: "CNAME", TTL: 300, Records: []string{"Target.example.com."}},
+			want:   utils.DnsRecord{Fqdn: "www.example.com.", Type: "CNAME", TTL: 300, Records: []string{"target.example.com."}},
+		},
+		{
+			name:   "TXT values keep their case",
+			rules:  RecordRules{CaseInsensitive: true},
+			record: utils.DnsRecord{Fqdn: "www.example.com.", Type: "TXT", TTL: 300, Records: []string{"Some Text"}},
+			want:   utils.DnsRecord{Fqdn: "www.example.com.", Type: "TXT", TTL: 300, Records: []string{"Some Text"}},
+		},
+		{
+			name:   "quoted TXT values",
+			rules:  RecordRules{QuotedTXT: true},
+			record: utils.DnsRecord{Fqdn: "www.example.com.", Type: "TXT", TTL: 300, Records: []string{`"v=spf1 -all"`, "unquoted"}},
+			want:   utils.DnsRecord{Fqdn: "www.example.com.", Type: "TXT", TTL: 300, Records: []string{"v=spf1 -all", "unquoted"}},
+		},
+		{
+			name:   "quotes of TXT values kept by providers returning them as written",
+			record: utils.DnsRecord{Fqdn: "www.example.com.", Type: "TXT", TTL: 300, Records: []string{`"quoted"`}},
+			want:   utils.DnsRecord{Fqdn: "www.example.com.", Type: "TXT", TTL: 300, Records: []string{`"quoted"`}},
+		},
+		{
+			name:   "host targets returned without trailing dot",
+			record: utils.DnsRecord{Fqdn: "www.example.com.", Type: "CNAME", TTL: 300, Records: []string{"origin.example.net"}},
+			want:   utils.DnsRecord{Fqdn: "www.example.com.", Type: "CNAME", TTL: 300, Records: []string{"origin.example.net."}},
+		},
+		{
+			name:   "MX targets",
+			record: utils.DnsRecord{Fqdn: "example.com.", Type: "MX", TTL: 300, Records: []string{"10 mail.example.com", "20 backup.example.com."}},
+			want:   utils.DnsRecord{Fqdn: "example.com.", Type: "MX", TTL: 300, Records: []string{"10 mail.example.com.", "20 backup.example.com."}},
+		},
+		{
+			name:   "SRV target",
+			record: utils.DnsRecord{Fqdn: "_sip._tcp.example.com.", Type: "SRV", TTL: 300, Records: []string{"10 5 5060 sip.example.com"}},
+			want:   utils.DnsRecord{Fqdn: "_sip._tcp.example.com.", Type: "SRV", TTL: 300, Records: []string{"10 5 5060 sip.example.com."}},
+		},
+		{
+			name:   "proxied record",
+			rules:  RecordRules{Proxied: true, ProxiedTTL: 1, MinTTL: 120},
+			record: utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 300, Proxied: true, Records: []string{"10.0.0.1"}},
+			want:   utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 1, Proxied: true, Records: []string{"10.0.0.1"}},
+		},
+		{
+			name:   "proxied record on a provider that cannot proxy",
+			record: utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 300, Proxied: true, Records: []string{"10.0.0.1"}},
+			want:   utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 300, Proxied: true, Records: []string{"10.0.0.1"}},
+		},
+		{
+			name:  "alias target",
+			rules: RecordRules{Alias: true},
+			record: utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{},
+				Alias: &utils.Alias{DNSName: "LB-1.eu-west-1.elb.amazonaws.com", EvaluateTargetHealth: true}},
+			want: utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{},
+				Alias: &utils.Alias{DNSName: "lb-1.eu-west-1.elb.amazonaws.com.", EvaluateTargetHealth: true}},
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.rules.Normalize(test.record); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRecordRulesNormalizeKeepsAlias(t *testing.T) {
+	alias := &utils.Alias{DNSName: "Target.example.com."}
+	record := utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", Alias: alias}
+	RecordRules{}.Normalize(record)
+	if alias.DNSName != "Target.example.com." {
+		t.Errorf("the alias of the record was changed to %s", alias.DNSName)
+	}
+}
+
+func TestRecordRulesUnsupported(t *testing.T) {
+	addressTypes := RecordRules{RecordTypes: []string{"A", "AAAA", "CNAME", "TXT"}}
+
+	tests := []struct {
+		name        string
+		rules       RecordRules
+		record      utils.DnsRecord
+		unsupported bool
+	}{
+		{
+			name:   "all types supported",
+			record: utils.DnsRecord{Fqdn: "example.com.", Type: "MX", Records: []string{"10 mail.example.com."}},
+		},
+		{
+			name:   "supported type",
+			rules:  addressTypes,
+			record: utils.DnsRecord{Fqdn: "www.example.com.", Type: "AAAA", Records: []string{"2001:db8::1"}},
+		},
+		{
+			name:        "unsupported type",
+			rules:       addressTypes,
+			record:      utils.DnsRecord{Fqdn: "_sip._tcp.example.com.", Type: "SRV", Records: []string{"10 20 5060 sip.example.com."}},
+			unsupported: true,
+		},
+		{
+			name:        "alias record",
+			record:      utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", Alias: &utils.Alias{DNSName: "lb.example.net."}},
+			unsupported: true,
+		},
+		{
+			name:        "routing policy",
+			record:      utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", RoutingPolicy: &utils.RoutingPolicy{Region: "eu-west-1"}, Records: []string{"10.0.0.1"}},
+			unsupported: true,
+		},
+	}
+
+	for _, test := range tests {
+		reason := test.rules.Unsupported(test.record)
+		if unsupported := reason != ""; unsupported != test.unsupported {
+			t.Errorf("%s: got reason %q, want unsupported %v", test.name, reason, test.unsupported)
+		}
+	}
+}
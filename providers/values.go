@@ -0,0 +1,150 @@
+package providers
+
+import (
+	"github.com/rancher/external-dns/utils"
+)
+
+// RecordValue is a single value of a record as stored by providers
+// that store each value of a record as a separate record of their own
+type RecordValue struct {
+	// ID identifies the provider's record holding the value
+	ID      string
+	Fqdn    string
+	Type    string
+	TTL     int
+	Proxied bool
+	Value   string
+}
+
+// GroupRecordValues groups the values by name and type into records,
+// keeping the order in which they were listed. Values of the same
+// record that disagree on their TTL give it a TTL of 0 so that the
+// record differs from any desired record and all values are updated
+// to the same TTL.
+func GroupRecordValues(values []RecordValue) []utils.DnsRecord {
+	var records []utils.DnsRecord
+	recordIdx := make(map[string]int)
+	for _, value := range values {
+		record := utils.DnsRecord{
+			Fqdn:    value.Fqdn,
+			Type:    value.Type,
+			TTL:     value.TTL,
+			Proxied: value.Proxied,
+		}
+		key := utils.RecordKey(record)
+		if idx, ok := recordIdx[key]; ok {
+			records[idx].Records = append(records[idx].Records, value.Value)
+			if records[idx].TTL != value.TTL {
+				records[idx].TTL = 0
+			}
+			continue
+		}
+		record.Records = []string{value.Value}
+		recordIdx[key] = len(records)
+		records = append(records, record)
+	}
+	return records
+}
+
+// ValueUpdate rewrites an existing value of a record
+type ValueUpdate struct {
+	Existing RecordValue
+	Value    string
+}
+
+// ValueChanges are the changes that turn the existing values
+// of a record into the values of the desired record
+type ValueChanges struct {
+	Create []string
+	Update []ValueUpdate
+	Delete []RecordValue
+}
+
+// DiffRecordValues computes the changes of the existing values of a
+// record that publish the desired record. Values are matched after
+// normalizing them with the rules. Kept values are only updated if
+// their TTL or proxying differs from the stored TTL and proxying of
+// the record, and values that are no longer desired are rewritten to
+// added values before any remaining added value is created. Only the
+// values left over are deleted.
+func DiffRecordValues(rules RecordRules, existing []RecordValue, record utils.DnsRecord) ValueChanges {
+	var changes ValueChanges
+	desired := rules.Normalize(record)
+
+	current := make(map[string]int, len(existing))
+	for idx, value := range existing {
+		normalized := rules.NormalizeValue(value.Type, value.Value)
+		if _, ok := current[normalized]; !ok {
+			current[normalized] = idx
+		}
+	}
+
+	var added []string
+	kept := make(map[int]struct{}, len(existing))
+	for _, value := range record.Records {
+		idx, ok := current[rules.NormalizeValue(record.Type, value)]
+		if !ok {
+			added = append(added, value)
+			continue
+		}
+		if _, ok := kept[idx]; ok {
+			continue
+		}
+		kept[idx] = struct{}{}
+		rec := existing[idx]
+		if rec.TTL != desired.TTL || (rules.Proxied && rec.Proxied != desired.Proxied) {
+			changes.Update = append(changes.Update, ValueUpdate{Existing: rec, Value: rec.Value})
+		}
+	}
+
+	var unused []RecordValue
+	for idx, rec := range existing {
+		if _, ok := kept[idx]; !ok {
+			unused = append(unused, rec)
+		}
+	}
+
+	for _, value := range added {
+		if len(unused) == 0 {
+			changes.Create = append(changes.Create, value)
+			continue
+		}
+		changes.Update = append(changes.Update, ValueUpdate{Existing: unused[0], Value: value})
+		unused = unused[1:]
+	}
+	changes.Delete = unused
+
+	return changes
+}
+
+// ValueWriter is implemented by providers that store each value of a
+// record separately. The record passed in is the desired record, whose
+// TTL and proxying the created and updated values get.
+type ValueWriter interface {
+	CreateValue(record utils.DnsRecord, value string) error
+	UpdateValue(record utils.DnsRecord, existing RecordValue, value string) error
+	DeleteValue(record utils.DnsRecord, existing RecordValue) error
+}
+
+// UpdateRecordValues changes the existing values of a record in place
+// so that the name keeps resolving while the record is updated. Values
+// are updated and created before the leftovers are deleted.
+func UpdateRecordValues(w ValueWriter, rules RecordRules, existing []RecordValue, record utils.DnsRecord) error {
+	changes := DiffRecordValues(rules, existing, record)
+	for _, update := range changes.Update {
+		if err := w.UpdateValue(record, update.Existing, update.Value); err != nil {
+			return err
+		}
+	}
+	for _, value := range changes.Create {
+		if err := w.CreateValue(record, value); err != nil {
+			return err
+		}
+	}
+	for _, value := range changes.Delete {
+		if err := w.DeleteValue(record, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
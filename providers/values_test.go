@@ -0,0 +1,182 @@
+package providers
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/external-dns/utils"
+)
+
+func TestGroupRecordValues(t *testing.T) {
+	values := []RecordValue{
+		{ID: "1", Fqdn: "www.example.com.", Type: "A", TTL: 300, Value: "10.0.0.1"},
+		{ID: "2", Fqdn: "api.example.com.", Type: "A", TTL: 60, Value: "10.0.0.3"},
+		{ID: "3", Fqdn: "www.example.com.", Type: "A", TTL: 300, Value: "10.0.0.2"},
+		{ID: "4", Fqdn: "www.example.com.", Type: "TXT", TTL: 300, Value: "text"},
+		{ID: "5", Fqdn: "api.example.com.", Type: "A", TTL: 120, Value: "10.0.0.4"},
+		{ID: "6", Fqdn: "cdn.example.com.", Type: "CNAME", TTL: 1, Proxied: true, Value: "origin.example.com."},
+	}
+
+	want := []utils.DnsRecord{
+		{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1", "10.0.0.2"}},
+		// values disagreeing on the TTL
+		{Fqdn: "api.example.com.", Type: "A", TTL: 0, Records: []string{"10.0.0.3", "10.0.0.4"}},
+		{Fqdn: "www.example.com.", Type: "TXT", TTL: 300, Records: []string{"text"}},
+		{Fqdn: "cdn.example.com.", Type: "CNAME", TTL: 1, Proxied: true, Records: []string{"origin.example.com."}},
+	}
+
+	if records := GroupRecordValues(values); !reflect.DeepEqual(records, want) {
+		t.Errorf("GroupRecordValues() = %v, want %v", records, want)
+	}
+	if records := GroupRecordValues(nil); len(records) != 0 {
+		t.Errorf("GroupRecordValues(nil) = %v, want none", records)
+	}
+}
+
+func TestDiffRecordValues(t *testing.T) {
+	value := func(id, v string, ttl int) RecordValue {
+		return RecordValue{ID: id, Fqdn: "www.example.com.", Type: "A", TTL: ttl, Value: v}
+	}
+	record := func(ttl int, values ...string) utils.DnsRecord {
+		return utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: ttl, Records: values}
+	}
+
+	tests := []struct {
+		name     string
+		rules    RecordRules
+		existing []RecordValue
+		record   utils.DnsRecord
+		changes  ValueChanges
+	}{
+		{
+			name:     "unchanged",
+			existing: []RecordValue{value("1", "10.0.0.1", 300), value("2", "10.0.0.2", 300)},
+			record:   record(300, "10.0.0.2", "10.0.0.1"),
+		},
+		{
+			name:     "new record",
+			record:   record(300, "10.0.0.1", "10.0.0.2"),
+			changes:  ValueChanges{Create: []string{"10.0.0.1", "10.0.0.2"}},
+			existing: nil,
+		},
+		{
+			name:     "TTL changed",
+			existing: []RecordValue{value("1", "10.0.0.1", 300), value("2", "10.0.0.2", 60)},
+			record:   record(60, "10.0.0.1", "10.0.0.2"),
+			changes: ValueChanges{Update: []ValueUpdate{
+				{Existing: value("1", "10.0.0.1", 300), Value: "10.0.0.1"},
+			}},
+		},
+		{
+			name:     "TTL normalized by the rules",
+			rules:    RecordRules{MinTTL: 120},
+			existing: []RecordValue{value("1", "10.0.0.1", 120)},
+			record:   record(60, "10.0.0.1"),
+		},
+		{
+			name:     "unused values are rewritten before values are created",
+			existing: []RecordValue{value("1", "10.0.0.1", 300), value("2", "10.0.0.2", 300)},
+			record:   record(300, "10.0.0.2", "10.0.0.3", "10.0.0.4"),
+			changes: ValueChanges{
+				Update: []ValueUpdate{{Existing: value("1", "10.0.0.1", 300), Value: "10.0.0.3"}},
+				Create: []string{"10.0.0.4"},
+				Delete: []RecordValue{},
+			},
+		},
+		{
+			name:     "values left over are deleted",
+			existing: []RecordValue{value("1", "10.0.0.1", 300), value("2", "10.0.0.2", 300), value("3", "10.0.0.3", 300)},
+			record:   record(300, "10.0.0.3"),
+			changes: ValueChanges{Delete: []RecordValue{
+				value("1", "10.0.0.1", 300), value("2", "10.0.0.2", 300),
+			}},
+		},
+		{
+			name:     "duplicate existing values",
+			existing: []RecordValue{value("1", "10.0.0.1", 300), value("2", "10.0.0.1", 300)},
+			record:   record(300, "10.0.0.1"),
+			changes:  ValueChanges{Delete: []RecordValue{value("2", "10.0.0.1", 300)}},
+		},
+		{
+			name:  "values are compared case insensitively",
+			rules: RecordRules{CaseInsensitive: true},
+			existing: []RecordValue{
+				{ID: "1", Fqdn: "www.example.com.", Type: "CNAME", TTL: 300, Value: "target.example.com."},
+			},
+			record: utils.DnsRecord{Fqdn: "www.example.com.", Type: "CNAME", TTL: 300, Records: []string{"Target.Example.com."}},
+		},
+		{
+			name:  "quoted TXT values",
+			rules: RecordRules{QuotedTXT: true},
+			existing: []RecordValue{
+				{ID: "1", Fqdn: "www.example.com.", Type: "TXT", TTL: 300, Value: `"v=spf1 -all"`},
+			},
+			record: utils.DnsRecord{Fqdn: "www.example.com.", Type: "TXT", TTL: 300, Records: []string{"v=spf1 -all"}},
+		},
+		{
+			name: "CNAME target returned without trailing dot",
+			existing: []RecordValue{
+				{ID: "1", Fqdn: "www.example.com.", Type: "CNAME", TTL: 300, Value: "origin.example.net"},
+			},
+			record: utils.DnsRecord{Fqdn: "www.example.com.", Type: "CNAME", TTL: 300, Records: []string{"origin.example.net."}},
+		},
+		{
+			name:  "proxying changed",
+			rules: RecordRules{Proxied: true, ProxiedTTL: 1},
+			existing: []RecordValue{
+				{ID: "1", Fqdn: "www.example.com.", Type: "A", TTL: 300, Value: "10.0.0.1"},
+			},
+			record: utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 300, Proxied: true, Records: []string{"10.0.0.1"}},
+			changes: ValueChanges{Update: []ValueUpdate{
+				{Existing: RecordValue{ID: "1", Fqdn: "www.example.com.", Type: "A", TTL: 300, Value: "10.0.0.1"}, Value: "10.0.0.1"},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		changes := DiffRecordValues(test.rules, test.existing, test.record)
+		if !reflect.DeepEqual(changes, test.changes) {
+			t.Errorf("%s: got changes %+v, want %+v", test.name, changes, test.changes)
+		}
+	}
+}
+
+// valueLog is a ValueWriter logging the operations made
+type valueLog []string
+
+func (l *valueLog) CreateValue(record utils.DnsRecord, value string) error {
+	*l = append(*l, fmt.Sprintf("create %s %d", value, record.TTL))
+	return nil
+}
+
+func (l *valueLog) UpdateValue(record utils.DnsRecord, existing RecordValue, value string) error {
+	*l = append(*l, fmt.Sprintf("update %s %s %d", existing.ID, value, record.TTL))
+	return nil
+}
+
+func (l *valueLog) DeleteValue(record utils.DnsRecord, existing RecordValue) error {
+	*l = append(*l, "delete "+existing.ID)
+	return nil
+}
+
+func TestUpdateRecordValues(t *testing.T) {
+	existing := []RecordValue{
+		{ID: "1", Fqdn: "www.example.com.", Type: "A", TTL: 300, Value: "10.0.0.1"},
+		{ID: "2", Fqdn: "www.example.com.", Type: "A", TTL: 300, Value: "10.0.0.2"},
+		{ID: "3", Fqdn: "www.example.com.", Type: "A", TTL: 300, Value: "10.0.0.3"},
+	}
+	record := utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 60, Records: []string{"10.0.0.4", "10.0.0.3"}}
+
+	var log valueLog
+	if err := UpdateRecordValues(&log, RecordRules{}, existing, record); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the kept value gets the new TTL and a value is reused before
+	// the leftover is deleted, so the name resolves throughout
+	want := valueLog{"update 3 10.0.0.3 60", "update 1 10.0.0.4 60", "delete 2"}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("got operations %q, want %q", log, want)
+	}
+}
@@ -0,0 +1,298 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/external-dns/sources"
+	"github.com/rancher/external-dns/utils"
+)
+
+const (
+	defaultConsulAddr = "http://127.0.0.1:8500"
+	// prefix of the labels that service tags may omit
+	labelPrefix = "io.rancher.service."
+
+	catalogServicesPath = "/v1/catalog/services"
+	healthStatePath     = "/v1/health/state/any"
+)
+
+// ConsulSource publishes the services of a Consul catalog. Service tags
+// of the form 'key=value' are used as labels, with the prefix
+// 'io.rancher.service.' being optional. Services are named after the
+// Consul service and datacenter.
+type ConsulSource struct {
+	client *http.Client
+	// watchClient makes blocking queries that take up to the wait time
+	watchClient     *http.Client
+	addr            string
+	token           string
+	datacenter      string
+	environmentName string
+	environmentUUID string
+	// last known-good record values of the services that fail open
+	knownGood sources.KnownGood
+
+	mu sync.Mutex
+	// watches of the catalog and the health checks
+	watches []*watch
+}
+
+type agentSelf struct {
+	Config struct {
+		Datacenter string `json:"Datacenter"`
+	} `json:"Config"`
+}
+
+type serviceEntry struct {
+	Node struct {
+		Node    string `json:"Node"`
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID      string   `json:"ID"`
+		Service string   `json:"Service"`
+		Address string   `json:"Address"`
+		Tags    []string `json:"Tags"`
+	} `json:"Service"`
+	Checks []struct {
+		Status string `json:"Status"`
+	} `json:"Checks"`
+}
+
+func init() {
+	sources.RegisterSource("consul", func() sources.Source {
+		return &ConsulSource{}
+	})
+}
+
+func (c *ConsulSource) Init() error {
+	if c.addr = os.Getenv("CONSUL_HTTP_ADDR"); len(c.addr) == 0 {
+		c.addr = defaultConsulAddr
+	}
+	if !strings.Contains(c.addr, "://") {
+		c.addr = "http://" + c.addr
+	}
+	c.addr = strings.TrimSuffix(c.addr, "/")
+	c.token = os.Getenv("CONSUL_HTTP_TOKEN")
+	c.client = &http.Client{Timeout: 30 * time.Second}
+	c.watchClient = &http.Client{Timeout: 30*time.Second + blockingWait + blockingWait/16}
+
+	if c.datacenter = os.Getenv("CONSUL_DATACENTER"); len(c.datacenter) == 0 {
+		var self agentSelf
+		if _, err := c.get("/v1/agent/self", &self); err != nil {
+			return fmt.Errorf("Failed to get Consul agent info: %v", err)
+		}
+		c.datacenter = self.Config.Datacenter
+	}
+
+	if c.environmentName = os.Getenv("ENVIRONMENT_NAME"); len(c.environmentName) == 0 {
+		c.environmentName = "consul"
+	}
+
+	if c.environmentUUID = os.Getenv("ENVIRONMENT_UUID"); len(c.environmentUUID) == 0 {
+		c.environmentUUID = "consul-" + c.datacenter
+	}
+
+	c.watches = []*watch{{path: catalogServicesPath}, {path: healthStatePath}}
+	for _, w := range c.watches {
+		go c.watch(w)
+	}
+
+	logrus.Infof("Configured %s source at %s for datacenter %s", c.GetName(), c.addr, c.datacenter)
+	return nil
+}
+
+func (*ConsulSource) GetName() string {
+	return "Consul"
+}
+
+func (c *ConsulSource) HealthCheck() error {
+	var leader string
+	_, err := c.get("/v1/status/leader", &leader)
+	return err
+}
+
+// GetVersion returns the Raft indexes of the catalog and the
+// health checks, which change with any of the services and are
+// followed with blocking queries
+func (c *ConsulSource) GetVersion() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	indexes := make([]string, len(c.watches))
+	for idx, w := range c.watches {
+		if w.err != nil {
+			return "", fmt.Errorf("Failed to watch %s: %v", w.path, w.err)
+		}
+		indexes[idx] = w.index
+	}
+	return strings.Join(indexes, "/"), nil
+}
+
+func (c *ConsulSource) GetEnvironment() (string, string) {
+	return c.environmentName, c.environmentUUID
+}
+
+func (c *ConsulSource) GetDnsRecords() (map[string]utils.MetadataDnsRecord, error) {
+	var services map[string][]string
+	if _, err := c.get(catalogServicesPath, &services); err != nil {
+		return nil, fmt.Errorf("Failed to list services: %v", err)
+	}
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dnsEntries := make(map[string]utils.MetadataDnsRecord)
+	for _, name := range names {
+		var entries []serviceEntry
+		if _, err := c.get("/v1/health/service/"+url.PathEscape(name), &entries); err != nil {
+			return dnsEntries, fmt.Errorf("Failed to get instances of service %s: %v", name, err)
+		}
+
+		labels := tagLabels(services[name])
+		values := c.getHealthyValues(name, labels, entries)
+		sources.AddServiceRecords(labels, name, c.datacenter, c.environmentName,
+			name == "consul", values, dnsEntries)
+	}
+	c.knownGood.Rotate()
+
+	records, err := sources.ReadStaticRecordsFile()
+	if err != nil {
+		return dnsEntries, err
+	}
+	sources.AddStaticRecords(records, dnsEntries)
+
+	return dnsEntries, nil
+}
+
+// getHealthyValues returns the addresses of the instances of the
+// service as selected by its health policy
+func (c *ConsulSource) getHealthyValues(name string, labels map[string]string, entries []serviceEntry) []sources.RecordValue {
+	policy, err := sources.ParseHealthPolicy(labels)
+	if err != nil {
+		logrus.Errorf("Skipping service %s: %v", name, err)
+		return nil
+	}
+
+	ipSource, err := sources.IPSource(labels)
+	if err != nil {
+		logrus.Errorf("Skipping service %s: %v", name, err)
+		return nil
+	}
+	if ipSource != "" && ipSource != "agent_ip" {
+		logrus.Errorf("Skipping service %s: IP source '%s' is not supported by %s", name, ipSource, c.GetName())
+		return nil
+	}
+
+	var values []sources.RecordValue
+	var healthy int
+	for _, entry := range entries {
+		health := healthState(entry)
+		if !policy.Included(health) {
+			continue
+		}
+
+		// the node address is used unless the instance has its own
+		ip := entry.Service.Address
+		if len(ip) == 0 || ipSource == "agent_ip" {
+			ip = entry.Node.Address
+		}
+
+		value, ok := sources.AddressValue(ip)
+		if !ok {
+			logrus.Errorf("Skipping instance %s of service %s: Invalid IP address %s", entry.Service.ID, name, ip)
+			continue
+		}
+		values = append(values, value)
+
+		if sources.Healthy(health) {
+			healthy++
+		}
+	}
+
+	return c.knownGood.Filter(sources.ServiceKey(c.datacenter, name), policy, values, healthy)
+}
+
+// tagLabels returns the 'key=value' tags of a service as labels
+func tagLabels(tags []string) map[string]string {
+	labels := make(map[string]string)
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := parts[0]
+		if strings.HasPrefix(key, "external_dns") {
+			key = labelPrefix + key
+		}
+		labels[key] = parts[1]
+	}
+	return labels
+}
+
+// healthState maps the worst status of the checks of a
+// service instance to the health states used by Rancher
+func healthState(entry serviceEntry) string {
+	state := "healthy"
+	for _, check := range entry.Checks {
+		switch check.Status {
+		case "critical":
+			return "unhealthy"
+		case "warning":
+			state = "degraded"
+		}
+	}
+	return state
+}
+
+// get decodes the response of the Consul API into v
+// and returns the Raft index of the response
+func (c *ConsulSource) get(path string, v interface{}) (string, error) {
+	return c.query(c.client, path, url.Values{}, v)
+}
+
+// query decodes the response of the Consul API to the query
+// into v and returns the Raft index of the response
+func (c *ConsulSource) query(client *http.Client, path string, query url.Values, v interface{}) (string, error) {
+	if len(c.datacenter) > 0 && (strings.HasPrefix(path, "/v1/catalog") || strings.HasPrefix(path, "/v1/health")) {
+		query.Set("dc", c.datacenter)
+	}
+	u := c.addr + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(c.token) > 0 {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Consul API returned %s for %s", resp.Status, path)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", err
+	}
+	return resp.Header.Get("X-Consul-Index"), nil
+}
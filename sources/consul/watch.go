@@ -0,0 +1,68 @@
+package consul
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// maximum time a blocking query waits for a change
+	blockingWait = 5 * time.Minute
+	// time to wait before retrying a failed query
+	watchRetryInterval = 5 * time.Second
+)
+
+// watch follows the Raft index of an endpoint
+type watch struct {
+	path  string
+	index string
+	// err is the error the query last failed with
+	err error
+}
+
+// watch follows the Raft index of the endpoint with blocking queries
+func (c *ConsulSource) watch(w *watch) {
+	var index uint64
+	for {
+		next, err := c.poll(w, index)
+		if err != nil {
+			logrus.Errorf("Failed to watch %s: %v", w.path, err)
+			time.Sleep(watchRetryInterval)
+			continue
+		}
+		index = next
+	}
+}
+
+// poll makes a blocking query that returns once the Raft index of the
+// endpoint moved past the given one or the wait time expired, and
+// returns the index to pass to the next query
+func (c *ConsulSource) poll(w *watch, index uint64) (uint64, error) {
+	query := url.Values{}
+	query.Set("index", strconv.FormatUint(index, 10))
+	query.Set("wait", blockingWait.String())
+
+	var response json.RawMessage
+	newIndex, err := c.query(c.watchClient, w.path, query, &response)
+	c.mu.Lock()
+	w.err = err
+	if err == nil {
+		w.index = newIndex
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return index, err
+	}
+
+	// the index is reset if it went backwards, e.g. after the
+	// cluster was restored, and must be at least 1 to block
+	next, err := strconv.ParseUint(newIndex, 10, 64)
+	if err != nil || next < index || next == 0 {
+		next = 1
+	}
+	return next, nil
+}
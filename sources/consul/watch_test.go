@@ -0,0 +1,66 @@
+package consul
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPoll(t *testing.T) {
+	var queries []string
+	indexes := []string{"5", "5", "3", "0", ""}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != healthStatePath || req.Header.Get("X-Consul-Token") != "token" {
+			http.NotFound(w, req)
+			return
+		}
+		queries = append(queries, req.URL.RawQuery)
+		w.Header().Set("X-Consul-Index", indexes[0])
+		indexes = indexes[1:]
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	c := &ConsulSource{
+		watchClient: &http.Client{},
+		addr:        server.URL,
+		token:       "token",
+		datacenter:  "dc1",
+	}
+	w := &watch{path: healthStatePath}
+	c.watches = []*watch{w}
+
+	var index uint64
+	// the index moves, stays on timeout, goes backwards, is zero and is missing
+	for _, want := range []uint64{5, 5, 1, 1, 1} {
+		next, err := c.poll(w, index)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if next != want {
+			t.Errorf("got index %d after %d, want %d", next, index, want)
+		}
+		index = next
+	}
+
+	wantQueries := []string{
+		"dc=dc1&index=0&wait=5m0s",
+		"dc=dc1&index=5&wait=5m0s",
+		"dc=dc1&index=5&wait=5m0s",
+		"dc=dc1&index=1&wait=5m0s",
+		"dc=dc1&index=1&wait=5m0s",
+	}
+	for idx, query := range queries {
+		if query != wantQueries[idx] {
+			t.Errorf("got query %s, want %s", query, wantQueries[idx])
+		}
+	}
+
+	server.Close()
+	if _, err := c.poll(w, index); err == nil {
+		t.Errorf("query of a stopped server succeeded")
+	}
+	if _, err := c.GetVersion(); err == nil {
+		t.Errorf("got version while the watch fails")
+	}
+}
@@ -0,0 +1,422 @@
+package docker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/external-dns/sources"
+	"github.com/rancher/external-dns/utils"
+)
+
+const (
+	defaultDockerHost = "unix:///var/run/docker.sock"
+	apiVersion        = "v1.24"
+	// stack name of containers that are not part of a compose project
+	defaultStackName = "default"
+)
+
+// DockerSource reads the running containers of a Docker engine. Containers
+// are grouped into services by their compose project and service labels.
+type DockerSource struct {
+	client *http.Client
+	// eventClient streams the events of the engine without a timeout
+	eventClient *http.Client
+	baseURL     string
+	// address of the Docker host published for its containers
+	hostIP          string
+	environmentName string
+	environmentUUID string
+	// last known-good record values of the services that fail open
+	knownGood sources.KnownGood
+
+	mu sync.Mutex
+	// changes counts the container events seen
+	changes uint64
+	// eventsErr is the error the event stream last failed
+	// with, or nil if the stream is connected
+	eventsErr error
+}
+
+type dockerInfo struct {
+	ID   string `json:"ID"`
+	Name string `json:"Name"`
+}
+
+type dockerPort struct {
+	IP          string `json:"IP"`
+	PrivatePort int    `json:"PrivatePort"`
+	PublicPort  int    `json:"PublicPort"`
+	Type        string `json:"Type"`
+}
+
+type dockerNetwork struct {
+	IPAddress         string `json:"IPAddress"`
+	GlobalIPv6Address string `json:"GlobalIPv6Address"`
+}
+
+type dockerContainer struct {
+	ID              string            `json:"Id"`
+	Names           []string          `json:"Names"`
+	Labels          map[string]string `json:"Labels"`
+	State           string            `json:"State"`
+	Status          string            `json:"Status"`
+	Ports           []dockerPort      `json:"Ports"`
+	NetworkSettings struct {
+		Networks map[string]dockerNetwork `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// dockerService is a group of containers published under one name
+type dockerService struct {
+	name       string
+	stack      string
+	labels     map[string]string
+	containers []dockerContainer
+}
+
+func init() {
+	sources.RegisterSource("docker", func() sources.Source {
+		return &DockerSource{}
+	})
+}
+
+// Init connects to the engine at DOCKER_HOST. Connections to TCP hosts
+// use TLS if DOCKER_TLS_VERIFY or DOCKER_CERT_PATH is set, with the CA
+// and client certificate read from DOCKER_CERT_PATH, ~/.docker by
+// default. The certificate of the engine is only verified if
+// DOCKER_TLS_VERIFY is set.
+func (d *DockerSource) Init() error {
+	dockerHost := os.Getenv("DOCKER_HOST")
+	if len(dockerHost) == 0 {
+		dockerHost = defaultDockerHost
+	}
+
+	u, err := url.Parse(dockerHost)
+	if err != nil {
+		return fmt.Errorf("Invalid DOCKER_HOST '%s': %v", dockerHost, err)
+	}
+
+	tlsConfig, err := tlsConfigFromEnv()
+	if err != nil {
+		return err
+	}
+
+	var transport *http.Transport
+	switch u.Scheme {
+	case "unix":
+		if tlsConfig != nil {
+			return fmt.Errorf("TLS is not supported for DOCKER_HOST '%s'", dockerHost)
+		}
+		socket := u.Path
+		transport = &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.Dial("unix", socket)
+			},
+		}
+		d.baseURL = "http://docker/" + apiVersion
+	case "tcp", "http", "https":
+		transport = &http.Transport{TLSClientConfig: tlsConfig}
+		scheme := "http"
+		if tlsConfig != nil || u.Scheme == "https" {
+			scheme = "https"
+		}
+		d.baseURL = scheme + "://" + u.Host + "/" + apiVersion
+	default:
+		return fmt.Errorf("Unsupported DOCKER_HOST '%s'", dockerHost)
+	}
+	d.client = &http.Client{Timeout: 30 * time.Second, Transport: transport}
+	d.eventClient = &http.Client{Transport: transport}
+
+	if d.hostIP = os.Getenv("DOCKER_HOST_IP"); net.ParseIP(d.hostIP) == nil {
+		return fmt.Errorf("DOCKER_HOST_IP is not set to a valid IP address")
+	}
+
+	var info dockerInfo
+	if err := d.get("/info", &info); err != nil {
+		return fmt.Errorf("Failed to get Docker engine info: %v", err)
+	}
+
+	if d.environmentName = os.Getenv("ENVIRONMENT_NAME"); len(d.environmentName) == 0 {
+		d.environmentName = info.Name
+	}
+
+	// the engine ID consists of colon separated groups
+	// that are not allowed in DNS labels
+	if d.environmentUUID = os.Getenv("ENVIRONMENT_UUID"); len(d.environmentUUID) == 0 {
+		d.environmentUUID = strings.ToLower(strings.Replace(info.ID, ":", "", -1))
+	}
+
+	go d.watchEvents()
+
+	logrus.Infof("Configured %s source at %s for environment %s", d.GetName(), dockerHost, d.environmentName)
+	return nil
+}
+
+// tlsConfigFromEnv returns the TLS configuration set up by the
+// environment of the Docker client, or nil if TLS is not enabled
+func tlsConfigFromEnv() (*tls.Config, error) {
+	verify := len(os.Getenv("DOCKER_TLS_VERIFY")) > 0
+	certPath := os.Getenv("DOCKER_CERT_PATH")
+	if !verify && len(certPath) == 0 {
+		return nil, nil
+	}
+	if len(certPath) == 0 {
+		certPath = filepath.Join(os.Getenv("HOME"), ".docker")
+	}
+
+	config := &tls.Config{InsecureSkipVerify: !verify}
+	if verify {
+		ca, err := ioutil.ReadFile(filepath.Join(certPath, "ca.pem"))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the Docker CA certificate: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("Failed to parse the Docker CA certificate %s", filepath.Join(certPath, "ca.pem"))
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certPath, "cert.pem"), filepath.Join(certPath, "key.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load the Docker client certificate: %v", err)
+	}
+	config.Certificates = []tls.Certificate{cert}
+
+	return config, nil
+}
+
+func (*DockerSource) GetName() string {
+	return "Docker"
+}
+
+func (d *DockerSource) HealthCheck() error {
+	var info dockerInfo
+	return d.get("/info", &info)
+}
+
+// GetVersion returns the number of container events streamed from
+// the engine that may have changed the records
+func (d *DockerSource) GetVersion() (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.eventsErr != nil {
+		return "", fmt.Errorf("Docker event stream failed: %v", d.eventsErr)
+	}
+	return strconv.FormatUint(d.changes, 10), nil
+}
+
+func (d *DockerSource) GetEnvironment() (string, string) {
+	return d.environmentName, d.environmentUUID
+}
+
+func (d *DockerSource) GetDnsRecords() (map[string]utils.MetadataDnsRecord, error) {
+	containers, err := d.listContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	dnsEntries := make(map[string]utils.MetadataDnsRecord)
+	for _, service := range groupServices(containers) {
+		values := d.getHealthyValues(service)
+		sources.AddServiceRecords(service.labels, service.name, service.stack, d.environmentName,
+			false, values, dnsEntries)
+	}
+	d.knownGood.Rotate()
+
+	records, err := sources.ReadStaticRecordsFile()
+	if err != nil {
+		return dnsEntries, err
+	}
+	sources.AddStaticRecords(records, dnsEntries)
+
+	return dnsEntries, nil
+}
+
+// groupServices groups the containers by compose project and service.
+// Containers started without compose are services of their own.
+func groupServices(containers []dockerContainer) []*dockerService {
+	var services []*dockerService
+	byKey := make(map[string]*dockerService)
+	for _, container := range containers {
+		name := container.Labels["com.docker.compose.service"]
+		if len(name) == 0 && len(container.Names) > 0 {
+			name = strings.TrimPrefix(container.Names[0], "/")
+		}
+		stack := container.Labels["com.docker.compose.project"]
+		if len(stack) == 0 {
+			stack = defaultStackName
+		}
+
+		key := sources.ServiceKey(stack, name)
+		service, ok := byKey[key]
+		if !ok {
+			service = &dockerService{name: name, stack: stack, labels: container.Labels}
+			byKey[key] = service
+			services = append(services, service)
+		}
+		service.containers = append(service.containers, container)
+	}
+	return services
+}
+
+// getHealthyValues returns the record values of the containers of the
+// service as selected by its health policy
+func (d *DockerSource) getHealthyValues(service *dockerService) []sources.RecordValue {
+	policy, err := sources.ParseHealthPolicy(service.labels)
+	if err != nil {
+		logrus.Errorf("Skipping service %s/%s: %v", service.stack, service.name, err)
+		return nil
+	}
+
+	ipSource, err := sources.IPSource(service.labels)
+	if err != nil {
+		logrus.Errorf("Skipping service %s/%s: %v", service.stack, service.name, err)
+		return nil
+	}
+	if ipSource == "vip" || strings.HasPrefix(ipSource, "host_label:") {
+		logrus.Errorf("Skipping service %s/%s: IP source '%s' is not supported by %s",
+			service.stack, service.name, ipSource, d.GetName())
+		return nil
+	}
+	requirePorts := sources.RequirePorts(service.labels, ipSource)
+
+	var values []sources.RecordValue
+	var healthy int
+	for _, container := range service.containers {
+		health := healthState(container)
+		if container.State != "running" || !policy.Included(health) {
+			continue
+		}
+
+		bindings := publishedPorts(container)
+		if len(bindings) == 0 && requirePorts {
+			continue
+		}
+
+		for _, ip := range d.containerIPs(ipSource, container, bindings) {
+			value, ok := sources.AddressValue(ip)
+			if !ok {
+				logrus.Errorf("Skipping container %s: Invalid IP address %s", container.ID, ip)
+				continue
+			}
+			values = append(values, value)
+		}
+
+		if sources.Healthy(health) {
+			healthy++
+		}
+	}
+
+	return d.knownGood.Filter(sources.ServiceKey(service.stack, service.name), policy, values, healthy)
+}
+
+// containerIPs returns the addresses published for the container
+// as selected by the IP source of its service
+func (d *DockerSource) containerIPs(ipSource string, container dockerContainer, bindings []dockerPort) []string {
+	switch ipSource {
+	case "agent_ip":
+		return []string{d.hostIP}
+	case "container_ip":
+		// use the first network in name order to be deterministic
+		var names []string
+		for name := range container.NetworkSettings.Networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if ip := container.NetworkSettings.Networks[name].IPAddress; len(ip) > 0 {
+				return []string{ip}
+			}
+		}
+		logrus.Debugf("Container %s has no IP address", container.ID)
+		return nil
+	case "ports":
+		var ips []string
+		for _, binding := range bindings {
+			if ip, ok := bindingIP(binding); ok {
+				ips = append(ips, ip)
+			}
+		}
+		if len(ips) == 0 {
+			ips = append(ips, d.hostIP)
+		}
+		return ips
+	}
+
+	if len(bindings) > 0 {
+		if ip, ok := bindingIP(bindings[0]); ok {
+			return []string{ip}
+		}
+	}
+	return []string{d.hostIP}
+}
+
+// publishedPorts returns the ports of the container bound on the host
+func publishedPorts(container dockerContainer) []dockerPort {
+	var bindings []dockerPort
+	for _, port := range container.Ports {
+		if port.PublicPort > 0 {
+			bindings = append(bindings, port)
+		}
+	}
+	return bindings
+}
+
+// bindingIP returns the IP a port is bound to unless it is bound to all addresses
+func bindingIP(port dockerPort) (string, bool) {
+	switch port.IP {
+	case "", "0.0.0.0", "::":
+		return "", false
+	}
+	return port.IP, true
+}
+
+// healthState maps the health shown in the status of
+// a container to the health states used by Rancher
+func healthState(container dockerContainer) string {
+	switch {
+	case strings.Contains(container.Status, "(healthy)"):
+		return "healthy"
+	case strings.Contains(container.Status, "(unhealthy)"):
+		return "unhealthy"
+	case strings.Contains(container.Status, "(health: starting)"):
+		return "initializing"
+	}
+	return ""
+}
+
+func (d *DockerSource) listContainers() ([]dockerContainer, error) {
+	var containers []dockerContainer
+	if err := d.get("/containers/json", &containers); err != nil {
+		return nil, fmt.Errorf("Failed to list containers: %v", err)
+	}
+	return containers, nil
+}
+
+func (d *DockerSource) get(path string, v interface{}) error {
+	resp, err := d.client.Get(d.baseURL + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Docker API returned %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,87 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// time to wait before reconnecting to the event stream
+const eventsRetryInterval = 5 * time.Second
+
+// containerActions are the actions of container events that
+// may change the records. Health status events have the
+// new status appended to the action.
+var containerActions = map[string]struct{}{
+	"start":         {},
+	"stop":          {},
+	"die":           {},
+	"pause":         {},
+	"unpause":       {},
+	"health_status": {},
+}
+
+type dockerEvent struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID string `json:"ID"`
+	} `json:"Actor"`
+}
+
+// watchEvents counts the container events of the engine. The stream
+// is reconnected when it fails, and as events may have been missed
+// in the meantime every connection counts as a change as well.
+func (d *DockerSource) watchEvents() {
+	for {
+		err := d.streamEvents()
+		logrus.Errorf("Docker event stream failed: %v", err)
+
+		d.mu.Lock()
+		d.eventsErr = err
+		d.mu.Unlock()
+		time.Sleep(eventsRetryInterval)
+	}
+}
+
+// streamEvents reads container events until the stream fails
+func (d *DockerSource) streamEvents() error {
+	filters := url.QueryEscape(`{"type":["container"]}`)
+	resp, err := d.eventClient.Get(d.baseURL + "/events?filters=" + filters)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Docker API returned %s", resp.Status)
+	}
+
+	d.mu.Lock()
+	d.changes++
+	d.eventsErr = nil
+	d.mu.Unlock()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event dockerEvent
+		if err := decoder.Decode(&event); err != nil {
+			return err
+		}
+
+		action := strings.SplitN(event.Action, ":", 2)[0]
+		if _, ok := containerActions[action]; !ok {
+			continue
+		}
+		logrus.Debugf("Container %s: %s", event.Actor.ID, event.Action)
+
+		d.mu.Lock()
+		d.changes++
+		d.mu.Unlock()
+	}
+}
@@ -0,0 +1,35 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStreamEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/"+apiVersion+"/events" {
+			http.NotFound(w, req)
+			return
+		}
+		for _, action := range []string{"create", "start", "health_status: healthy", "exec_start: sh", "die"} {
+			fmt.Fprintf(w, `{"Type":"container","Action":%q,"Actor":{"ID":"abc"}}`+"\n", action)
+		}
+	}))
+	defer server.Close()
+
+	d := &DockerSource{
+		eventClient: &http.Client{},
+		baseURL:     server.URL + "/" + apiVersion,
+	}
+	if err := d.streamEvents(); err != io.EOF {
+		t.Errorf("got error %v at the end of the stream, want EOF", err)
+	}
+
+	// the connection and the start, health status and die events
+	if version, err := d.GetVersion(); err != nil || version != "4" {
+		t.Errorf("got version %q (%v), want 4", version, err)
+	}
+}
@@ -0,0 +1,128 @@
+package sources
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// HealthPolicy selects the containers of a service that are published
+type HealthPolicy struct {
+	// States are the health states included in addition to healthy containers
+	States map[string]bool
+	// MinHealthy is the minimum number of healthy endpoints
+	// required to publish the service
+	MinHealthy int
+	// FailOpen keeps the last known-good values of the service
+	// if it does not have the required healthy endpoints
+	FailOpen bool
+}
+
+// ParseHealthPolicy returns the health policy set by the service labels
+func ParseHealthPolicy(labels map[string]string) (HealthPolicy, error) {
+	policy := HealthPolicy{States: make(map[string]bool)}
+
+	// Check for Service Label: io.rancher.service.external_dns_health_states
+	// Comma separated health states to include in addition to healthy containers,
+	// accepts 'initializing', 'degraded' and 'unhealthy'
+	for _, state := range SplitLabel(labels["io.rancher.service.external_dns_health_states"]) {
+		switch state {
+		case "initializing":
+			policy.States["initializing"] = true
+			policy.States["reinitializing"] = true
+		case "degraded":
+			policy.States["degraded"] = true
+			policy.States["updating-degraded"] = true
+		case "unhealthy":
+			policy.States["unhealthy"] = true
+			policy.States["updating-unhealthy"] = true
+		default:
+			return policy, fmt.Errorf("Invalid health state '%s'", state)
+		}
+	}
+
+	// Check for Service Label: io.rancher.service.external_dns_min_healthy
+	// Minimum number of healthy endpoints required to publish the service, defaults to 0
+	if label, ok := labels["io.rancher.service.external_dns_min_healthy"]; ok {
+		var err error
+		if policy.MinHealthy, err = strconv.Atoi(strings.TrimSpace(label)); err != nil || policy.MinHealthy < 0 {
+			return policy, fmt.Errorf("Invalid minimum of healthy endpoints '%s'", label)
+		}
+	}
+
+	// Check for Service Label: io.rancher.service.external_dns_fail_open
+	// Accepts 'true' or 'false' (default)
+	if label, ok := labels["io.rancher.service.external_dns_fail_open"]; ok {
+		var err error
+		if policy.FailOpen, err = strconv.ParseBool(strings.TrimSpace(label)); err != nil {
+			return policy, fmt.Errorf("Invalid value '%s' for failing open", label)
+		}
+	}
+
+	return policy, nil
+}
+
+// Healthy returns true if the health state is healthy. Containers
+// without health check have an empty health state.
+func Healthy(healthState string) bool {
+	switch healthState {
+	case "healthy":
+	case "updating-healthy":
+	case "":
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Included returns true if containers in the health state are published
+func (p HealthPolicy) Included(healthState string) bool {
+	return Healthy(healthState) || p.States[healthState]
+}
+
+// KnownGood keeps the last known-good values of the services that
+// fail open. Values of services that are gone are dropped by Rotate.
+type KnownGood struct {
+	last    map[string][]RecordValue
+	current map[string][]RecordValue
+}
+
+// Filter returns the values of the service if it has the minimum
+// number of healthy endpoints. Services that fail open get their last
+// known-good values otherwise.
+func (k *KnownGood) Filter(key string, policy HealthPolicy, values []RecordValue, healthy int) []RecordValue {
+	if k.current == nil {
+		k.current = make(map[string][]RecordValue)
+	}
+
+	if len(values) > 0 && healthy >= policy.MinHealthy {
+		if policy.FailOpen {
+			k.current[key] = values
+		}
+		return values
+	}
+
+	if policy.FailOpen {
+		if last, ok := k.last[key]; ok {
+			logrus.Warnf("Service %s has %d healthy endpoints: keeping last known-good values %v",
+				key, healthy, last)
+			k.current[key] = last
+			return last
+		}
+	}
+
+	if len(values) > 0 {
+		logrus.Infof("Not publishing service %s: %d healthy endpoints, %d required",
+			key, healthy, policy.MinHealthy)
+	}
+	return nil
+}
+
+// Rotate finishes an update cycle
+func (k *KnownGood) Rotate() {
+	k.last = k.current
+	k.current = nil
+}
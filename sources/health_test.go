@@ -0,0 +1,33 @@
+package sources
+
+import "testing"
+
+func TestParseHealthPolicyFailOpen(t *testing.T) {
+	tests := []struct {
+		label    string
+		failOpen bool
+		err      bool
+	}{
+		{label: "true", failOpen: true},
+		{label: " TRUE ", failOpen: true},
+		{label: "1", failOpen: true},
+		{label: "false"},
+		{label: "yes", err: true},
+		{label: "", err: true},
+	}
+
+	for _, test := range tests {
+		policy, err := ParseHealthPolicy(map[string]string{"io.rancher.service.external_dns_fail_open": test.label})
+		if (err != nil) != test.err {
+			t.Errorf("%q: got error %v, want error %v", test.label, err, test.err)
+			continue
+		}
+		if err == nil && policy.FailOpen != test.failOpen {
+			t.Errorf("%q: got fail open %v, want %v", test.label, policy.FailOpen, test.failOpen)
+		}
+	}
+
+	if policy, err := ParseHealthPolicy(map[string]string{}); err != nil || policy.FailOpen {
+		t.Errorf("got policy %+v (%v) without label, want failing closed", policy, err)
+	}
+}
@@ -0,0 +1,373 @@
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/external-dns/sources"
+	"github.com/rancher/external-dns/utils"
+)
+
+const (
+	defaultAPIURL = "https://kubernetes.default.svc"
+	tokenFile     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	caFile        = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+
+	servicesPath  = "/api/v1/services"
+	nodesPath     = "/api/v1/nodes"
+	ingressesPath = "/apis/networking.k8s.io/v1/ingresses"
+)
+
+// KubernetesSource publishes LoadBalancer and NodePort services under
+// the name template and ingress hosts under their own name. Services
+// and ingresses are configured with annotations using the keys of the
+// Rancher service labels. Services are only published if annotated
+// with io.rancher.service.external_dns 'auto' or 'always'.
+type KubernetesSource struct {
+	client *http.Client
+	// watchClient follows watches without a timeout
+	watchClient     *http.Client
+	apiURL          string
+	token           string
+	environmentName string
+	environmentUUID string
+
+	mu       sync.Mutex
+	watchers []*watcher
+	// changes counts the changes of the watched objects
+	changes uint64
+}
+
+type objectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	UID             string            `json:"uid"`
+	Annotations     map[string]string `json:"annotations"`
+	ResourceVersion string            `json:"resourceVersion"`
+}
+
+type listMeta struct {
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+type loadBalancerStatus struct {
+	Ingress []struct {
+		IP       string `json:"ip"`
+		Hostname string `json:"hostname"`
+	} `json:"ingress"`
+}
+
+type service struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		Type string `json:"type"`
+	} `json:"spec"`
+	Status struct {
+		LoadBalancer loadBalancerStatus `json:"loadBalancer"`
+	} `json:"status"`
+}
+
+type ingress struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		Rules []struct {
+			Host string `json:"host"`
+		} `json:"rules"`
+	} `json:"spec"`
+	Status struct {
+		LoadBalancer loadBalancerStatus `json:"loadBalancer"`
+	} `json:"status"`
+}
+
+type node struct {
+	Metadata objectMeta `json:"metadata"`
+	Status   struct {
+		Addresses []struct {
+			Type    string `json:"type"`
+			Address string `json:"address"`
+		} `json:"addresses"`
+	} `json:"status"`
+}
+
+type serviceList struct {
+	Metadata listMeta  `json:"metadata"`
+	Items    []service `json:"items"`
+}
+
+type ingressList struct {
+	Metadata listMeta  `json:"metadata"`
+	Items    []ingress `json:"items"`
+}
+
+type nodeList struct {
+	Metadata listMeta `json:"metadata"`
+	Items    []node   `json:"items"`
+}
+
+func init() {
+	sources.RegisterSource("kubernetes", func() sources.Source {
+		return &KubernetesSource{}
+	})
+}
+
+func (k *KubernetesSource) Init() error {
+	if k.apiURL = os.Getenv("KUBERNETES_API_URL"); len(k.apiURL) == 0 {
+		k.apiURL = defaultAPIURL
+	}
+	k.apiURL = strings.TrimSuffix(k.apiURL, "/")
+
+	if k.token = os.Getenv("KUBERNETES_TOKEN"); len(k.token) == 0 {
+		if data, err := ioutil.ReadFile(tokenFile); err == nil {
+			k.token = strings.TrimSpace(string(data))
+		}
+	}
+
+	tlsConfig := &tls.Config{}
+	if data, err := ioutil.ReadFile(caFile); err == nil {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(data)
+		tlsConfig.RootCAs = pool
+	}
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+	k.client = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+	}
+	k.watchClient = &http.Client{Transport: transport}
+
+	if k.environmentName = os.Getenv("ENVIRONMENT_NAME"); len(k.environmentName) == 0 {
+		k.environmentName = "kubernetes"
+	}
+
+	// the records are owned by the cluster unless an environment
+	// is configured, e.g. to take over the records of a Rancher environment
+	if k.environmentUUID = os.Getenv("ENVIRONMENT_UUID"); len(k.environmentUUID) == 0 {
+		var ns struct {
+			Metadata objectMeta `json:"metadata"`
+		}
+		if err := k.get("/api/v1/namespaces/kube-system", &ns); err != nil {
+			return fmt.Errorf("Failed to get cluster UID: %v", err)
+		}
+		k.environmentUUID = ns.Metadata.UID
+	}
+
+	k.watchers = newWatchers()
+	for _, w := range k.watchers {
+		go k.watch(w)
+	}
+
+	logrus.Infof("Configured %s source at %s for environment %s", k.GetName(), k.apiURL, k.environmentName)
+	return nil
+}
+
+func (*KubernetesSource) GetName() string {
+	return "Kubernetes"
+}
+
+func (k *KubernetesSource) HealthCheck() error {
+	var list nodeList
+	return k.get(nodesPath+"?limit=1", &list)
+}
+
+// GetVersion returns the number of changes of the watched services,
+// ingresses and nodes that may have changed the records
+func (k *KubernetesSource) GetVersion() (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, w := range k.watchers {
+		if w.err != nil {
+			return "", fmt.Errorf("Failed to watch %s: %v", w.path, w.err)
+		}
+	}
+	return strconv.FormatUint(k.changes, 10), nil
+}
+
+func (k *KubernetesSource) GetEnvironment() (string, string) {
+	return k.environmentName, k.environmentUUID
+}
+
+func (k *KubernetesSource) GetDnsRecords() (map[string]utils.MetadataDnsRecord, error) {
+	dnsEntries := make(map[string]utils.MetadataDnsRecord)
+
+	if err := k.getServicesDnsRecords(dnsEntries); err != nil {
+		return dnsEntries, err
+	}
+
+	if err := k.getIngressesDnsRecords(dnsEntries); err != nil {
+		return dnsEntries, err
+	}
+
+	records, err := sources.ReadStaticRecordsFile()
+	if err != nil {
+		return dnsEntries, err
+	}
+	sources.AddStaticRecords(records, dnsEntries)
+
+	return dnsEntries, nil
+}
+
+// getServicesDnsRecords adds the load balancer addresses of LoadBalancer
+// services and the node addresses of NodePort services
+func (k *KubernetesSource) getServicesDnsRecords(dnsEntries map[string]utils.MetadataDnsRecord) error {
+	var services serviceList
+	if err := k.get(servicesPath, &services); err != nil {
+		return fmt.Errorf("Failed to list services: %v", err)
+	}
+
+	var nodeValues []sources.RecordValue
+	for _, svc := range services.Items {
+		if svc.Spec.Type == "NodePort" && serviceOptedIn(svc.Metadata.Annotations) {
+			var err error
+			if nodeValues, err = k.getNodeValues(); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	for _, svc := range services.Items {
+		if !serviceOptedIn(svc.Metadata.Annotations) {
+			continue
+		}
+
+		var values []sources.RecordValue
+		switch svc.Spec.Type {
+		case "LoadBalancer":
+			values = loadBalancerValues(svc.Status.LoadBalancer)
+		case "NodePort":
+			values = nodeValues
+		default:
+			continue
+		}
+
+		sources.AddServiceRecords(svc.Metadata.Annotations, svc.Metadata.Name, svc.Metadata.Namespace,
+			k.environmentName, svc.Metadata.Namespace == "kube-system", values, dnsEntries)
+	}
+
+	return nil
+}
+
+// serviceOptedIn returns true if the service is annotated to be
+// published. LoadBalancer and NodePort services are created for many
+// purposes, so unlike ingresses they are not published by default.
+func serviceOptedIn(annotations map[string]string) bool {
+	value, ok := annotations["io.rancher.service.external_dns"]
+	return ok && value != "never"
+}
+
+// getIngressesDnsRecords adds the load balancer addresses of
+// the ingresses under the hosts of their rules
+func (k *KubernetesSource) getIngressesDnsRecords(dnsEntries map[string]utils.MetadataDnsRecord) error {
+	var ingresses ingressList
+	if err := k.get(ingressesPath, &ingresses); err != nil {
+		return fmt.Errorf("Failed to list ingresses: %v", err)
+	}
+
+	for _, ing := range ingresses.Items {
+		var hosts []string
+		for _, rule := range ing.Spec.Rules {
+			if len(rule.Host) == 0 || strings.HasPrefix(rule.Host, "*") {
+				continue
+			}
+			hosts = append(hosts, rule.Host)
+		}
+
+		sources.AddHostRecords(ing.Metadata.Annotations, hosts, ing.Metadata.Name, ing.Metadata.Namespace,
+			ing.Metadata.Namespace == "kube-system", loadBalancerValues(ing.Status.LoadBalancer), dnsEntries)
+	}
+
+	return nil
+}
+
+// getNodeValues returns the external addresses of the nodes,
+// falling back to their internal addresses
+func (k *KubernetesSource) getNodeValues() ([]sources.RecordValue, error) {
+	var nodes nodeList
+	if err := k.get(nodesPath, &nodes); err != nil {
+		return nil, fmt.Errorf("Failed to list nodes: %v", err)
+	}
+
+	var values []sources.RecordValue
+	for _, n := range nodes.Items {
+		var external, internal []string
+		for _, addr := range n.Status.Addresses {
+			switch addr.Type {
+			case "ExternalIP":
+				external = append(external, addr.Address)
+			case "InternalIP":
+				internal = append(internal, addr.Address)
+			}
+		}
+		if len(external) == 0 {
+			external = internal
+		}
+		for _, ip := range external {
+			if value, ok := sources.AddressValue(ip); ok {
+				values = append(values, value)
+			}
+		}
+	}
+	return values, nil
+}
+
+// loadBalancerValues returns the IPs of the load balancer as A and
+// AAAA record values, or its hostname as CNAME value
+func loadBalancerValues(status loadBalancerStatus) []sources.RecordValue {
+	var values []sources.RecordValue
+	var hostname string
+	for _, ingress := range status.Ingress {
+		if len(ingress.IP) > 0 {
+			if value, ok := sources.AddressValue(ingress.IP); ok {
+				values = append(values, value)
+			}
+		} else if len(ingress.Hostname) > 0 && len(hostname) == 0 {
+			hostname = ingress.Hostname
+		}
+	}
+
+	if len(values) == 0 && len(hostname) > 0 {
+		values = append(values, sources.RecordValue{Type: "CNAME", Value: utils.Fqdn(strings.ToLower(hostname))})
+	}
+	return values
+}
+
+func (k *KubernetesSource) newRequest(path string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", k.apiURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(k.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+	return req, nil
+}
+
+func (k *KubernetesSource) get(path string, v interface{}) error {
+	req, err := k.newRequest(path)
+	if err != nil {
+		return err
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Kubernetes API returned %s for %s", resp.Status, path)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
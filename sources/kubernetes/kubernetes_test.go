@@ -0,0 +1,63 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/rancher/external-dns/config"
+	"github.com/rancher/external-dns/utils"
+)
+
+func TestGetDnsRecords(t *testing.T) {
+	config.NameTemplate = "%{{service_name}}.%{{stack_name}}"
+	config.RootDomainName = "example.com."
+	config.RootDomainNames = []string{"example.com."}
+	config.TTL = 300
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case servicesPath:
+			fmt.Fprint(w, `{"items":[`+
+				// opted in
+				`{"metadata":{"name":"web","namespace":"shop","annotations":{"io.rancher.service.external_dns":"auto"}},`+
+				`"spec":{"type":"LoadBalancer"},"status":{"loadBalancer":{"ingress":[{"ip":"10.0.0.1"}]}}},`+
+				// not annotated
+				`{"metadata":{"name":"db","namespace":"shop"},`+
+				`"spec":{"type":"LoadBalancer"},"status":{"loadBalancer":{"ingress":[{"ip":"10.0.0.2"}]}}},`+
+				`{"metadata":{"name":"admin","namespace":"shop","annotations":{"io.rancher.service.external_dns":"never"}},`+
+				`"spec":{"type":"NodePort"}}]}`)
+		case ingressesPath:
+			fmt.Fprint(w, `{"items":[`+
+				`{"metadata":{"name":"shop","namespace":"shop","annotations":{`+
+				`"io.rancher.service.external_dns_visibility":"public","io.rancher.service.external_dns_ttl":"60"}},`+
+				`"spec":{"rules":[{"host":"shop.example.com"},{"host":"*.example.com"}]},`+
+				`"status":{"loadBalancer":{"ingress":[{"hostname":"LB.example.net"}]}}}]}`)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	k := &KubernetesSource{client: &http.Client{}, apiURL: server.URL}
+	dnsEntries, err := k.GetDnsRecords()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var records []string
+	for _, rec := range dnsEntries {
+		r := rec.DnsRecord
+		records = append(records, fmt.Sprintf("%s %s %d %s %v", r.Fqdn, r.Type, r.TTL, r.Visibility, r.Records))
+	}
+	sort.Strings(records)
+	want := []string{
+		"shop.example.com. CNAME 60 " + utils.VisibilityPublic + " [lb.example.net.]",
+		"web.shop.example.com. A 300  [10.0.0.1]",
+	}
+	if fmt.Sprint(records) != fmt.Sprint(want) {
+		t.Errorf("got records %q, want %q", records, want)
+	}
+}
@@ -0,0 +1,232 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// time to wait before retrying a failed watch
+	watchRetryInterval = 5 * time.Second
+	// seconds after which the API server ends a watch,
+	// which is then resumed at the last resource version
+	watchTimeoutSeconds = "300"
+)
+
+// watcher follows the objects of a kind. Only changes of the
+// fields that records are made from are counted as changes.
+type watcher struct {
+	path string
+	// fingerprint returns the metadata of the object in JSON and the
+	// fields records are made from, which are empty if it makes none
+	fingerprint func(data []byte) (objectMeta, string, error)
+
+	// fingerprints of the objects making records by namespace and name
+	fingerprints    map[string]string
+	resourceVersion string
+	// err is the error the watch last failed with
+	err error
+}
+
+// watchEvent is an event of the watch API
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// watchStatus is the object of an ERROR event
+type watchStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func newWatchers() []*watcher {
+	return []*watcher{
+		{path: servicesPath, fingerprint: serviceFingerprint},
+		{path: ingressesPath, fingerprint: ingressFingerprint},
+		{path: nodesPath, fingerprint: nodeFingerprint},
+	}
+}
+
+func serviceFingerprint(data []byte) (objectMeta, string, error) {
+	var svc service
+	if err := json.Unmarshal(data, &svc); err != nil {
+		return objectMeta{}, "", err
+	}
+	if (svc.Spec.Type != "LoadBalancer" && svc.Spec.Type != "NodePort") || !serviceOptedIn(svc.Metadata.Annotations) {
+		return svc.Metadata, "", nil
+	}
+	fingerprint, err := json.Marshal([]interface{}{svc.Spec.Type, svc.Metadata.Annotations, svc.Status.LoadBalancer})
+	return svc.Metadata, string(fingerprint), err
+}
+
+func ingressFingerprint(data []byte) (objectMeta, string, error) {
+	var ing ingress
+	if err := json.Unmarshal(data, &ing); err != nil {
+		return objectMeta{}, "", err
+	}
+	fingerprint, err := json.Marshal([]interface{}{ing.Metadata.Annotations, ing.Spec.Rules, ing.Status.LoadBalancer})
+	return ing.Metadata, string(fingerprint), err
+}
+
+func nodeFingerprint(data []byte) (objectMeta, string, error) {
+	var n node
+	if err := json.Unmarshal(data, &n); err != nil {
+		return objectMeta{}, "", err
+	}
+	fingerprint, err := json.Marshal(n.Status.Addresses)
+	return n.Metadata, string(fingerprint), err
+}
+
+// watch follows the objects of the watcher. The objects are listed
+// first and whenever the watch cannot be resumed where it ended.
+func (k *KubernetesSource) watch(w *watcher) {
+	for {
+		err := k.runWatcher(w)
+		k.mu.Lock()
+		w.err = err
+		k.mu.Unlock()
+		if err != nil {
+			logrus.Errorf("Failed to watch %s: %v", w.path, err)
+			time.Sleep(watchRetryInterval)
+		}
+	}
+}
+
+// runWatcher lists the objects unless a resource version to resume
+// from is known and watches the changes until the watch ends
+func (k *KubernetesSource) runWatcher(w *watcher) error {
+	if len(w.resourceVersion) == 0 {
+		if err := k.listObjects(w); err != nil {
+			return err
+		}
+	}
+
+	k.mu.Lock()
+	w.err = nil
+	k.mu.Unlock()
+	return k.watchObjects(w)
+}
+
+// listObjects replaces the fingerprints of the objects
+// and counts a change if any of them changed
+func (k *KubernetesSource) listObjects(w *watcher) error {
+	var list struct {
+		Metadata listMeta          `json:"metadata"`
+		Items    []json.RawMessage `json:"items"`
+	}
+	if err := k.get(w.path, &list); err != nil {
+		return err
+	}
+
+	fingerprints := make(map[string]string, len(list.Items))
+	for _, item := range list.Items {
+		meta, fingerprint, err := w.fingerprint(item)
+		if err != nil {
+			return err
+		}
+		if len(fingerprint) > 0 {
+			fingerprints[meta.Namespace+"/"+meta.Name] = fingerprint
+		}
+	}
+
+	changed := w.fingerprints == nil || len(fingerprints) != len(w.fingerprints)
+	for key, fingerprint := range fingerprints {
+		if w.fingerprints[key] != fingerprint {
+			changed = true
+		}
+	}
+	w.fingerprints = fingerprints
+	w.resourceVersion = list.Metadata.ResourceVersion
+	if changed {
+		k.countChange()
+	}
+	return nil
+}
+
+// watchObjects follows the changes of the objects from the resource
+// version of the watcher. The resource version is cleared if it has
+// expired so that the objects are listed again.
+func (k *KubernetesSource) watchObjects(w *watcher) error {
+	query := url.Values{}
+	query.Set("watch", "1")
+	query.Set("resourceVersion", w.resourceVersion)
+	query.Set("allowWatchBookmarks", "true")
+	query.Set("timeoutSeconds", watchTimeoutSeconds)
+	req, err := k.newRequest(w.path + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+
+	resp, err := k.watchClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		w.resourceVersion = ""
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Kubernetes API returned %s for %s", resp.Status, w.path)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event watchEvent
+		if err := decoder.Decode(&event); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if event.Type == "ERROR" {
+			var status watchStatus
+			if err := json.Unmarshal(event.Object, &status); err != nil {
+				return err
+			}
+			if status.Code == http.StatusGone {
+				w.resourceVersion = ""
+				return nil
+			}
+			return fmt.Errorf("Watch failed: %s", status.Message)
+		}
+
+		meta, fingerprint, err := w.fingerprint(event.Object)
+		if err != nil {
+			return err
+		}
+		w.resourceVersion = meta.ResourceVersion
+
+		key := meta.Namespace + "/" + meta.Name
+		switch event.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			if event.Type == "DELETED" {
+				fingerprint = ""
+			}
+			if w.fingerprints[key] == fingerprint {
+				continue
+			}
+			if len(fingerprint) > 0 {
+				w.fingerprints[key] = fingerprint
+			} else {
+				delete(w.fingerprints, key)
+			}
+			logrus.Debugf("Object %s of %s changed", key, w.path)
+			k.countChange()
+		}
+	}
+}
+
+func (k *KubernetesSource) countChange() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.changes++
+}
@@ -0,0 +1,88 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testService(name, serviceType, resourceVersion, ip string) string {
+	return fmt.Sprintf(`{"metadata":{"name":%q,"namespace":"default","resourceVersion":%q,`+
+		`"annotations":{"io.rancher.service.external_dns":"auto"}},`+
+		`"spec":{"type":%q},"status":{"loadBalancer":{"ingress":[{"ip":%q}]}}}`,
+		name, resourceVersion, serviceType, ip)
+}
+
+func TestWatchServices(t *testing.T) {
+	var watchQueries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != servicesPath {
+			http.NotFound(w, req)
+			return
+		}
+		if req.URL.Query().Get("watch") == "" {
+			fmt.Fprintf(w, `{"metadata":{"resourceVersion":"10"},"items":[%s,%s]}`,
+				testService("web", "LoadBalancer", "5", "10.0.0.1"),
+				testService("internal", "ClusterIP", "6", ""))
+			return
+		}
+
+		watchQueries = append(watchQueries, req.URL.RawQuery)
+		if req.URL.Query().Get("resourceVersion") == "expired" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		for _, event := range []struct{ eventType, object string }{
+			// changes of services that make no records
+			{"MODIFIED", testService("internal", "ClusterIP", "11", "")},
+			{"ADDED", testService("other", "ClusterIP", "12", "")},
+			// changes of fields that records are not made from
+			{"MODIFIED", testService("web", "LoadBalancer", "13", "10.0.0.1")},
+			{"BOOKMARK", `{"metadata":{"resourceVersion":"14"}}`},
+			// changes of records
+			{"MODIFIED", testService("web", "LoadBalancer", "15", "10.0.0.2")},
+			{"MODIFIED", testService("internal", "LoadBalancer", "16", "10.0.0.3")},
+			{"DELETED", testService("web", "LoadBalancer", "17", "10.0.0.2")},
+		} {
+			fmt.Fprintf(w, `{"type":%q,"object":%s}`+"\n", event.eventType, event.object)
+		}
+	}))
+	defer server.Close()
+
+	k := &KubernetesSource{
+		client:      &http.Client{},
+		watchClient: &http.Client{},
+		apiURL:      server.URL,
+	}
+	w := &watcher{path: servicesPath, fingerprint: serviceFingerprint}
+	k.watchers = []*watcher{w}
+
+	if err := k.runWatcher(w); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(watchQueries) != 1 || watchQueries[0] != "allowWatchBookmarks=true&resourceVersion=10&timeoutSeconds=300&watch=1" {
+		t.Errorf("got watches %v", watchQueries)
+	}
+	if w.resourceVersion != "17" {
+		t.Errorf("watch ended at resource version %s, want 17", w.resourceVersion)
+	}
+
+	// the listing and the three changes of records
+	if version, err := k.GetVersion(); err != nil || version != "4" {
+		t.Errorf("got version %q (%v), want 4", version, err)
+	}
+
+	// an expired resource version makes the services listed again,
+	// which only counts as change if they differ from the watched ones
+	w.resourceVersion = "expired"
+	if err := k.runWatcher(w); err != nil || w.resourceVersion != "" {
+		t.Fatalf("got resource version %q (%v) after expiry", w.resourceVersion, err)
+	}
+	if err := k.runWatcher(w); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version, err := k.GetVersion(); err != nil || version != "8" {
+		t.Errorf("got version %q (%v), want 8", version, err)
+	}
+}
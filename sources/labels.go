@@ -0,0 +1,537 @@
+package sources
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/external-dns/config"
+	"github.com/rancher/external-dns/utils"
+)
+
+// RecordValue is a value of a DNS record published for a service
+type RecordValue struct {
+	Type  string
+	Value string
+}
+
+// Entry holds the name and publishing settings of a service
+type Entry struct {
+	Fqdn      string
+	Service   string
+	Stack     string
+	TTL       int
+	Providers []string
+	// RoutingPolicy of the records, nil for simple records
+	RoutingPolicy *utils.RoutingPolicy
+	// Visibility restricts the records to the public or private zone
+	Visibility string
+	// HealthCheck of the endpoints of address records, if any
+	HealthCheck *utils.HealthCheck
+	// Proxied is set if the records are served through a proxy
+	Proxied bool
+}
+
+// ServiceKey returns the key identifying a service within the source
+func ServiceKey(stackName, serviceName string) string {
+	return stackName + "/" + serviceName
+}
+
+// ServiceDisabled returns true if the labels disable the service
+func ServiceDisabled(labels map[string]string) bool {
+	// Check for Service Label: io.rancher.service.external_dns
+	// Accepts 'always', 'auto' (default), or 'never'
+	return labels["io.rancher.service.external_dns"] == "never"
+}
+
+// NewEntry returns the name and publishing settings of the service
+// from its labels. It returns false if the service is not published.
+func NewEntry(labels map[string]string, serviceName, stackName, environmentName string) (Entry, bool) {
+	nameTemplate, ok := labels["io.rancher.service.external_dns_name_template"]
+	if !ok {
+		nameTemplate = config.NameTemplate
+	}
+
+	// Check for Service Label: io.rancher.service.external_dns_root_domain
+	// Selects the domain the name is created in, defaults to the first ROOT_DOMAIN
+	rootDomainName, ok := labels["io.rancher.service.external_dns_root_domain"]
+	if !ok {
+		rootDomainName = config.RootDomainName
+	}
+
+	fqdn := utils.FqdnFromTemplate(nameTemplate, serviceName, stackName,
+		environmentName, utils.Fqdn(rootDomainName))
+
+	if !FqdnSelected(fqdn) {
+		logrus.Debugf("FQDN %s of service %s/%s is filtered", fqdn, stackName, serviceName)
+		return Entry{}, false
+	}
+
+	return newHostEntry(labels, fqdn, serviceName, stackName)
+}
+
+// newHostEntry returns the publishing settings of the service from
+// its labels for the given FQDN. It returns false if they are invalid.
+func newHostEntry(labels map[string]string, fqdn, serviceName, stackName string) (Entry, bool) {
+	// Check for Service Label: io.rancher.service.external_dns_ttl
+	// Sets the TTL of the record in seconds, defaults to TTL
+	ttl := config.TTL
+	if label, ok := labels["io.rancher.service.external_dns_ttl"]; ok {
+		var err error
+		if ttl, err = ParseTTL(label); err != nil {
+			logrus.Errorf("Skipping service %s/%s: %v", stackName, serviceName, err)
+			return Entry{}, false
+		}
+	}
+
+	routingPolicy, err := ParseRoutingPolicy(labels)
+	if err != nil {
+		logrus.Errorf("Skipping service %s/%s: %v", stackName, serviceName, err)
+		return Entry{}, false
+	}
+
+	// Check for Service Label: io.rancher.service.external_dns_visibility
+	// Restricts the records to the 'public' or 'private' zone of a
+	// split-horizon domain, defaults to both
+	visibility := strings.ToLower(strings.TrimSpace(labels["io.rancher.service.external_dns_visibility"]))
+	if visibility != "" && visibility != utils.VisibilityPublic && visibility != utils.VisibilityPrivate {
+		logrus.Errorf("Skipping service %s/%s: invalid visibility '%s'", stackName, serviceName, visibility)
+		return Entry{}, false
+	}
+
+	// Check for Service Label: io.rancher.service.external_dns_health_check
+	// Publishes each address only while its endpoint passes the check,
+	// given as '<protocol>:<port>[<path>]', e.g. 'HTTP:8080/healthz'
+	healthCheck, err := ParseHealthCheck(labels["io.rancher.service.external_dns_health_check"])
+	if err != nil {
+		logrus.Errorf("Skipping service %s/%s: %v", stackName, serviceName, err)
+		return Entry{}, false
+	}
+	if healthCheck != nil && routingPolicy != nil {
+		logrus.Errorf("Skipping service %s/%s: a health check cannot be combined with a routing policy", stackName, serviceName)
+		return Entry{}, false
+	}
+
+	// Check for Service Label: io.rancher.service.external_dns_proxied
+	// Accepts 'true' to serve the records through the proxy of the provider
+	// (Cloudflare), or 'false' (default)
+	var proxied bool
+	if label, ok := labels["io.rancher.service.external_dns_proxied"]; ok {
+		if proxied, err = strconv.ParseBool(strings.TrimSpace(label)); err != nil {
+			logrus.Errorf("Skipping service %s/%s: invalid value '%s' for proxying", stackName, serviceName, label)
+			return Entry{}, false
+		}
+	}
+
+	return Entry{
+		Fqdn:          fqdn,
+		Service:       serviceName,
+		Stack:         stackName,
+		TTL:           ttl,
+		RoutingPolicy: routingPolicy,
+		Visibility:    visibility,
+		HealthCheck:   healthCheck,
+		Proxied:       proxied,
+		// Check for Service Label: io.rancher.service.external_dns_provider
+		// Comma separated names of the provider instances to publish to, defaults to all
+		Providers: SplitLabel(labels["io.rancher.service.external_dns_provider"]),
+	}, true
+}
+
+// AddRecord adds the value to the record of the entry
+func AddRecord(entry Entry, value RecordValue, dnsEntries map[string]utils.MetadataDnsRecord) {
+	var records []string
+	key := utils.RecordKey(utils.DnsRecord{Fqdn: entry.Fqdn, Type: value.Type, Visibility: entry.Visibility})
+	if _, ok := dnsEntries[key]; !ok {
+		records = []string{value.Value}
+	} else {
+		records = dnsEntries[key].DnsRecord.Records
+		// skip if the records already have that value
+		for _, val := range records {
+			if val == value.Value {
+				return
+			}
+		}
+		records = append(records, value.Value)
+	}
+
+	// only the endpoints of address records can be checked
+	var healthCheck *utils.HealthCheck
+	if value.Type == "A" || value.Type == "AAAA" {
+		healthCheck = entry.HealthCheck
+	}
+	// only A, AAAA and CNAME records can be proxied
+	proxied := entry.Proxied && (value.Type == "A" || value.Type == "AAAA" || value.Type == "CNAME")
+
+	dnsEntries[key] = utils.MetadataDnsRecord{
+		ServiceName: entry.Service,
+		StackName:   entry.Stack,
+		Providers:   entry.Providers,
+		DnsRecord: utils.DnsRecord{
+			Fqdn:          entry.Fqdn,
+			Records:       records,
+			Type:          value.Type,
+			TTL:           entry.TTL,
+			RoutingPolicy: entry.RoutingPolicy,
+			Visibility:    entry.Visibility,
+			HealthCheck:   healthCheck,
+			Proxied:       proxied,
+		},
+	}
+}
+
+// ParseHealthCheck parses the value of a health check label
+// of the form '<protocol>:<port>[<path>]'
+func ParseHealthCheck(value string) (*utils.HealthCheck, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Invalid health check '%s'", value)
+	}
+
+	check := &utils.HealthCheck{Protocol: strings.ToUpper(parts[0])}
+	port := parts[1]
+	if idx := strings.Index(port, "/"); idx >= 0 {
+		port, check.Path = port[:idx], port[idx:]
+	}
+
+	var err error
+	if check.Port, err = strconv.Atoi(port); err != nil || check.Port < 1 || check.Port > 65535 {
+		return nil, fmt.Errorf("Invalid port in health check '%s'", value)
+	}
+
+	switch check.Protocol {
+	case "HTTP", "HTTPS":
+		if check.Path == "" {
+			check.Path = "/"
+		}
+	case "TCP":
+		if check.Path != "" {
+			return nil, fmt.Errorf("TCP health check '%s' cannot have a path", value)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid protocol in health check '%s'", value)
+	}
+
+	return check, nil
+}
+
+// ParseRoutingPolicy returns the routing policy set by the labels, if any
+func ParseRoutingPolicy(labels map[string]string) (*utils.RoutingPolicy, error) {
+	policy := &utils.RoutingPolicy{}
+	var policies int
+
+	// Check for Service Label: io.rancher.service.external_dns_weight
+	// Publishes a weighted RRSet with a weight between 0 and 255
+	if label, ok := labels["io.rancher.service.external_dns_weight"]; ok {
+		weight, err := strconv.ParseInt(strings.TrimSpace(label), 10, 64)
+		if err != nil || weight < 0 || weight > 255 {
+			return nil, fmt.Errorf("Invalid weight '%s'", label)
+		}
+		policy.Weight = &weight
+		policies++
+	}
+
+	// Check for Service Label: io.rancher.service.external_dns_region
+	// Publishes a latency based RRSet for the AWS region
+	if label, ok := labels["io.rancher.service.external_dns_region"]; ok {
+		policy.Region = strings.TrimSpace(label)
+		policies++
+	}
+
+	// Check for Service Label: io.rancher.service.external_dns_failover
+	// Publishes a failover RRSet, accepts 'primary' or 'secondary'
+	if label, ok := labels["io.rancher.service.external_dns_failover"]; ok {
+		policy.Failover = strings.ToUpper(strings.TrimSpace(label))
+		if policy.Failover != "PRIMARY" && policy.Failover != "SECONDARY" {
+			return nil, fmt.Errorf("Invalid failover role '%s'", label)
+		}
+		policies++
+	}
+
+	switch policies {
+	case 0:
+		return nil, nil
+	case 1:
+		return policy, nil
+	}
+	return nil, fmt.Errorf("Only one of weight, region and failover can be set")
+}
+
+// AddServiceRecords adds the values of a service to its records
+// unless the service is disabled, not selected or filtered
+func AddServiceRecords(labels map[string]string, serviceName, stackName, environmentName string,
+	system bool, values []RecordValue, dnsEntries map[string]utils.MetadataDnsRecord) {
+	alias, ok := servicePublished(labels, serviceName, stackName, system, values)
+	if !ok {
+		return
+	}
+
+	entry, ok := NewEntry(labels, serviceName, stackName, environmentName)
+	if !ok {
+		return
+	}
+
+	addEntryRecords(entry, alias, values, dnsEntries)
+}
+
+// AddHostRecords adds the values of a service to the records of the
+// hosts it is published under, which take the place of the name
+// template, unless the service is disabled or not selected. Hosts
+// outside of the zones or filtered are skipped.
+func AddHostRecords(labels map[string]string, hosts []string, serviceName, stackName string,
+	system bool, values []RecordValue, dnsEntries map[string]utils.MetadataDnsRecord) {
+	alias, ok := servicePublished(labels, serviceName, stackName, system, values)
+	if !ok {
+		return
+	}
+
+	for _, host := range hosts {
+		fqdn := utils.Fqdn(strings.ToLower(host))
+		if utils.ZoneForFqdn(fqdn, config.RootDomainNames) == "" {
+			logrus.Debugf("Host %s of service %s/%s is not in any of the zones", fqdn, stackName, serviceName)
+			continue
+		}
+		if !FqdnSelected(fqdn) {
+			logrus.Debugf("FQDN %s of service %s/%s is filtered", fqdn, stackName, serviceName)
+			continue
+		}
+
+		entry, ok := newHostEntry(labels, fqdn, serviceName, stackName)
+		if !ok {
+			return
+		}
+		addEntryRecords(entry, alias, values, dnsEntries)
+	}
+}
+
+// servicePublished returns the alias target of the service and true
+// unless the service is disabled, not selected or has nothing to publish
+func servicePublished(labels map[string]string, serviceName, stackName string,
+	system bool, values []RecordValue) (*utils.Alias, bool) {
+	if ServiceDisabled(labels) {
+		logrus.Debugf("Service %v is Disabled", serviceName)
+		return nil, false
+	}
+
+	alias, err := ParseAlias(labels)
+	if err != nil {
+		logrus.Errorf("Skipping service %s/%s: %v", stackName, serviceName, err)
+		return nil, false
+	}
+
+	if len(values) == 0 && alias == nil {
+		return nil, false
+	}
+
+	if !ServiceSelected(stackName, system, labels) {
+		logrus.Debugf("Service %s/%s is not selected", stackName, serviceName)
+		return nil, false
+	}
+
+	return alias, true
+}
+
+// addEntryRecords adds the alias record of the entry if
+// there is an alias target, or the values otherwise
+func addEntryRecords(entry Entry, alias *utils.Alias, values []RecordValue, dnsEntries map[string]utils.MetadataDnsRecord) {
+	if alias != nil {
+		AddAlias(entry, alias, dnsEntries)
+		return
+	}
+
+	if err := CheckCNAME(values); err != nil {
+		logrus.Errorf("Skipping service %s/%s: %v", entry.Stack, entry.Service, err)
+		return
+	}
+
+	for _, value := range values {
+		AddRecord(entry, value, dnsEntries)
+	}
+}
+
+// ParseAlias returns the alias target set by the labels, if any
+func ParseAlias(labels map[string]string) (*utils.Alias, error) {
+	// Check for Service Label: io.rancher.service.external_dns_alias
+	// Publishes the service as alias of the AWS resource with that DNS name
+	// instead of its addresses. Only supported by Route 53.
+	dnsName := strings.TrimSpace(labels["io.rancher.service.external_dns_alias"])
+	if len(dnsName) == 0 {
+		return nil, nil
+	}
+
+	alias := &utils.Alias{
+		DNSName: utils.Fqdn(strings.ToLower(dnsName)),
+		// Check for Service Label: io.rancher.service.external_dns_alias_zone_id
+		// Hosted zone ID of the alias target, detected for ELB and CloudFront if not set
+		HostedZoneId: strings.TrimSpace(labels["io.rancher.service.external_dns_alias_zone_id"]),
+	}
+
+	// Check for Service Label: io.rancher.service.external_dns_alias_evaluate_health
+	// Accepts 'true' or 'false' (default)
+	if label, ok := labels["io.rancher.service.external_dns_alias_evaluate_health"]; ok {
+		evaluate, err := strconv.ParseBool(label)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value '%s' for evaluating the target health", label)
+		}
+		alias.EvaluateTargetHealth = evaluate
+	}
+
+	return alias, nil
+}
+
+// AddAlias adds the alias record of the entry
+func AddAlias(entry Entry, alias *utils.Alias, dnsEntries map[string]utils.MetadataDnsRecord) {
+	record := utils.DnsRecord{
+		Fqdn:          entry.Fqdn,
+		Type:          "A",
+		Alias:         alias,
+		RoutingPolicy: entry.RoutingPolicy,
+		Visibility:    entry.Visibility,
+	}
+	dnsEntries[utils.RecordKey(record)] = utils.MetadataDnsRecord{
+		ServiceName: entry.Service,
+		StackName:   entry.Stack,
+		Providers:   entry.Providers,
+		DnsRecord:   record,
+	}
+}
+
+// ParseTTL parses the value of a TTL label
+func ParseTTL(value string) (int, error) {
+	ttl, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("Invalid TTL '%s': must be a positive number of seconds", value)
+	}
+	return ttl, nil
+}
+
+// SplitLabel splits a comma separated label value into a list of
+// trimmed, non-empty values
+func SplitLabel(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// AddressValue returns the IP as A or AAAA record value
+func AddressValue(ip string) (RecordValue, bool) {
+	addr := net.ParseIP(ip)
+	switch {
+	case addr == nil:
+		return RecordValue{}, false
+	case addr.To4() != nil:
+		return RecordValue{Type: "A", Value: ip}, true
+	default:
+		return RecordValue{Type: "AAAA", Value: ip}, true
+	}
+}
+
+// CheckCNAME returns an error if a CNAME value is combined with
+// other values, which DNS does not allow
+func CheckCNAME(values []RecordValue) error {
+	for _, value := range values {
+		if value.Type == "CNAME" && len(values) > 1 {
+			return fmt.Errorf("CNAME %s cannot be combined with other records", value.Value)
+		}
+	}
+	return nil
+}
+
+// IPSource returns the value of the IP source label of the service
+func IPSource(labels map[string]string) (string, error) {
+	// Check for Service Label: io.rancher.service.external_dns_ip_source
+	// Accepts 'agent_ip', 'host_label:<label>', 'container_ip', 'ports' or 'vip'.
+	// Defaults to the host label io.rancher.host.external_dns_ip, then the
+	// IP of the first port binding, then the host agent IP.
+	ipSource := strings.TrimSpace(labels["io.rancher.service.external_dns_ip_source"])
+	switch ipSource {
+	case "", "agent_ip", "container_ip", "ports", "vip":
+		return ipSource, nil
+	}
+	if strings.HasPrefix(ipSource, "host_label:") && len(ipSource) > len("host_label:") {
+		return ipSource, nil
+	}
+	return "", fmt.Errorf("Invalid IP source '%s'", ipSource)
+}
+
+// RequirePorts returns true if containers of the service are only
+// published if they have port bindings, which is the case unless the
+// policy is 'always' or the addresses do not depend on them
+func RequirePorts(labels map[string]string, ipSource string) bool {
+	return labels["io.rancher.service.external_dns"] != "always" && (ipSource == "" || ipSource == "ports")
+}
+
+// ServiceSelected returns true if the service passes the
+// configured system stack, stack name and label filters
+func ServiceSelected(stackName string, system bool, labels map[string]string) bool {
+	if config.SkipSystemStacks && system {
+		return false
+	}
+
+	if len(config.StackInclude) > 0 && !matchesAny(stackName, config.StackInclude) {
+		return false
+	}
+
+	if matchesAny(stackName, config.StackExclude) {
+		return false
+	}
+
+	return MatchesSelector(labels, config.ServiceSelector)
+}
+
+// FqdnSelected returns true if the FQDN passes the configured
+// include and exclude expressions
+func FqdnSelected(fqdn string) bool {
+	if config.FqdnInclude != nil && !config.FqdnInclude.MatchString(fqdn) {
+		return false
+	}
+
+	if config.FqdnExclude != nil && config.FqdnExclude.MatchString(fqdn) {
+		return false
+	}
+
+	return true
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesSelector returns true if the labels match all terms of the selector
+func MatchesSelector(labels map[string]string, selector []string) bool {
+	for _, term := range selector {
+		if parts := strings.SplitN(term, "!=", 2); len(parts) == 2 {
+			if value, ok := labels[parts[0]]; ok && value == parts[1] {
+				return false
+			}
+		} else if parts := strings.SplitN(term, "=", 2); len(parts) == 2 {
+			if value, ok := labels[parts[0]]; !ok || value != parts[1] {
+				return false
+			}
+		} else if strings.HasPrefix(term, "!") {
+			if _, ok := labels[strings.TrimPrefix(term, "!")]; ok {
+				return false
+			}
+		} else if _, ok := labels[term]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,324 @@
+package sources
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/rancher/external-dns/config"
+	"github.com/rancher/external-dns/utils"
+)
+
+// filters are the configured service and FQDN filters
+type filters struct {
+	stackInclude []string
+	stackExclude []string
+	selector     []string
+	fqdnInclude  *regexp.Regexp
+	fqdnExclude  *regexp.Regexp
+	skipSystem   bool
+}
+
+// setFilters replaces the configured filters and returns the previous ones
+func setFilters(f filters) filters {
+	saved := filters{config.StackInclude, config.StackExclude, config.ServiceSelector,
+		config.FqdnInclude, config.FqdnExclude, config.SkipSystemStacks}
+	config.StackInclude, config.StackExclude, config.ServiceSelector = f.stackInclude, f.stackExclude, f.selector
+	config.FqdnInclude, config.FqdnExclude, config.SkipSystemStacks = f.fqdnInclude, f.fqdnExclude, f.skipSystem
+	return saved
+}
+
+// setNames sets the configuration services are named with
+func setNames() {
+	config.NameTemplate = "%{{service_name}}.%{{stack_name}}.%{{environment_name}}"
+	config.RootDomainName = "example.com."
+	config.RootDomainNames = []string{"example.com."}
+	config.TTL = 300
+}
+
+func recordFqdns(dnsEntries map[string]utils.MetadataDnsRecord) []string {
+	var fqdns []string
+	for _, rec := range dnsEntries {
+		fqdns = append(fqdns, rec.DnsRecord.Fqdn)
+	}
+	sort.Strings(fqdns)
+	return fqdns
+}
+
+func TestAddServiceRecordsFilters(t *testing.T) {
+	setNames()
+	defer setFilters(setFilters(filters{}))
+
+	services := []struct {
+		name, stack string
+		system      bool
+		labels      map[string]string
+	}{
+		{"web", "shop", false, map[string]string{"tier": "public"}},
+		{"worker", "shop-batch", false, map[string]string{"tier": "internal", "batch": ""}},
+		{"internal", "shop", false, map[string]string{}},
+		{"network", "ipsec", true, map[string]string{}},
+	}
+
+	tests := []struct {
+		name    string
+		filters filters
+		fqdns   []string
+	}{
+		{
+			name: "no filters",
+			fqdns: []string{"internal.shop.env.example.com.", "network.ipsec.env.example.com.",
+				"web.shop.env.example.com.", "worker.shop-batch.env.example.com."},
+		},
+		{
+			name:    "system stacks skipped",
+			filters: filters{skipSystem: true, stackExclude: []string{"*-batch"}},
+			fqdns:   []string{"internal.shop.env.example.com.", "web.shop.env.example.com."},
+		},
+		{
+			name:    "stacks included",
+			filters: filters{stackInclude: []string{"shop*"}},
+			fqdns:   []string{"internal.shop.env.example.com.", "web.shop.env.example.com.", "worker.shop-batch.env.example.com."},
+		},
+		{
+			name:    "label selector",
+			filters: filters{selector: []string{"tier", "!batch"}},
+			fqdns:   []string{"web.shop.env.example.com."},
+		},
+		{
+			name:    "label value excluded",
+			filters: filters{selector: []string{"tier!=public"}, stackInclude: []string{"shop*"}},
+			fqdns:   []string{"internal.shop.env.example.com.", "worker.shop-batch.env.example.com."},
+		},
+		{
+			name:    "FQDN filters",
+			filters: filters{fqdnInclude: regexp.MustCompile(`\.shop\.`), fqdnExclude: regexp.MustCompile(`^internal\.`)},
+			fqdns:   []string{"web.shop.env.example.com."},
+		},
+	}
+
+	for _, test := range tests {
+		setFilters(test.filters)
+		dnsEntries := make(map[string]utils.MetadataDnsRecord)
+		for _, service := range services {
+			AddServiceRecords(service.labels, service.name, service.stack, "env", service.system,
+				[]RecordValue{{Type: "A", Value: "10.0.0.1"}}, dnsEntries)
+		}
+		if fqdns := recordFqdns(dnsEntries); !equalStrings(fqdns, test.fqdns) {
+			t.Errorf("%s: got records %v, want %v", test.name, fqdns, test.fqdns)
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAddServiceRecordsAlias(t *testing.T) {
+	setNames()
+	labels := map[string]string{
+		"io.rancher.service.external_dns_alias":                 "My-LB-1234.eu-west-1.elb.amazonaws.com",
+		"io.rancher.service.external_dns_alias_evaluate_health": "true",
+	}
+
+	dnsEntries := make(map[string]utils.MetadataDnsRecord)
+	// the alias replaces the addresses of the service
+	AddServiceRecords(labels, "web", "shop", "env", false, []RecordValue{{Type: "A", Value: "10.0.0.1"}}, dnsEntries)
+
+	rec, ok := dnsEntries["web.shop.env.example.com. A"]
+	if !ok || len(dnsEntries) != 1 {
+		t.Fatalf("got records %+v, want the alias record", dnsEntries)
+	}
+	want := utils.Alias{DNSName: "my-lb-1234.eu-west-1.elb.amazonaws.com.", EvaluateTargetHealth: true}
+	if rec.DnsRecord.Alias == nil || *rec.DnsRecord.Alias != want || len(rec.DnsRecord.Records) != 0 {
+		t.Errorf("got record %+v, want alias %+v", rec.DnsRecord, want)
+	}
+
+	// an alias is published even if the service has no addresses
+	dnsEntries = make(map[string]utils.MetadataDnsRecord)
+	AddServiceRecords(labels, "web", "shop", "env", false, nil, dnsEntries)
+	if len(dnsEntries) != 1 {
+		t.Errorf("got records %+v, want the alias record", dnsEntries)
+	}
+
+	labels["io.rancher.service.external_dns_alias_evaluate_health"] = "maybe"
+	dnsEntries = make(map[string]utils.MetadataDnsRecord)
+	AddServiceRecords(labels, "web", "shop", "env", false, nil, dnsEntries)
+	if len(dnsEntries) != 0 {
+		t.Errorf("got records %+v for an invalid alias", dnsEntries)
+	}
+}
+
+func TestAddServiceRecordsRoutingPolicy(t *testing.T) {
+	setNames()
+	weight := func(w int64) *int64 { return &w }
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		policy *utils.RoutingPolicy
+		// skipped is set if the service is not published
+		skipped bool
+	}{
+		{name: "simple record", labels: map[string]string{}},
+		{
+			name:   "weighted",
+			labels: map[string]string{"io.rancher.service.external_dns_weight": " 10 "},
+			policy: &utils.RoutingPolicy{Weight: weight(10)},
+		},
+		{
+			name:   "no traffic",
+			labels: map[string]string{"io.rancher.service.external_dns_weight": "0"},
+			policy: &utils.RoutingPolicy{Weight: weight(0)},
+		},
+		{
+			name:   "latency based",
+			labels: map[string]string{"io.rancher.service.external_dns_region": "eu-west-1"},
+			policy: &utils.RoutingPolicy{Region: "eu-west-1"},
+		},
+		{
+			name:   "failover",
+			labels: map[string]string{"io.rancher.service.external_dns_failover": "Secondary"},
+			policy: &utils.RoutingPolicy{Failover: "SECONDARY"},
+		},
+		{
+			name:    "weight out of range",
+			labels:  map[string]string{"io.rancher.service.external_dns_weight": "256"},
+			skipped: true,
+		},
+		{
+			name:    "invalid failover role",
+			labels:  map[string]string{"io.rancher.service.external_dns_failover": "backup"},
+			skipped: true,
+		},
+		{
+			name: "several policies",
+			labels: map[string]string{
+				"io.rancher.service.external_dns_weight": "10",
+				"io.rancher.service.external_dns_region": "eu-west-1",
+			},
+			skipped: true,
+		},
+	}
+
+	for _, test := range tests {
+		dnsEntries := make(map[string]utils.MetadataDnsRecord)
+		AddServiceRecords(test.labels, "web", "shop", "env", false,
+			[]RecordValue{{Type: "A", Value: "10.0.0.1"}, {Type: "A", Value: "10.0.0.2"}}, dnsEntries)
+
+		rec, ok := dnsEntries["web.shop.env.example.com. A"]
+		if test.skipped {
+			if len(dnsEntries) != 0 {
+				t.Errorf("%s: got records %+v, want none", test.name, dnsEntries)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%s: got records %+v, want web.shop.env.example.com. A", test.name, dnsEntries)
+			continue
+		}
+		if !reflect.DeepEqual(rec.DnsRecord.RoutingPolicy, test.policy) || len(rec.DnsRecord.Records) != 2 {
+			t.Errorf("%s: got record %+v with policy %+v, want policy %+v",
+				test.name, rec.DnsRecord, rec.DnsRecord.RoutingPolicy, test.policy)
+		}
+	}
+}
+
+func TestAddServiceRecordsHealthCheck(t *testing.T) {
+	setNames()
+
+	tests := []struct {
+		label  string
+		values []RecordValue
+		check  *utils.HealthCheck
+		// skipped is set if the service is not published
+		skipped bool
+	}{
+		{
+			label:  "http:8080",
+			values: []RecordValue{{Type: "A", Value: "10.0.0.1"}},
+			check:  &utils.HealthCheck{Protocol: "HTTP", Port: 8080, Path: "/"},
+		},
+		{
+			label:  "HTTPS:8443/healthz",
+			values: []RecordValue{{Type: "AAAA", Value: "2001:db8::1"}},
+			check:  &utils.HealthCheck{Protocol: "HTTPS", Port: 8443, Path: "/healthz"},
+		},
+		{
+			label:  " tcp:5432 ",
+			values: []RecordValue{{Type: "A", Value: "10.0.0.1"}},
+			check:  &utils.HealthCheck{Protocol: "TCP", Port: 5432},
+		},
+		{
+			// the endpoints of a CNAME record are not checked
+			label:  "http:80",
+			values: []RecordValue{{Type: "CNAME", Value: "origin.example.net."}},
+		},
+		{label: "tcp:5432/ping", values: []RecordValue{{Type: "A", Value: "10.0.0.1"}}, skipped: true},
+		{label: "udp:53", values: []RecordValue{{Type: "A", Value: "10.0.0.1"}}, skipped: true},
+		{label: "http:65536", values: []RecordValue{{Type: "A", Value: "10.0.0.1"}}, skipped: true},
+	}
+
+	for _, test := range tests {
+		labels := map[string]string{"io.rancher.service.external_dns_health_check": test.label}
+		dnsEntries := make(map[string]utils.MetadataDnsRecord)
+		AddServiceRecords(labels, "web", "shop", "env", false, test.values, dnsEntries)
+
+		if test.skipped {
+			if len(dnsEntries) != 0 {
+				t.Errorf("%q: got records %+v, want none", test.label, dnsEntries)
+			}
+			continue
+		}
+		if len(dnsEntries) != 1 {
+			t.Errorf("%q: got records %+v, want one", test.label, dnsEntries)
+			continue
+		}
+		for _, rec := range dnsEntries {
+			if !reflect.DeepEqual(rec.DnsRecord.HealthCheck, test.check) {
+				t.Errorf("%q: got health check %+v, want %+v", test.label, rec.DnsRecord.HealthCheck, test.check)
+			}
+		}
+	}
+}
+
+func TestAddHostRecords(t *testing.T) {
+	setNames()
+	defer setFilters(setFilters(filters{fqdnExclude: regexp.MustCompile(`^internal\.`)}))
+
+	labels := map[string]string{
+		"io.rancher.service.external_dns_ttl":        "60",
+		"io.rancher.service.external_dns_visibility": "private",
+		"io.rancher.service.external_dns_proxied":    "true",
+	}
+	hosts := []string{"WWW.example.com", "api.example.com.", "internal.example.com", "www.example.net"}
+	dnsEntries := make(map[string]utils.MetadataDnsRecord)
+	AddHostRecords(labels, hosts, "web", "shop", false, []RecordValue{{Type: "A", Value: "10.0.0.1"}}, dnsEntries)
+
+	// hosts outside of the zones and filtered hosts are skipped
+	want := []string{"api.example.com.", "www.example.com."}
+	if fqdns := recordFqdns(dnsEntries); !equalStrings(fqdns, want) {
+		t.Errorf("got FQDNs %v, want %v", fqdns, want)
+	}
+	for _, rec := range dnsEntries {
+		if rec.DnsRecord.TTL != 60 || rec.DnsRecord.Visibility != utils.VisibilityPrivate || !rec.DnsRecord.Proxied {
+			t.Errorf("got record %+v, want the settings of the labels", rec.DnsRecord)
+		}
+	}
+
+	dnsEntries = make(map[string]utils.MetadataDnsRecord)
+	labels = map[string]string{"io.rancher.service.external_dns": "never"}
+	AddHostRecords(labels, hosts, "web", "shop", false, []RecordValue{{Type: "A", Value: "10.0.0.1"}}, dnsEntries)
+	if len(dnsEntries) != 0 {
+		t.Errorf("disabled service got records %+v", dnsEntries)
+	}
+}
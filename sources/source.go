@@ -0,0 +1,49 @@
+package sources
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/external-dns/utils"
+)
+
+// Source provides the DNS records that should be published
+type Source interface {
+	Init() error
+	GetName() string
+	HealthCheck() error
+	// GetVersion returns a value that changes when the records may have changed
+	GetVersion() (string, error)
+	// GetEnvironment returns the name and UUID of the environment
+	// the records belong to. The UUID identifies the owner of the
+	// records in the state RRSets.
+	GetEnvironment() (string, string)
+	// GetDnsRecords returns the records keyed by utils.RecordKey
+	GetDnsRecords() (map[string]utils.MetadataDnsRecord, error)
+}
+
+// Factory returns a new, uninitialized source
+type Factory func() Source
+
+var (
+	sources = make(map[string]Factory)
+)
+
+// GetSource returns a new, initialized instance of the named source
+func GetSource(name string) (Source, error) {
+	if factory, ok := sources[name]; ok {
+		source := factory()
+		if err := source.Init(); err != nil {
+			return nil, err
+		}
+		return source, nil
+	}
+	return nil, fmt.Errorf("No such source '%s'", name)
+}
+
+func RegisterSource(name string, factory Factory) {
+	if _, exists := sources[name]; exists {
+		logrus.Fatalf("Source '%s' tried to register twice", name)
+	}
+	sources[name] = factory
+}
@@ -0,0 +1,164 @@
+package sources
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/external-dns/config"
+	"github.com/rancher/external-dns/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// StaticRecord is a record declared in the static records file
+// or in the metadata of the source
+type StaticRecord struct {
+	// Name is a FQDN in one of the root domains, or a name
+	// relative to the default root domain. '@' is the root domain.
+	Name      string   `yaml:"name"`
+	Type      string   `yaml:"type"`
+	TTL       int      `yaml:"ttl"`
+	Values    []string `yaml:"values"`
+	Providers []string `yaml:"providers"`
+}
+
+type staticRecordsFile struct {
+	Records []StaticRecord `yaml:"records"`
+}
+
+// ReadStaticRecordsFile returns the records declared in the
+// static records file, if one is configured
+func ReadStaticRecordsFile() ([]StaticRecord, error) {
+	if len(config.StaticRecordsFile) == 0 {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(config.StaticRecordsFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read static records: %v", err)
+	}
+	var file staticRecordsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("Failed to parse static records file %s: %v", config.StaticRecordsFile, err)
+	}
+	return file.Records, nil
+}
+
+// ParseStaticRecords returns the records declared in a generic
+// value such as service metadata
+func ParseStaticRecords(value interface{}) ([]StaticRecord, error) {
+	// convert the value through its YAML representation
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var records []StaticRecord
+	if err := yaml.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// AddStaticRecords adds the static records
+func AddStaticRecords(records []StaticRecord, dnsEntries map[string]utils.MetadataDnsRecord) {
+	for _, record := range records {
+		rec, err := parseStaticRecord(record)
+		if err != nil {
+			logrus.Errorf("Skipping static record %s %s: %v", record.Name, record.Type, err)
+			continue
+		}
+		entry := Entry{Fqdn: rec.Fqdn, TTL: rec.TTL, Providers: record.Providers}
+		for _, value := range rec.Records {
+			AddRecord(entry, RecordValue{Type: rec.Type, Value: value}, dnsEntries)
+		}
+	}
+}
+
+// parseStaticRecord validates the static record and
+// returns it as DNS record
+func parseStaticRecord(record StaticRecord) (utils.DnsRecord, error) {
+	rec := utils.DnsRecord{
+		Fqdn: staticFqdn(record.Name),
+		Type: strings.ToUpper(record.Type),
+		TTL:  record.TTL,
+	}
+
+	if len(record.Name) == 0 {
+		return rec, fmt.Errorf("Name is not set")
+	}
+	if rec.TTL == 0 {
+		rec.TTL = config.TTL
+	} else if rec.TTL < 0 {
+		return rec, fmt.Errorf("Invalid TTL %d", rec.TTL)
+	}
+	if len(record.Values) == 0 {
+		return rec, fmt.Errorf("No values")
+	}
+	if rec.Type == "CNAME" && len(record.Values) > 1 {
+		return rec, fmt.Errorf("CNAME records must have a single value")
+	}
+
+	for _, value := range record.Values {
+		value, err := parseStaticValue(rec.Type, strings.TrimSpace(value))
+		if err != nil {
+			return rec, err
+		}
+		rec.Records = append(rec.Records, value)
+	}
+
+	return rec, nil
+}
+
+// parseStaticValue validates the value of a static record of the given
+// type and returns it with host names as FQDN
+func parseStaticValue(recordType, value string) (string, error) {
+	switch recordType {
+	case "A":
+		if ip := net.ParseIP(value); ip == nil || ip.To4() == nil {
+			return "", fmt.Errorf("Invalid IPv4 address '%s'", value)
+		}
+	case "AAAA":
+		if ip := net.ParseIP(value); ip == nil || ip.To4() != nil {
+			return "", fmt.Errorf("Invalid IPv6 address '%s'", value)
+		}
+	case "CNAME":
+		value = utils.Fqdn(strings.ToLower(value))
+	case "TXT":
+	case "MX":
+		// expects 'preference host'
+		fields := strings.Fields(value)
+		if len(fields) != 2 || !isUint16(fields[0]) {
+			return "", fmt.Errorf("Invalid MX value '%s'", value)
+		}
+		value = fields[0] + " " + utils.Fqdn(strings.ToLower(fields[1]))
+	case "SRV":
+		// expects 'priority weight port target'
+		fields := strings.Fields(value)
+		if len(fields) != 4 || !isUint16(fields[0]) || !isUint16(fields[1]) || !isUint16(fields[2]) {
+			return "", fmt.Errorf("Invalid SRV value '%s'", value)
+		}
+		value = strings.Join(append(fields[:3], utils.Fqdn(strings.ToLower(fields[3]))), " ")
+	default:
+		return "", fmt.Errorf("Unsupported record type")
+	}
+	return value, nil
+}
+
+// staticFqdn returns the FQDN of a static record name
+func staticFqdn(name string) string {
+	if name == "@" {
+		return utils.Fqdn(config.RootDomainName)
+	}
+	fqdn := utils.Fqdn(strings.ToLower(name))
+	if utils.ZoneForFqdn(fqdn, config.RootDomainNames) != "" {
+		return fqdn
+	}
+	return fqdn + utils.Fqdn(config.RootDomainName)
+}
+
+func isUint16(value string) bool {
+	_, err := strconv.ParseUint(value, 10, 16)
+	return err == nil
+}
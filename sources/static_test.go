@@ -0,0 +1,116 @@
+package sources
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rancher/external-dns/config"
+	"github.com/rancher/external-dns/utils"
+)
+
+func TestParseStaticRecord(t *testing.T) {
+	defer func(root string, roots []string, ttl int) {
+		config.RootDomainName, config.RootDomainNames, config.TTL = root, roots, ttl
+	}(config.RootDomainName, config.RootDomainNames, config.TTL)
+	config.RootDomainName = "example.com."
+	config.RootDomainNames = []string{"example.com.", "example.org."}
+	config.TTL = 300
+
+	tests := []struct {
+		name   string
+		record StaticRecord
+		want   utils.DnsRecord
+		err    bool
+	}{
+		{
+			name:   "relative name with the default TTL",
+			record: StaticRecord{Name: "WWW", Type: "a", Values: []string{" 10.0.0.1 "}},
+			want:   utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 300, Records: []string{"10.0.0.1"}},
+		},
+		{
+			name:   "root domain",
+			record: StaticRecord{Name: "@", Type: "TXT", TTL: 60, Values: []string{"v=spf1 -all"}},
+			want:   utils.DnsRecord{Fqdn: "example.com.", Type: "TXT", TTL: 60, Records: []string{"v=spf1 -all"}},
+		},
+		{
+			name:   "FQDN in another root domain",
+			record: StaticRecord{Name: "www.example.org", Type: "AAAA", Values: []string{"2001:db8::1"}},
+			want:   utils.DnsRecord{Fqdn: "www.example.org.", Type: "AAAA", TTL: 300, Records: []string{"2001:db8::1"}},
+		},
+		{
+			name:   "CNAME",
+			record: StaticRecord{Name: "docs", Type: "CNAME", Values: []string{"Pages.Example.net"}},
+			want:   utils.DnsRecord{Fqdn: "docs.example.com.", Type: "CNAME", TTL: 300, Records: []string{"pages.example.net."}},
+		},
+		{
+			name:   "MX",
+			record: StaticRecord{Name: "@", Type: "MX", Values: []string{"10  Mail.example.com", "20 backup.example.com."}},
+			want: utils.DnsRecord{Fqdn: "example.com.", Type: "MX", TTL: 300,
+				Records: []string{"10 mail.example.com.", "20 backup.example.com."}},
+		},
+		{
+			name:   "SRV",
+			record: StaticRecord{Name: "_sip._tcp", Type: "SRV", Values: []string{"10 5 5060 SIP.example.com"}},
+			want: utils.DnsRecord{Fqdn: "_sip._tcp.example.com.", Type: "SRV", TTL: 300,
+				Records: []string{"10 5 5060 sip.example.com."}},
+		},
+		{name: "no name", record: StaticRecord{Type: "A", Values: []string{"10.0.0.1"}}, err: true},
+		{name: "negative TTL", record: StaticRecord{Name: "www", Type: "A", TTL: -1, Values: []string{"10.0.0.1"}}, err: true},
+		{name: "no values", record: StaticRecord{Name: "www", Type: "A"}, err: true},
+		{name: "several CNAME values", record: StaticRecord{Name: "www", Type: "CNAME", Values: []string{"a.example.net", "b.example.net"}}, err: true},
+		{name: "IPv6 address in A record", record: StaticRecord{Name: "www", Type: "A", Values: []string{"2001:db8::1"}}, err: true},
+		{name: "IPv4 address in AAAA record", record: StaticRecord{Name: "www", Type: "AAAA", Values: []string{"10.0.0.1"}}, err: true},
+		{name: "MX without preference", record: StaticRecord{Name: "@", Type: "MX", Values: []string{"mail.example.com"}}, err: true},
+		{name: "SRV with port out of range", record: StaticRecord{Name: "_sip._tcp", Type: "SRV", Values: []string{"10 5 70000 sip.example.com"}}, err: true},
+		{name: "unsupported type", record: StaticRecord{Name: "www", Type: "PTR", Values: []string{"host.example.com"}}, err: true},
+	}
+
+	for _, test := range tests {
+		rec, err := parseStaticRecord(test.record)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %+v", test.name, rec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(rec, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, rec, test.want)
+		}
+	}
+}
+
+func TestAddStaticRecords(t *testing.T) {
+	setNames()
+
+	// static records as read from the metadata of the service
+	metadata := []interface{}{
+		map[interface{}]interface{}{"name": "@", "type": "MX", "values": []interface{}{"10 mail.example.com"}},
+		map[interface{}]interface{}{"name": "www", "type": "A", "ttl": 60,
+			"values": []interface{}{"10.0.0.1", "10.0.0.2"}, "providers": []interface{}{"internal"}},
+		map[interface{}]interface{}{"name": "broken", "type": "A", "values": []interface{}{"not-an-ip"}},
+	}
+	records, err := ParseStaticRecords(metadata)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dnsEntries := make(map[string]utils.MetadataDnsRecord)
+	AddStaticRecords(records, dnsEntries)
+
+	want := map[string]utils.MetadataDnsRecord{
+		"example.com. MX": {
+			DnsRecord: utils.DnsRecord{Fqdn: "example.com.", Type: "MX", TTL: 300, Records: []string{"10 mail.example.com."}},
+		},
+		"www.example.com. A": {
+			Providers: []string{"internal"},
+			DnsRecord: utils.DnsRecord{Fqdn: "www.example.com.", Type: "A", TTL: 60, Records: []string{"10.0.0.1", "10.0.0.2"}},
+		},
+	}
+	if !reflect.DeepEqual(dnsEntries, want) {
+		t.Errorf("got records %+v, want %+v", dnsEntries, want)
+	}
+}
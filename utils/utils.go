@@ -12,7 +12,12 @@ import (
 )
 
 const (
-	stateRecordFqdnTemplate = "external-dns-%s.%s"
+	stateRecordFqdnTemplate         = "external-dns-%s.%s"
+	instanceStateRecordFqdnTemplate = "%s.external-dns-%s.%s"
+
+	// Visibilities of records published to split-horizon zones
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
 )
 
 // MetadataDnsRecord is a wrapper around a DnsRecord
@@ -21,7 +26,10 @@ const (
 type MetadataDnsRecord struct {
 	ServiceName string
 	StackName   string
-	DnsRecord   DnsRecord
+	// Providers are the names of the provider instances
+	// the record is published to. Empty means all.
+	Providers []string
+	DnsRecord DnsRecord
 }
 
 // DnsRecord represents a provider DNS record
@@ -30,6 +38,51 @@ type DnsRecord struct {
 	Records []string
 	Type    string
 	TTL     int
+	// Alias is set for alias records, which have no values
+	Alias *Alias
+	// SetIdentifier distinguishes RRSets of the same name
+	// and type that are selected by a routing policy
+	SetIdentifier string
+	RoutingPolicy *RoutingPolicy
+	// Visibility restricts the record to the public or the private
+	// zone of a split-horizon domain. Empty means both.
+	Visibility string
+	// HealthCheck is set if each value of an address record is
+	// to be published only while its endpoint passes the check
+	HealthCheck *HealthCheck
+	// Proxied is set if the record is served through the proxy
+	// of the provider instead of resolving to its values
+	Proxied bool
+}
+
+// HealthCheck of the endpoints of an address record
+type HealthCheck struct {
+	// Protocol is 'HTTP', 'HTTPS' or 'TCP'
+	Protocol string
+	Port     int
+	// Path requested by HTTP and HTTPS checks
+	Path string
+}
+
+// RoutingPolicy selects between the RRSets sharing a name and type.
+// Exactly one of the policies is set.
+type RoutingPolicy struct {
+	// Weight of the RRSet for weighted routing
+	Weight *int64
+	// Region of the RRSet for latency based routing
+	Region string
+	// Failover is 'PRIMARY' or 'SECONDARY' for failover routing
+	Failover string
+}
+
+// Alias is the target of an alias record, a Route 53
+// extension resolving to the addresses of an AWS resource
+type Alias struct {
+	DNSName string
+	// HostedZoneId of the target. Empty if it is to be
+	// detected from the DNS name.
+	HostedZoneId         string
+	EvaluateTargetHealth bool
 }
 
 // Fqdn ensures that the name is a fqdn adding a trailing dot if necessary.
@@ -51,33 +104,90 @@ func UnFqdn(name string) string {
 }
 
 func FqdnFromTemplate(template, serviceName, stackName, environmentName, rootDomainName string) string {
+	return FqdnFromTemplateValues(template, map[string]string{
+		"service_name":     serviceName,
+		"stack_name":       stackName,
+		"environment_name": environmentName,
+	}, rootDomainName)
+}
+
+// FqdnFromTemplateValues returns the FQDN in the root domain built from
+// the template with its placeholders replaced by the given values.
+func FqdnFromTemplateValues(template string, values map[string]string, rootDomainName string) string {
 	t, err := fasttemplate.NewTemplate(template, "%{{", "}}")
 	if err != nil {
 		logrus.Fatalf("error while parsing fqdn template: %s", err)
 	}
 
 	fqdn := t.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
-		switch tag {
-		case "service_name":
-			return w.Write([]byte(sanitizeLabel(serviceName)))
-		case "stack_name":
-			return w.Write([]byte(sanitizeLabel(stackName)))
-		case "environment_name":
-			return w.Write([]byte(sanitizeLabel(environmentName)))
-		default:
+		value, ok := values[tag]
+		if !ok {
 			return 0, fmt.Errorf("invalid placeholder '%q' in fqdn template", tag)
 		}
+		return w.Write([]byte(sanitizeLabel(value)))
 	})
 
 	labels := []string{fqdn, rootDomainName}
 	return strings.ToLower(strings.Join(labels, "."))
 }
 
-func StateFqdn(environmentUUID, rootDomainName string) string {
+// ZoneForFqdn returns the zone with the longest name that the FQDN
+// belongs to, or an empty string if none of the zones match.
+func ZoneForFqdn(fqdn string, zones []string) string {
+	fqdn = strings.ToLower(Fqdn(fqdn))
+	var match string
+	for _, zone := range zones {
+		name := strings.ToLower(Fqdn(zone))
+		if fqdn != name && !strings.HasSuffix(fqdn, "."+name) {
+			continue
+		}
+		if len(name) > len(match) {
+			match = zone
+		}
+	}
+	return match
+}
+
+// RecordKey returns the key identifying the RRSet of the record
+func RecordKey(record DnsRecord) string {
+	key := record.Fqdn + " " + record.Type
+	if record.SetIdentifier != "" {
+		key += " " + record.SetIdentifier
+	}
+	if record.Visibility != "" {
+		key += " " + record.Visibility
+	}
+	return key
+}
+
+// StateFqdn returns the FQDN of the state RRSet of the environment in
+// the root domain. The state RRSets of named provider instances have
+// the name of the instance prepended so that instances sharing a zone
+// each own their records.
+func StateFqdn(environmentUUID, instanceName, rootDomainName string) string {
 	fqdn := fmt.Sprintf(stateRecordFqdnTemplate, environmentUUID, rootDomainName)
+	if instanceName != "" {
+		fqdn = fmt.Sprintf(instanceStateRecordFqdnTemplate, sanitizeLabel(instanceName), environmentUUID, rootDomainName)
+	}
 	return strings.ToLower(fqdn)
 }
 
+// StateEntry returns the value of a state RRSet that
+// claims the RRSets of the given FQDN and type
+func StateEntry(fqdn, recordType string) string {
+	return recordType + ":" + fqdn
+}
+
+// ParseStateEntry returns the FQDN and type of the RRSets claimed
+// by the value of a state RRSet. Values written before the type
+// was recorded only claim A records.
+func ParseStateEntry(value string) (string, string) {
+	if idx := strings.Index(value, ":"); idx > 0 {
+		return value[idx+1:], value[:idx]
+	}
+	return value, "A"
+}
+
 func StateRecord(fqdn string, ttl int, entries map[string]struct{}) DnsRecord {
 	records := make([]string, len(entries))
 	idx := 0
@@ -86,7 +196,7 @@ func StateRecord(fqdn string, ttl int, entries map[string]struct{}) DnsRecord {
 		idx++
 	}
 	sort.Strings(records)
-	return DnsRecord{fqdn, records, "TXT", ttl}
+	return DnsRecord{Fqdn: fqdn, Records: records, Type: "TXT", TTL: ttl}
 }
 
 // sanitizeLabel replaces characters that are not allowed in DNS labels with dashes.
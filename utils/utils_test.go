@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestZoneForFqdn(t *testing.T) {
+	// zones as configured in ROOT_DOMAIN, including a zone
+	// delegated from another one
+	zones := []string{"example.com.", "internal.example.com.", "example.org."}
+
+	tests := []struct {
+		fqdn string
+		zone string
+	}{
+		{"web.stack.env.example.com.", "example.com."},
+		{"web.stack.env.internal.example.com.", "internal.example.com."},
+		{"internal.example.com.", "internal.example.com."},
+		{"example.org", "example.org."},
+		{"Web.Example.ORG.", "example.org."},
+		{"web.notexample.com.", ""},
+		{"web.example.net.", ""},
+	}
+
+	for _, test := range tests {
+		if zone := ZoneForFqdn(test.fqdn, zones); zone != test.zone {
+			t.Errorf("ZoneForFqdn(%q) = %q, want %q", test.fqdn, zone, test.zone)
+		}
+	}
+}
+
+func TestStateFqdn(t *testing.T) {
+	tests := []struct {
+		instanceName string
+		fqdn         string
+	}{
+		{"", "external-dns-uuid.example.com."},
+		{"internal", "internal.external-dns-uuid.example.com."},
+		{"Internal_DNS", "internal-dns.external-dns-uuid.example.com."},
+	}
+
+	for _, test := range tests {
+		if fqdn := StateFqdn("uuid", test.instanceName, "example.com."); fqdn != test.fqdn {
+			t.Errorf("StateFqdn(%q) = %q, want %q", test.instanceName, fqdn, test.fqdn)
+		}
+	}
+}